@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"shop-api/config"
+	"shop-api/handlers"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/openapi"
+	"shop-api/problem"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// accessProbe sends requests to the app and reports why the middleware
+// turned them away
+type accessProbe struct {
+	t   *testing.T
+	api *App
+}
+
+// denial returns the detail of a 401 or 403 answered by the middleware, or ""
+// when the request got through to the handler
+func (p accessProbe) denial(method, path string, header http.Header) string {
+	p.t.Helper()
+
+	r := httptest.NewRequest(method, config.APIPrefix+path, strings.NewReader("{}"))
+	r.Header = header
+	w := httptest.NewRecorder()
+	p.api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+		return ""
+	}
+	var body problem.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		p.t.Fatalf("%s %s: %d with a body that is not a problem: %s", method, path, w.Code, w.Body.String())
+	}
+	return body.Detail
+}
+
+func (p accessProbe) login(email, password string) string {
+	p.t.Helper()
+
+	body, _ := json.Marshal(handlers.LoginRequest{Email: email, Password: password})
+	r := httptest.NewRequest(http.MethodPost, config.APIPrefix+"/login", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	p.api.Router.ServeHTTP(w, r)
+
+	var response handlers.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Token == "" {
+		p.t.Fatalf("login as %s: %d %s", email, w.Code, w.Body.String())
+	}
+	return response.Token
+}
+
+func (p accessProbe) apiKey(scopes ...models.Scope) http.Header {
+	p.t.Helper()
+
+	_, key, err := p.api.APIKeys.Create(1, "probe", scopes, 1)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(middleware.APIKeyHeader, key)
+	return header
+}
+
+// Each documented operation must be exactly as open as its route: Public
+// routes take no credentials, only SuperAdmin routes refuse an Admin, and
+// API keys need every documented scope (and admin on SuperAdmin routes)
+func TestOpenAPIAccessMatchesRoutes(t *testing.T) {
+	previousCost := config.PasswordBcryptCost
+	config.PasswordBcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { config.PasswordBcryptCost = previousCost })
+
+	api := newTestApp(t)
+	middleware.UseRateLimits(nil)
+	probe := accessProbe{t: t, api: api}
+	admin := http.Header{"Authorization": {"Bearer " + probe.login("admin@shop1.com", "admin123")}}
+
+	// Messages the middleware answers with, as opposed to the handlers
+	const (
+		noCredentials  = "authorization header required"
+		superAdminOnly = "super admin access required"
+		usersOnly      = "api keys cannot call this route"
+	)
+
+	for _, op := range handlers.Operations() {
+		t.Run(op.Method+" "+op.Path, func(t *testing.T) {
+			probe.t = t
+			path := pathParam.ReplaceAllString(op.Path, "999999")
+
+			denial := probe.denial(op.Method, path, http.Header{})
+			if op.Access == openapi.Public {
+				if denial != "" {
+					t.Fatalf("documented as public, refused without credentials: %s", denial)
+				}
+				return
+			}
+			if denial != noCredentials {
+				t.Fatalf("documented as private, answered %q without credentials", denial)
+			}
+
+			denial = probe.denial(op.Method, path, admin)
+			if op.Access == openapi.SuperAdmin && denial != superAdminOnly {
+				t.Errorf("documented as SuperAdmin only, answered %q to an Admin", denial)
+			}
+			if op.Access != openapi.SuperAdmin && denial != "" {
+				t.Errorf("documented as open to Admins, refused one: %s", denial)
+			}
+
+			if len(op.Scopes) == 0 {
+				if denial := probe.denial(op.Method, path, probe.apiKey(models.ValidScopes...)); denial != usersOnly {
+					t.Errorf("documented without scopes, answered %q to a key holding every scope", denial)
+				}
+				return
+			}
+
+			scopes := op.Scopes
+			if op.Access == openapi.SuperAdmin {
+				scopes = append(slices.Clip(scopes), models.ScopeAdmin)
+			}
+			if denial := probe.denial(op.Method, path, probe.apiKey(scopes...)); denial != "" {
+				t.Errorf("refused a key holding the documented scopes %v: %s", scopes, denial)
+			}
+			for i, missing := range scopes {
+				others := slices.Delete(slices.Clone(scopes), i, i+1)
+				if len(others) == 0 {
+					others = []models.Scope{otherScope(missing)}
+				}
+				want := "api key lacks the " + string(missing) + " scope"
+				if denial := probe.denial(op.Method, path, probe.apiKey(others...)); denial != want {
+					t.Errorf("answered %q to a key without %s, want %q", denial, missing, want)
+				}
+			}
+		})
+	}
+}
+
+// otherScope returns a scope other than scope, for a key that must lack it
+func otherScope(scope models.Scope) models.Scope {
+	if scope == models.ScopeShopsRead {
+		return models.ScopeProductsRead
+	}
+	return models.ScopeShopsRead
+}
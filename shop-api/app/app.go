@@ -0,0 +1,185 @@
+// Package app wires the services, handlers and routes of the API, so the
+// server and the tests run the same router
+package app
+
+import (
+	"fmt"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/handlers"
+	"shop-api/ledger"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/openapi"
+	"shop-api/router"
+	"shop-api/services"
+)
+
+// App is the API: its stores, and the router serving them with the OpenAPI
+// document describing it
+type App struct {
+	Router *router.Router
+	Spec   *openapi.Document
+
+	Shops        services.ShopService
+	Memberships  services.MembershipService
+	Users        services.UserService
+	APIKeys      services.APIKeyService
+	Products     services.ProductService
+	Transactions services.TransactionService
+	Audit        services.AuditService
+	Reports      services.ReportService
+}
+
+// New creates the stores, subscribes the reports to the event bus and
+// registers every route. The middleware reaches the stores through
+// package-level hooks, so only one App serves at a time.
+func New(eventBus *events.Bus, checkpointSigner *ledger.Signer) (*App, error) {
+	// Initialize services
+	shopService := services.NewShopService(eventBus)
+	membershipService := services.NewMembershipService()
+	loginAttemptService := services.NewLoginAttemptService()
+	userService := services.NewUserService(membershipService, loginAttemptService)
+	apiKeyService := services.NewAPIKeyService()
+	idempotencyService := services.NewIdempotencyService()
+	rateLimitService := services.NewRateLimitService()
+	productService := services.NewProductService(eventBus)
+	transactionService := services.NewTransactionService(productService, checkpointSigner, eventBus)
+	auditService := services.NewAuditService()
+	reportService := services.NewReportService()
+
+	// Event subscribers: reports follow sales and stock changes. Their names
+	// identify their deliveries in the outbox, so they must not change.
+	events.On(eventBus, "reports", reportService.ApplySale)
+	events.On(eventBus, "low-stock", reportService.ApplyStockChange)
+
+	// Initialize handlers
+	authHandler := handlers.NewAuthHandler(userService, shopService, membershipService, auditService)
+	productHandler := handlers.NewProductHandler(productService, shopService, auditService)
+	transactionHandler := handlers.NewTransactionHandler(transactionService, auditService)
+	shopHandler := handlers.NewShopHandler(shopService, userService, membershipService, auditService)
+	userHandler := handlers.NewUserHandler(userService, membershipService, auditService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, auditService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	reportHandler := handlers.NewReportHandler(reportService)
+
+	// Tokens are only honoured while the account is active and the user
+	// still belongs to the token's shop
+	middleware.UseUsers(userService)
+	middleware.UseMemberships(membershipService)
+	middleware.UseShops(shopService)
+	middleware.UseAPIKeys(apiKeyService)
+
+	// Creates wrapped with middleware.Idempotent replay their first response
+	// when retried with the same Idempotency-Key
+	middleware.UseIdempotency(idempotencyService)
+
+	// Token-bucket rate limits: per IP on public routes, per user or API key
+	// on private ones (see config.RateLimit*)
+	middleware.UseRateLimits(rateLimitService)
+
+	// Setup routes: every route is served under /api/v1, and at its original
+	// unversioned path as a deprecated alias
+	r := router.New()
+
+	// Auth routes (public)
+	r.Handle("POST", "/register", middleware.RateLimited(config.RateLimitPublic, authHandler.Register))
+	r.Handle("POST", "/login", middleware.RateLimited(config.RateLimitAuth, authHandler.Login))
+	r.Handle("POST", "/login/2fa", middleware.RateLimited(config.RateLimitAuth, authHandler.LoginTwoFactor))
+	r.Handle("POST", "/password/reset", middleware.RateLimited(config.RateLimitAuth, userHandler.ResetPassword))
+
+	// Public routes (no auth required)
+	r.Handle("GET", "/public/{shopID}/products", middleware.RateLimited(config.RateLimitPublic, productHandler.GetPublicProducts))
+
+	// Single sign-on routes (public, only when an identity provider is configured)
+	if config.OIDCIssuerURL != "" {
+		oidcService, err := services.NewOIDCService(userService, membershipService)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OIDC configuration: %w", err)
+		}
+		oidcHandler := handlers.NewOIDCHandler(oidcService)
+
+		r.Handle("GET", "/auth/oidc/login", middleware.RateLimited(config.RateLimitAuth, oidcHandler.Login))
+		r.Handle("GET", "/auth/oidc/callback", middleware.RateLimited(config.RateLimitAuth, oidcHandler.Callback))
+	}
+
+	// Private routes take the scopes an API key needs to call them; routes
+	// without scopes only accept users. SuperAdmin routes also need the admin scope.
+
+	// Product routes (private - requires auth)
+	r.Handle("GET", "/products", middleware.AuthMiddleware(productHandler.GetAll, models.ScopeProductsRead))
+	r.Handle("POST", "/products", middleware.AuthMiddleware(middleware.Idempotent(productHandler.Create), models.ScopeProductsWrite))
+	r.Handle("GET", "/products/{id}", middleware.AuthMiddleware(productHandler.Get, models.ScopeProductsRead))
+	r.Handle("PUT", "/products/{id}", middleware.AuthMiddleware(productHandler.Update, models.ScopeProductsWrite))
+	r.Handle("PATCH", "/products/{id}", middleware.AuthMiddleware(productHandler.Patch, models.ScopeProductsWrite))
+	r.Handle("DELETE", "/products/{id}", middleware.AuthMiddleware(productHandler.Delete, models.ScopeProductsWrite))
+
+	// Transaction routes (private - requires admin)
+	r.Handle("GET", "/transactions", middleware.RequireAdmin(transactionHandler.GetAll, models.ScopeTransactionsRead))
+	r.Handle("POST", "/transactions", middleware.RequireAdmin(middleware.Idempotent(transactionHandler.Create), models.ScopeTransactionsWrite))
+	r.Handle("GET", "/reports/low-stock", middleware.RequireAdmin(reportHandler.GetLowStock, models.ScopeProductsRead))
+
+	// Dashboard and ledger routes (SuperAdmin only)
+	r.Handle("GET", "/reports/dashboard", middleware.RequireSuperAdmin(transactionHandler.GetDashboard, models.ScopeReportsRead))
+	r.Handle("GET", "/reports/sales", middleware.RequireSuperAdmin(reportHandler.GetDailySales, models.ScopeReportsRead))
+	r.Handle("GET", "/transactions/verify", middleware.RequireSuperAdmin(transactionHandler.VerifyChain, models.ScopeTransactionsRead))
+	r.Handle("GET", "/transactions/checkpoints", middleware.RequireSuperAdmin(transactionHandler.GetCheckpoints, models.ScopeTransactionsRead))
+	r.Handle("POST", "/transactions/checkpoints", middleware.RequireSuperAdmin(transactionHandler.CreateCheckpoint))
+
+	// Shop routes
+	r.Handle("GET", "/shops", middleware.AuthMiddleware(shopHandler.GetAll, models.ScopeShopsRead))
+	r.Handle("GET", "/shops/current", middleware.AuthMiddleware(shopHandler.GetCurrent, models.ScopeShopsRead))
+	r.Handle("PUT", "/shops/whatsapp", middleware.RequireSuperAdmin(shopHandler.UpdateWhatsApp))
+	r.Handle("PUT", "/shops/2fa", middleware.RequireSuperAdmin(shopHandler.UpdateTwoFactorPolicy))
+	r.Handle("POST", "/shops/members", middleware.RequireSuperAdmin(middleware.Idempotent(shopHandler.AddMember)))
+	r.Handle("POST", "/shops/switch", middleware.AuthMiddleware(authHandler.SwitchShop))
+
+	// Current user routes (any signed-in user)
+	r.Handle("GET", "/me/shops", middleware.AuthMiddleware(authHandler.MyShops))
+	r.Handle("GET", "/me/invitations", middleware.AuthMiddleware(authHandler.MyInvitations))
+	r.Handle("POST", "/me/invitations/{id}/accept", middleware.AuthMiddleware(authHandler.AcceptInvitation))
+	r.Handle("POST", "/me/invitations/{id}/decline", middleware.AuthMiddleware(authHandler.DeclineInvitation))
+	r.Handle("PUT", "/me/password", middleware.AuthMiddleware(userHandler.ChangePassword))
+	r.Handle("POST", "/me/2fa/setup", middleware.AuthMiddleware(authHandler.SetupTOTP))
+	r.Handle("POST", "/me/2fa/enable", middleware.AuthMiddleware(authHandler.EnableTOTP))
+	r.Handle("POST", "/me/2fa/disable", middleware.AuthMiddleware(authHandler.DisableTOTP))
+
+	// User management routes (SuperAdmin only)
+	r.Handle("GET", "/users", middleware.RequireSuperAdmin(userHandler.GetAll))
+	r.Handle("GET", "/users/{id}", middleware.RequireSuperAdmin(userHandler.Get))
+	r.Handle("PUT", "/users/{id}", middleware.RequireSuperAdmin(userHandler.Update))
+	r.Handle("POST", "/users/{id}/deactivate", middleware.RequireSuperAdmin(userHandler.Deactivate))
+	r.Handle("POST", "/users/{id}/reactivate", middleware.RequireSuperAdmin(userHandler.Reactivate))
+	r.Handle("POST", "/users/{id}/password-reset", middleware.RequireSuperAdmin(userHandler.CreatePasswordReset))
+	r.Handle("POST", "/users/{id}/unlock", middleware.RequireSuperAdmin(userHandler.Unlock))
+
+	// API key routes (SuperAdmin only)
+	r.Handle("GET", "/api-keys", middleware.RequireSuperAdmin(apiKeyHandler.GetAll))
+	r.Handle("POST", "/api-keys", middleware.RequireSuperAdmin(apiKeyHandler.Create))
+	r.Handle("DELETE", "/api-keys/{id}", middleware.RequireSuperAdmin(apiKeyHandler.Revoke))
+
+	// Audit log (SuperAdmin only)
+	r.Handle("GET", "/audit", middleware.RequireSuperAdmin(auditHandler.GetAll))
+
+	// API description, generated from the request and response types. The
+	// tests check that it documents exactly the routes registered above.
+	spec := openapi.Build(openapi.Info{
+		Title:   "Shop Management API",
+		Version: "1.0.0",
+	}, config.APIPrefix, handlers.Operations())
+	r.HandleRoot("GET", "/openapi.json", openapi.SpecHandler(spec))
+	r.HandleRoot("GET", "/docs", openapi.DocsHandler())
+
+	return &App{
+		Router:       r,
+		Spec:         spec,
+		Shops:        shopService,
+		Memberships:  membershipService,
+		Users:        userService,
+		APIKeys:      apiKeyService,
+		Products:     productService,
+		Transactions: transactionService,
+		Audit:        auditService,
+		Reports:      reportService,
+	}, nil
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/ledger"
+	"shop-api/openapi"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventBus, err := events.NewBus(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { eventBus.Shutdown(context.Background()) })
+
+	api, err := New(eventBus, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+// Every route must be documented in handlers/openapi.go, and every documented
+// operation served
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	for _, issuer := range []string{"", "https://idp.example"} {
+		t.Run("oidc="+issuer, func(t *testing.T) {
+			previous := config.OIDCIssuerURL
+			config.OIDCIssuerURL = issuer
+			t.Cleanup(func() { config.OIDCIssuerURL = previous })
+
+			api := newTestApp(t)
+			if diff := openapi.Diff(api.Router.Routes(), api.Spec); len(diff) > 0 {
+				t.Errorf("OpenAPI document out of date:\n  %s", strings.Join(diff, "\n  "))
+			}
+		})
+	}
+}
+
+// PATCH /products/{id} takes a merge patch: any subset of the product's
+// fields, where null resets one
+func TestOpenAPIProductPatchIsAMergePatch(t *testing.T) {
+	api := newTestApp(t)
+	data, err := json.Marshal(api.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	content := doc.Paths["/products/{id}"]["patch"].RequestBody.Content
+	ref, _ := content["application/merge-patch+json"].Schema["$ref"].(string)
+	name := strings.TrimPrefix(ref, "#/components/schemas/")
+	patch := doc.Components.Schemas[name]
+	if patch == nil {
+		t.Fatalf("PATCH body %v is not a merge patch schema", content)
+	}
+	if _, ok := patch["required"]; ok {
+		t.Errorf("merge patch requires %v", patch["required"])
+	}
+	if patch["additionalProperties"] != false {
+		t.Error("merge patch accepts other keys")
+	}
+
+	properties, _ := patch["properties"].(map[string]any)
+	replace, _ := doc.Components.Schemas["CreateProductRequest"]["properties"].(map[string]any)
+	if len(properties) != len(replace) {
+		t.Errorf("merge patch has %d fields, the product %d", len(properties), len(replace))
+	}
+	for field, property := range properties {
+		types, _ := property.(map[string]any)["type"].([]any)
+		if !slices.Contains(types, any("null")) {
+			t.Errorf("%s is not nullable: %v", field, property)
+		}
+	}
+	// PUT still replaces the whole product
+	if required, _ := doc.Components.Schemas["CreateProductRequest"]["required"].([]any); len(required) == 0 {
+		t.Error("CreateProductRequest lost its required fields")
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"shop-api/handlers"
+	"shop-api/models"
+	"strconv"
+)
+
+// loginResponse is either a signed-in user or a two-factor challenge
+type loginResponse struct {
+	handlers.AuthResponse
+	handlers.TwoFactorChallengeResponse
+}
+
+// Register creates a user in a shop, with the Admin role
+func (c *Client) Register(ctx context.Context, req handlers.RegisterRequest) (*models.UserResponse, error) {
+	var user models.UserResponse
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/register", body: req}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login signs in and authenticates later requests. The credentials are kept
+// to sign in again when the token expires. Accounts with two-factor
+// authentication get a *TwoFactorChallenge error to answer with LoginTwoFactor.
+func (c *Client) Login(ctx context.Context, email, password string) (*handlers.AuthResponse, error) {
+	response, err := c.login(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token = response.Token
+	c.email, c.password, c.shopID = email, password, 0
+	c.mu.Unlock()
+	return response, nil
+}
+
+func (c *Client) login(ctx context.Context, email, password string) (*handlers.AuthResponse, error) {
+	var response loginResponse
+	// Signing in changes nothing, so it is retried, e.g. after the server's login backoff
+	req := &request{method: http.MethodPost, path: "/login", body: handlers.LoginRequest{Email: email, Password: password}, idempotent: true}
+	if err := c.do(ctx, req, &response); err != nil {
+		return nil, err
+	}
+	if response.TwoFactorRequired {
+		return nil, &TwoFactorChallenge{Token: response.ChallengeToken}
+	}
+	if response.Token == "" {
+		return nil, errNoToken
+	}
+	return &response.AuthResponse, nil
+}
+
+// LoginTwoFactor completes a sign-in with a TOTP or recovery code. Renewing
+// its token would take a new code, so it is not renewed when it expires.
+func (c *Client) LoginTwoFactor(ctx context.Context, challengeToken, code string) (*handlers.AuthResponse, error) {
+	var response handlers.AuthResponse
+	req := &request{method: http.MethodPost, path: "/login/2fa", body: handlers.TwoFactorLoginRequest{ChallengeToken: challengeToken, Code: code}}
+	if err := c.do(ctx, req, &response); err != nil {
+		return nil, err
+	}
+
+	c.SetToken(response.Token)
+	return &response, nil
+}
+
+// SwitchShop makes another shop of the user the active one for later requests
+func (c *Client) SwitchShop(ctx context.Context, shopID int) (*handlers.SwitchShopResponse, error) {
+	response, err := c.switchShop(ctx, shopID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token = response.Token
+	c.shopID = shopID
+	c.mu.Unlock()
+	return response, nil
+}
+
+// switchShop renews the token when renew is set, which refresh, already renewing it, doesn't
+func (c *Client) switchShop(ctx context.Context, shopID int, renew bool) (*handlers.SwitchShopResponse, error) {
+	var response handlers.SwitchShopResponse
+	req := &request{method: http.MethodPost, path: "/shops/switch", body: handlers.SwitchShopRequest{ShopID: shopID}, auth: true, noRenew: !renew}
+	if err := c.do(ctx, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// MyShops lists the shops the user belongs to, with their role in each
+func (c *Client) MyShops(ctx context.Context) ([]models.Membership, error) {
+	var memberships []models.Membership
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/me/shops", auth: true}, &memberships); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// MyInvitations lists the shops inviting the user, awaiting an answer
+func (c *Client) MyInvitations(ctx context.Context) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/me/invitations", auth: true}, &invitations); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation joins the inviting shop; SwitchShop then makes it the active one
+func (c *Client) AcceptInvitation(ctx context.Context, id int) (*models.Membership, error) {
+	var membership models.Membership
+	req := &request{method: http.MethodPost, path: "/me/invitations/" + strconv.Itoa(id) + "/accept", auth: true}
+	if err := c.do(ctx, req, &membership); err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// DeclineInvitation discards an invitation
+func (c *Client) DeclineInvitation(ctx context.Context, id int) error {
+	return c.do(ctx, &request{method: http.MethodPost, path: "/me/invitations/" + strconv.Itoa(id) + "/decline", auth: true}, nil)
+}
@@ -0,0 +1,333 @@
+// Package client is a typed Go client for shop-api. It reuses the server's
+// request and response types, so it stays in step with the handlers.
+//
+//	c := client.New("http://localhost:8081")
+//	if _, err := c.Login(ctx, "super@shop1.com", "admin123"); err != nil { ... }
+//	products, err := c.ListProducts(ctx)
+//	if errors.Is(err, client.ErrForbidden) { ... }
+//
+// Requests are retried with exponential backoff on network errors and on
+// 429/502/503/504, as long as repeating them is safe: reads, PUT/PATCH/DELETE,
+// signing in, and creates, which are sent with an Idempotency-Key reused
+// across retries.
+// When the client signed in with Login, an expired token is renewed by signing
+// in again, and the shop selected with SwitchShop is restored.
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"shop-api/problem"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// refreshBefore is how long before its expiry a token is renewed
+const refreshBefore = 30 * time.Second
+
+// Protocol constants of the server, copied rather than read from its
+// config package; the tests check they still match
+const (
+	apiPrefix            = "/api/v1" // version prefix of every API path
+	apiKeyHeader         = "X-API-Key"
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+type Client struct {
+	BaseURL      string // server root, e.g. http://localhost:8081, without the API prefix
+	HTTPClient   *http.Client
+	APIKey       string        // sent as X-API-Key instead of a bearer token when set
+	MaxRetries   int           // retries after the first attempt
+	RetryWait    time.Duration // wait before the first retry, doubled on each one
+	MaxRetryWait time.Duration // longest wait, including a server's Retry-After
+
+	mu       sync.Mutex
+	token    string
+	email    string // credentials from Login, to sign in again when the token expires
+	password string
+	shopID   int // shop selected with SwitchShop, restored after signing in again
+
+	refreshMu sync.Mutex
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		RetryWait:    200 * time.Millisecond,
+		MaxRetryWait: 5 * time.Second,
+	}
+}
+
+// SetToken authenticates later requests with a token obtained elsewhere.
+// Such a token is not renewed when it expires.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	c.email, c.password, c.shopID = "", "", 0
+}
+
+// Token returns the bearer token in use, if any
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// request is one API call
+type request struct {
+	method      string
+	path        string // relative to the API prefix
+	body        any    // encoded as JSON when not nil
+	contentType string // application/json by default
+	header      http.Header
+	auth        bool // sends the API key or bearer token
+	idempotent  bool // safe to repeat even though it is a POST
+	noRenew     bool // never renews the token, for requests made while renewing it
+}
+
+// retryable reports whether repeating the request can't apply it twice
+func (r *request) retryable() bool {
+	return r.method != http.MethodPost || r.idempotent || r.header.Get(idempotencyKeyHeader) != ""
+}
+
+// do sends req, retrying and renewing the token as needed, and decodes a
+// successful response into out when it is not nil
+func (c *Client) do(ctx context.Context, req *request, out any) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return err
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		if req.auth && !req.noRenew {
+			if err := c.ensureToken(ctx); err != nil {
+				return err
+			}
+		}
+		token := c.Token()
+
+		resp, err := c.send(ctx, req, body, token)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !req.retryable() || attempt >= c.MaxRetries {
+				return err
+			}
+			if err := c.wait(ctx, c.backoff(attempt)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if resp.StatusCode < 300 {
+			return decodeResponse(resp, out)
+		}
+		apiErr := readError(resp)
+
+		// A token rejected by the server is renewed once, if the client can sign in again
+		if resp.StatusCode == http.StatusUnauthorized && req.auth && !req.noRenew && c.APIKey == "" && !refreshed && c.canRefresh() {
+			refreshed = true
+			if err := c.refresh(ctx, token); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.retryable() && attempt < c.MaxRetries && shouldRetry(resp.StatusCode, apiErr.Code) {
+			wait := c.backoff(attempt)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > c.MaxRetryWait {
+					return apiErr
+				}
+				wait = max(wait, retryAfter)
+			}
+			if err := c.wait(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+		return apiErr
+	}
+}
+
+func (c *Client) send(ctx context.Context, req *request, body []byte, token string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.BaseURL+apiPrefix+req.path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Accept", "application/json, "+problem.ContentType)
+	// Continue the caller's trace, if ctx carries one
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+	if body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if req.auth {
+		if c.APIKey != "" {
+			httpReq.Header.Set(apiKeyHeader, c.APIKey)
+		} else if token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	return c.HTTPClient.Do(httpReq)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// shouldRetry reports whether a failed response may succeed if repeated
+func shouldRetry(status int, code string) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		return code == problem.CodeRequestInProgress
+	}
+	return false
+}
+
+// backoff returns the wait before retry number attempt+1: exponential, with jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.RetryWait << attempt
+	if wait <= 0 || wait > c.MaxRetryWait {
+		wait = c.MaxRetryWait
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func (c *Client) canRefresh() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.email != ""
+}
+
+// ensureToken signs in again before sending a request with a token about to expire
+func (c *Client) ensureToken(ctx context.Context) error {
+	if c.APIKey != "" || !c.canRefresh() {
+		return nil
+	}
+
+	token := c.Token()
+	if token != "" {
+		claims := &jwt.RegisteredClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err == nil &&
+			(claims.ExpiresAt == nil || time.Until(claims.ExpiresAt.Time) > refreshBefore) {
+			return nil
+		}
+	}
+	return c.refresh(ctx, token)
+}
+
+// refresh signs in again with the credentials given to Login and returns to
+// the shop selected with SwitchShop. Concurrent callers holding the same
+// stale token wait for a single renewal.
+func (c *Client) refresh(ctx context.Context, stale string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.Lock()
+	email, password, shopID, current := c.email, c.password, c.shopID, c.token
+	c.mu.Unlock()
+	if current != stale {
+		return nil
+	}
+
+	response, err := c.login(ctx, email, password)
+	if err != nil {
+		return err
+	}
+	c.setToken(response.Token)
+
+	if shopID != 0 && shopID != response.User.ShopID {
+		switched, err := c.switchShop(ctx, shopID, false)
+		if err != nil {
+			return err
+		}
+		c.setToken(switched.Token)
+	}
+	return nil
+}
+
+func (c *Client) setToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// newIdempotencyKey returns a random key for one create, reused across its retries
+func newIdempotencyKey() http.Header {
+	return http.Header{idempotencyKeyHeader: {crand.Text()}}
+}
+
+// ifMatch returns the If-Match header for a version, or none for version 0
+func ifMatch(version int) http.Header {
+	if version == 0 {
+		return nil
+	}
+	return http.Header{"If-Match": {`"` + strconv.Itoa(version) + `"`}}
+}
+
+var errNoToken = errors.New("client: response has no token")
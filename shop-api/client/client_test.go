@@ -0,0 +1,224 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"shop-api/app"
+	"shop-api/client"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/handlers"
+	"shop-api/ledger"
+	"shop-api/models"
+	"shop-api/utils"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the real API, with fresh seeded stores, and returns a
+// client for it
+func newTestServer(t *testing.T) (*client.Client, *app.App) {
+	t.Helper()
+
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventBus, err := events.NewBus(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { eventBus.Shutdown(context.Background()) })
+
+	api, err := app.New(eventBus, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(api.Router)
+	t.Cleanup(server.Close)
+
+	c := client.New(server.URL)
+	c.MaxRetries = 0
+	return c, api
+}
+
+func TestLogin(t *testing.T) {
+	c, _ := newTestServer(t)
+	ctx := context.Background()
+
+	response, err := c.Login(ctx, "super@shop1.com", "admin123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Token == "" || c.Token() != response.Token {
+		t.Fatalf("token %q not used by the client (%q)", response.Token, c.Token())
+	}
+	if response.User.Role != models.RoleSuperAdmin || response.User.ShopID != 1 {
+		t.Errorf("signed in as %s of shop %d, want SuperAdmin of shop 1", response.User.Role, response.User.ShopID)
+	}
+
+	shop, err := c.CurrentShop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shop.ID != 1 {
+		t.Errorf("current shop = %d, want 1", shop.ID)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	c, _ := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := c.Login(ctx, "super@shop1.com", "wrong password")
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if c.Token() != "" {
+		t.Error("client kept a token after a failed login")
+	}
+
+	// Without a token, private routes answer 401
+	if _, err := c.ListProducts(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	c, api := newTestServer(t)
+	ctx := context.Background()
+
+	secret, _, err := api.Users.BeginTOTPEnrollment(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := utils.TOTPStep(time.Now())
+	enrollCode, _ := utils.TOTPCode(secret, step)
+	if _, err := api.Users.EnableTOTP(1, enrollCode); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Login(ctx, "super@shop1.com", "admin123")
+	var challenge *client.TwoFactorChallenge
+	if !errors.As(err, &challenge) || !errors.Is(err, client.ErrTwoFactorRequired) {
+		t.Fatalf("err = %v, want a *TwoFactorChallenge", err)
+	}
+
+	// The enrollment code was used up: answer with the next one
+	code, _ := utils.TOTPCode(secret, step+1)
+	response, err := c.LoginTwoFactor(ctx, challenge.Token, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Token == "" || c.Token() != response.Token {
+		t.Fatalf("token %q not used by the client (%q)", response.Token, c.Token())
+	}
+	if _, err := c.ListProducts(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProductVersions(t *testing.T) {
+	c, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Login(ctx, "super@shop1.com", "admin123"); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := c.CreateProduct(ctx, handlers.CreateProductRequest{Name: "Cable", SellingPrice: 50, Stock: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes sent with the current version apply and bump it
+	updated, err := c.UpdateProduct(ctx, created.ID, handlers.CreateProductRequest{Name: "USB-C cable", SellingPrice: 60, Stock: 10}, created.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != created.Version+1 || updated.Name != "USB-C cable" {
+		t.Fatalf("updated = %+v, want version %d", updated, created.Version+1)
+	}
+
+	// A writer still holding the first version is refused
+	if _, err := c.PatchProduct(ctx, created.ID, map[string]any{"stock": 5}, created.Version); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("stale patch: err = %v, want ErrPreconditionFailed", err)
+	}
+	if err := c.DeleteProduct(ctx, created.ID, created.Version); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("stale delete: err = %v, want ErrPreconditionFailed", err)
+	}
+
+	patched, err := c.PatchProduct(ctx, created.ID, map[string]any{"stock": 5}, updated.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Stock != 5 || patched.Name != "USB-C cable" || patched.Version != updated.Version+1 {
+		t.Fatalf("patched = %+v", patched)
+	}
+
+	if err := c.DeleteProduct(ctx, created.ID, patched.Version); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetProduct(ctx, created.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestProductVersionRequired(t *testing.T) {
+	c, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Login(ctx, "super@shop1.com", "admin123"); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.RequireIfMatch
+	config.RequireIfMatch = true
+	t.Cleanup(func() { config.RequireIfMatch = previous })
+
+	// Version 0 sends no If-Match
+	if _, err := c.PatchProduct(ctx, 1, map[string]any{"stock": 5}, 0); !errors.Is(err, client.ErrPreconditionRequired) {
+		t.Fatalf("err = %v, want ErrPreconditionRequired", err)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	c, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Login(ctx, "admin@shop1.com", "admin123"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every invalid field is reported
+	_, err := c.CreateProduct(ctx, handlers.CreateProductRequest{SellingPrice: -1})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrValidationFailed) {
+		t.Fatalf("err = %v, want a validation_failed *Error", err)
+	}
+	if apiErr.Status != http.StatusUnprocessableEntity || apiErr.Type == "" || apiErr.Instance != config.APIPrefix+"/products" {
+		t.Errorf("problem = %+v", apiErr.Problem)
+	}
+	var fields []string
+	for _, field := range apiErr.Errors {
+		fields = append(fields, field.Field)
+	}
+	if !slices.Contains(fields, "name") || !slices.Contains(fields, "selling_price") {
+		t.Errorf("field errors = %v, want name and selling_price", fields)
+	}
+
+	// Admins don't see the dashboard
+	_, err = c.Dashboard(ctx)
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrForbidden) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("err = %v, want a forbidden *Error", err)
+	}
+
+	// Sales beyond the stock are refused
+	productID := 1
+	_, err = c.CreateTransaction(ctx, handlers.CreateTransactionRequest{Type: models.TransactionSale, ProductID: &productID, Quantity: 1000000, Amount: 1})
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrInsufficientStock) || apiErr.Status != http.StatusConflict {
+		t.Fatalf("err = %v, want an insufficient_stock *Error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"shop-api/problem"
+	"strings"
+)
+
+// Error is an error response from the API, decoded from its problem details
+type Error struct {
+	problem.Problem
+}
+
+func (e *Error) Error() string {
+	message := "shop-api: " + http.StatusText(e.Status)
+	if e.Code != "" {
+		message += " (" + e.Code + ")"
+	}
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	for _, field := range e.Errors {
+		message += "; " + field.Field + " " + field.Message
+	}
+	return message
+}
+
+// Is matches errors with the same code, so that errors.Is(err, ErrNotFound)
+// holds for any not_found response
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Errors to compare with errors.Is, one per code the server sends
+var (
+	ErrInvalidJSON          = codeError(problem.CodeInvalidJSON)
+	ErrInvalidInput         = codeError(problem.CodeInvalidInput)
+	ErrValidationFailed     = codeError(problem.CodeValidationFailed)
+	ErrUnauthorized         = codeError(problem.CodeUnauthorized)
+	ErrInvalidCredentials   = codeError(problem.CodeInvalidCredentials)
+	ErrForbidden            = codeError(problem.CodeForbidden)
+	ErrForbiddenTenant      = codeError(problem.CodeForbiddenTenant)
+	ErrAccountDisabled      = codeError(problem.CodeAccountDisabled)
+	ErrTwoFactorRequired    = codeError(problem.CodeTwoFactorRequired)
+	ErrNotFound             = codeError(problem.CodeNotFound)
+	ErrMethodNotAllowed     = codeError(problem.CodeMethodNotAllowed)
+	ErrConflict             = codeError(problem.CodeConflict)
+	ErrPreconditionFailed   = codeError(problem.CodePreconditionFailed)
+	ErrPreconditionRequired = codeError(problem.CodePreconditionRequired)
+	ErrInsufficientStock    = codeError(problem.CodeInsufficientStock)
+	ErrIdempotencyKeyReused = codeError(problem.CodeIdempotencyKeyReused)
+	ErrRequestInProgress    = codeError(problem.CodeRequestInProgress)
+	ErrPayloadTooLarge      = codeError(problem.CodePayloadTooLarge)
+	ErrUnsupportedMediaType = codeError(problem.CodeUnsupportedMediaType)
+	ErrTooManyAttempts      = codeError(problem.CodeTooManyAttempts)
+	ErrRateLimited          = codeError(problem.CodeRateLimited)
+	ErrBadGateway           = codeError(problem.CodeBadGateway)
+	ErrInternal             = codeError(problem.CodeInternal)
+)
+
+func codeError(code string) *Error {
+	return &Error{problem.Problem{Code: code}}
+}
+
+// TwoFactorChallenge is returned by Login when the account must also prove a
+// second factor: pass Token to LoginTwoFactor with a TOTP or recovery code.
+// It matches ErrTwoFactorRequired.
+type TwoFactorChallenge struct {
+	Token string
+}
+
+func (e *TwoFactorChallenge) Error() string {
+	return "shop-api: two-factor authentication required"
+}
+
+func (e *TwoFactorChallenge) Is(target error) bool {
+	return target == ErrTwoFactorRequired
+}
+
+// readError decodes an error response, falling back to its status for
+// responses that are not problem details, e.g. from a proxy
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	apiErr := &Error{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), problem.ContentType) {
+		json.Unmarshal(body, &apiErr.Problem)
+	}
+	if apiErr.Status == 0 {
+		apiErr.Status = resp.StatusCode
+	}
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
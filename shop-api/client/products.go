@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"shop-api/handlers"
+	"shop-api/models"
+	"strconv"
+)
+
+// ListProducts lists the active shop's products. PurchasePrice is only set
+// for SuperAdmins.
+func (c *Client) ListProducts(ctx context.Context) ([]models.Product, error) {
+	var products []models.Product
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/products", auth: true}, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (c *Client) GetProduct(ctx context.Context, id int) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodGet, path: productPath(id), auth: true})
+}
+
+// CreateProduct adds a product to the active shop. It is sent with an
+// Idempotency-Key, so a retry never creates it twice.
+func (c *Client) CreateProduct(ctx context.Context, req handlers.CreateProductRequest) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPost, path: "/products", body: req, header: newIdempotencyKey(), auth: true})
+}
+
+// UpdateProduct replaces a product. With a non-zero version, it fails with
+// ErrPreconditionFailed if the product changed since that version.
+func (c *Client) UpdateProduct(ctx context.Context, id int, req handlers.CreateProductRequest, version int) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPut, path: productPath(id), body: req, header: ifMatch(version), auth: true})
+}
+
+// PatchProduct changes the fields present in patch (a JSON Merge Patch, such
+// as a map or a struct with omitempty fields). version works as in UpdateProduct.
+func (c *Client) PatchProduct(ctx context.Context, id int, patch any, version int) (*models.Product, error) {
+	return c.product(ctx, &request{
+		method:      http.MethodPatch,
+		path:        productPath(id),
+		body:        patch,
+		contentType: "application/merge-patch+json",
+		header:      ifMatch(version),
+		auth:        true,
+	})
+}
+
+// DeleteProduct deletes a product. version works as in UpdateProduct.
+func (c *Client) DeleteProduct(ctx context.Context, id int, version int) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: productPath(id), header: ifMatch(version), auth: true}, nil)
+}
+
+// PublicProducts lists a shop's public catalog, without authentication
+func (c *Client) PublicProducts(ctx context.Context, shopID int) ([]models.PublicProductResponse, error) {
+	var products []models.PublicProductResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/public/" + strconv.Itoa(shopID) + "/products"}, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (c *Client) product(ctx context.Context, req *request) (*models.Product, error) {
+	var product models.Product
+	if err := c.do(ctx, req, &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func productPath(id int) string {
+	return "/products/" + strconv.Itoa(id)
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"shop-api/config"
+	"shop-api/middleware"
+	"testing"
+)
+
+func TestProtocolConstantsMatchTheServer(t *testing.T) {
+	tests := []struct{ name, client, server string }{
+		{"API prefix", apiPrefix, config.APIPrefix},
+		{"API key header", apiKeyHeader, middleware.APIKeyHeader},
+		{"idempotency key header", idempotencyKeyHeader, middleware.IdempotencyKeyHeader},
+	}
+	for _, tt := range tests {
+		if tt.client != tt.server {
+			t.Errorf("%s: client uses %q, the server %q", tt.name, tt.client, tt.server)
+		}
+	}
+}
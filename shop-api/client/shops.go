@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"shop-api/handlers"
+	"shop-api/models"
+)
+
+func (c *Client) ListShops(ctx context.Context) ([]models.Shop, error) {
+	var shops []models.Shop
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/shops", auth: true}, &shops); err != nil {
+		return nil, err
+	}
+	return shops, nil
+}
+
+// CurrentShop returns the active shop, whose Version conditions the updates below
+func (c *Client) CurrentShop(ctx context.Context) (*models.Shop, error) {
+	var shop models.Shop
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/shops/current", auth: true}, &shop); err != nil {
+		return nil, err
+	}
+	return &shop, nil
+}
+
+// UpdateWhatsApp sets the active shop's WhatsApp number (SuperAdmin). With a
+// non-zero version, it fails with ErrPreconditionFailed if the shop changed since.
+func (c *Client) UpdateWhatsApp(ctx context.Context, number string, version int) error {
+	req := &request{method: http.MethodPut, path: "/shops/whatsapp", body: handlers.UpdateWhatsAppRequest{WhatsAppNumber: number}, header: ifMatch(version), auth: true}
+	return c.do(ctx, req, nil)
+}
+
+// SetTwoFactorPolicy requires SuperAdmins of the active shop to sign in with a
+// second factor (SuperAdmin). version works as in UpdateWhatsApp.
+func (c *Client) SetTwoFactorPolicy(ctx context.Context, required bool, version int) error {
+	req := &request{method: http.MethodPut, path: "/shops/2fa", body: handlers.UpdateTwoFactorPolicyRequest{Required: required}, header: ifMatch(version), auth: true}
+	return c.do(ctx, req, nil)
+}
+
+// AddMember invites an existing user to the active shop with a role
+// (SuperAdmin). They get access once they accept with AcceptInvitation.
+func (c *Client) AddMember(ctx context.Context, email string, role models.Role) (*models.Invitation, error) {
+	var invitation models.Invitation
+	req := &request{method: http.MethodPost, path: "/shops/members", body: handlers.AddMemberRequest{Email: email, Role: role}, header: newIdempotencyKey(), auth: true}
+	if err := c.do(ctx, req, &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"shop-api/handlers"
+	"shop-api/ledger"
+	"shop-api/models"
+	"shop-api/services"
+)
+
+// ListTransactions lists the active shop's transactions (Admin)
+func (c *Client) ListTransactions(ctx context.Context) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/transactions", auth: true}, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// CreateTransaction records a sale, expense or withdrawal (Admin). It is sent
+// with an Idempotency-Key, so a retry never records it twice.
+func (c *Client) CreateTransaction(ctx context.Context, req handlers.CreateTransactionRequest) (*models.Transaction, error) {
+	var transaction models.Transaction
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/transactions", body: req, header: newIdempotencyKey(), auth: true}, &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// Dashboard returns the active shop's sales and profit totals (SuperAdmin)
+func (c *Client) Dashboard(ctx context.Context) (*services.DashboardStats, error) {
+	var stats services.DashboardStats
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/reports/dashboard", auth: true}, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// VerifyChain walks the active shop's transaction hash chain (SuperAdmin)
+func (c *Client) VerifyChain(ctx context.Context) (*ledger.Verification, error) {
+	var verification ledger.Verification
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/transactions/verify", auth: true}, &verification); err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// ExportCheckpoints returns the active shop's signed checkpoints and their public key (SuperAdmin)
+func (c *Client) ExportCheckpoints(ctx context.Context) (*ledger.CheckpointExport, error) {
+	var export ledger.CheckpointExport
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/transactions/checkpoints", auth: true}, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
@@ -0,0 +1,93 @@
+// Command verify-chain checks a shop's exported transactions against its
+// signed checkpoints, without access to the server:
+//
+//	verify-chain -transactions transactions.json -checkpoints checkpoints.json [-public-key KEY]
+//
+// transactions.json is the body of GET /transactions and checkpoints.json the
+// body of GET /transactions/checkpoints. -public-key pins the key the
+// checkpoints must be signed with, instead of trusting the one exported with
+// them. It prints the verification and exits with status 1 on a break.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shop-api/ledger"
+	"shop-api/models"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run verifies the chain and returns the exit status: 0 when it holds, 1 on
+// a break, 2 when the files cannot be checked
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify-chain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	transactionsFile := flags.String("transactions", "", "JSON array of the shop's transactions, in chain order")
+	checkpointsFile := flags.String("checkpoints", "", "checkpoint export of the shop")
+	publicKey := flags.String("public-key", "", "base64 Ed25519 public key the checkpoints must be signed with")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *transactionsFile == "" || *checkpointsFile == "" {
+		flags.Usage()
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintln(stderr, "verify-chain:", err)
+		return 2
+	}
+
+	var transactions []models.Transaction
+	if err := readJSON(*transactionsFile, &transactions); err != nil {
+		return fail(err)
+	}
+	var export ledger.CheckpointExport
+	if err := readJSON(*checkpointsFile, &export); err != nil {
+		return fail(err)
+	}
+
+	if export.Algorithm != ledger.Algorithm {
+		return fail(fmt.Errorf("unsupported signature algorithm %q", export.Algorithm))
+	}
+	if *publicKey != "" && *publicKey != export.PublicKey {
+		return fail(fmt.Errorf("checkpoints are signed with %s, not the expected key", export.PublicKey))
+	}
+	key, err := base64.StdEncoding.DecodeString(export.PublicKey)
+	if err != nil {
+		return fail(fmt.Errorf("invalid public key: %w", err))
+	}
+	for _, transaction := range transactions {
+		if transaction.ShopID != export.ShopID {
+			return fail(fmt.Errorf("transaction %d belongs to shop %d, not %d", transaction.ID, transaction.ShopID, export.ShopID))
+		}
+	}
+
+	verification := ledger.Verify(export.ShopID, transactions, export.Checkpoints, key)
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(verification)
+	if !verification.Valid {
+		return 1
+	}
+	return 0
+}
+
+func readJSON(name string, v any) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"shop-api/ledger"
+	"shop-api/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeExport writes a shop's chain of 3 transactions and a checkpoint over
+// it, as exported by the API, after tamper changed the transactions
+func writeExport(t *testing.T, signer *ledger.Signer, tamper func([]models.Transaction)) (transactionsFile, checkpointsFile string) {
+	t.Helper()
+
+	var transactions []models.Transaction
+	head := ledger.GenesisHash
+	for i := range 3 {
+		transaction := models.Transaction{
+			ID:        i + 1,
+			Type:      models.TransactionExpense,
+			Quantity:  1,
+			Amount:    500,
+			ShopID:    1,
+			CreatedAt: time.Date(2026, 3, 1, i, 0, 0, 0, time.UTC),
+			PrevHash:  head,
+		}
+		transaction.Hash = ledger.Hash(transaction)
+		head = transaction.Hash
+		transactions = append(transactions, transaction)
+	}
+	checkpoint := models.ChainCheckpoint{ID: 1, ShopID: 1, TransactionID: 3, Count: 3, Hash: head, CreatedAt: time.Now()}
+	signer.Sign(&checkpoint)
+	tamper(transactions)
+
+	dir := t.TempDir()
+	transactionsFile = filepath.Join(dir, "transactions.json")
+	checkpointsFile = filepath.Join(dir, "checkpoints.json")
+	writeJSON(t, transactionsFile, transactions)
+	writeJSON(t, checkpointsFile, ledger.CheckpointExport{
+		ShopID:      1,
+		Algorithm:   ledger.Algorithm,
+		PublicKey:   base64.StdEncoding.EncodeToString(signer.PublicKey()),
+		Checkpoints: []models.ChainCheckpoint{checkpoint},
+	})
+	return transactionsFile, checkpointsFile
+}
+
+func writeJSON(t *testing.T, name string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	untouched := func([]models.Transaction) {}
+
+	tests := []struct {
+		name      string
+		tamper    func([]models.Transaction)
+		publicKey []byte
+		status    int
+		output    string // expected in stdout or stderr
+	}{
+		{"valid", untouched, nil, 0, `"valid": true`},
+		{"pinned key", untouched, signer.PublicKey(), 0, `"valid": true`},
+		{"altered transaction", func(transactions []models.Transaction) { transactions[1].Amount = 5 }, nil, 1, ledger.BreakHash},
+		{"another key", untouched, otherSigner.PublicKey(), 2, "not the expected key"},
+		{"another shop's transaction", func(transactions []models.Transaction) { transactions[0].ShopID = 2 }, nil, 2, "belongs to shop 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactionsFile, checkpointsFile := writeExport(t, signer, tt.tamper)
+			args := []string{"-transactions", transactionsFile, "-checkpoints", checkpointsFile}
+			if tt.publicKey != nil {
+				args = append(args, "-public-key", base64.StdEncoding.EncodeToString(tt.publicKey))
+			}
+
+			var stdout, stderr bytes.Buffer
+			status := run(args, &stdout, &stderr)
+
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d (stderr: %s)", status, tt.status, stderr.String())
+			}
+			if output := stdout.String() + stderr.String(); !strings.Contains(output, tt.output) {
+				t.Errorf("output %q does not mention %q", output, tt.output)
+			}
+		})
+	}
+}
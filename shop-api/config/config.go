@@ -1,12 +1,172 @@
 package config
 
-import "time"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var (
 	// JWT Configuration
 	JWTSecret     = []byte("your-secret-key-change-this-in-production")
 	JWTExpiration = time.Hour * 24 * 7 // 7 days
 
+	// Password Policy Configuration
+	PasswordMinLength     = 8
+	PasswordMaxLength     = 72 // bcrypt ignores anything longer
+	PasswordRequireUpper  = true
+	PasswordRequireLower  = true
+	PasswordRequireDigit  = true
+	PasswordRequireSymbol = false
+	PasswordBcryptCost    = 12 // existing hashes are upgraded on the next successful login
+
+	// Password Reset Configuration
+	PasswordResetExpiration = time.Hour // single-use reset tokens
+
+	// Two-Factor Authentication Configuration
+	TOTPIssuer                   = "Shop Management" // shown in authenticator apps
+	TwoFactorChallengeExpiration = time.Minute * 5   // time allowed to enter the code after the password
+	RecoveryCodeCount            = 10
+
+	// Login Throttling Configuration
+	LoginMaxAccountFailures = 5                // failures before an account is locked
+	LoginMaxIPFailures      = 20               // failures before an IP is locked
+	LoginLockoutDuration    = time.Minute * 15 // how long a lockout lasts
+	LoginFailureWindow      = time.Minute * 15 // failures older than this are forgotten
+	LoginBackoffBase        = time.Second      // delay after the first failure, doubled on each one
+	LoginBackoffMax         = time.Second * 30 // upper bound for the backoff delay
+
+	// Idempotency Configuration
+	IdempotencyKeyWindow    = time.Hour * 24 // how long a key's first response is replayed
+	IdempotencyKeyMaxLength = 255
+
+	// OpenID Connect SSO Configuration (disabled unless OIDC_ISSUER_URL is set)
+	OIDCIssuerURL       = os.Getenv("OIDC_ISSUER_URL")
+	OIDCClientID        = os.Getenv("OIDC_CLIENT_ID")
+	OIDCClientSecret    = os.Getenv("OIDC_CLIENT_SECRET")
+	OIDCRedirectURL     = getEnv("OIDC_REDIRECT_URL", "http://localhost:8081/api/v1/auth/oidc/callback")
+	OIDCFrontendURL     = os.Getenv("OIDC_FRONTEND_URL") // receives the token as #token=...; JSON response if empty
+	OIDCScopes          = strings.Fields(getEnv("OIDC_SCOPES", "openid email profile"))
+	OIDCGroupsClaim     = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	OIDCGroupMappings   = parseGroupMappings(os.Getenv("OIDC_GROUP_MAPPINGS"))
+	OIDCLoginExpiration = time.Minute * 10 // time allowed between redirect and callback
+
+	// Transaction Ledger Configuration
+	ChainSigningKey         = os.Getenv("CHAIN_SIGNING_KEY")                             // base64 Ed25519 seed signing checkpoints
+	ChainSigningKeyFile     = getEnv("CHAIN_SIGNING_KEY_FILE", "data/chain-signing-key") // holds the seed when CHAIN_SIGNING_KEY is empty; generated once if missing
+	ChainCheckpointInterval = time.Hour                                                  // how often each shop's chain head is signed, when it moved
+
+	// Event Bus Configuration
+	EventOutboxFile       = getEnv("EVENT_OUTBOX_FILE", "data/outbox.jsonl") // events stay here until every subscriber handled them
+	EventHandlerTimeout   = time.Second * 10                                 // for one delivery to a subscriber
+	EventRetryBase        = time.Second                                      // delay before the first retry, doubled on each failure
+	EventRetryMax         = time.Minute * 5                                  // upper bound for the retry delay
+	EventMaxAttempts      = 10                                               // failed deliveries before a subscriber gives up on an event
+	EventOutboxCompaction = 1000                                             // records appended before settled events are removed from the file
+
+	// Logging Configuration
+	LogLevel  = getEnv("LOG_LEVEL", "info")  // debug, info, warn or error
+	LogFormat = getEnv("LOG_FORMAT", "text") // text or json
+
+	// Metrics Configuration
+	MetricsToken      = os.Getenv("METRICS_TOKEN") // bearer token required on /metrics; if empty, it is open but without business figures
+	LowStockThreshold = 5                          // products with less stock count as low stock
+
+	// Tracing Configuration (OTLP endpoint from the standard OTEL_EXPORTER_OTLP_* variables)
+	TraceExporter    = getEnv("OTEL_TRACES_EXPORTER", "none") // none, otlp or stdout
+	TraceServiceName = getEnv("OTEL_SERVICE_NAME", "shop-api")
+
 	// Server Configuration
-	ServerPort = ":8081"
+	ServerPort          = ":8081"
+	APIPrefix           = "/api/v1"      // unprefixed paths remain as deprecated aliases
+	MaxRequestBodyBytes = int64(1 << 20) // larger JSON bodies are rejected with 413
+	RequireIfMatch      = false          // when true, PUT/PATCH/DELETE without If-Match get 428
+
+	// CORS Configuration (the public catalog accepts any origin)
+	CORSAllowedOrigins   = parseList(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000")) // frontends allowed on the rest of the API
+	CORSAllowCredentials = getEnv("CORS_ALLOW_CREDENTIALS", "true") == "true"
+	CORSMaxAge           = time.Hour * 2 // how long browsers may cache a preflight
+
+	// TLS Configuration (HTTPS when both files are set, plain HTTP when neither is; one alone is an error)
+	TLSCertFile       = os.Getenv("TLS_CERT_FILE")
+	TLSKeyFile        = os.Getenv("TLS_KEY_FILE")
+	TLSReloadInterval = time.Second * 10                      // how often the files are checked for a renewed certificate
+	HTTPRedirectAddr  = getEnv("HTTP_REDIRECT_ADDR", ":8080") // with TLS on, plain HTTP listener redirecting to HTTPS; empty disables
+	HSTSMaxAge        = time.Hour * 24 * 365                  // on responses reaching the client over HTTPS; 0 disables
+
+	// Reverse Proxy Configuration
+	TrustedProxies = parseList(os.Getenv("TRUSTED_PROXIES")) // IPs or CIDRs whose X-Forwarded-For/-Proto are believed
+
+	// Frontend Configuration (static files served outside the API)
+	FrontendContentSecurityPolicy = "default-src 'self'; img-src 'self' data: https:; style-src 'self' 'unsafe-inline'; " +
+		"connect-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+
+	// Rate Limiting Configuration (token buckets; Requests = 0 disables a group)
+	RateLimitPublic  = RateLimit{Group: "public", Requests: 60, Period: time.Minute, Burst: 30}   // per IP
+	RateLimitAuth    = RateLimit{Group: "auth", Requests: 10, Period: time.Minute, Burst: 5}      // per IP, sign-in routes
+	RateLimitPrivate = RateLimit{Group: "private", Requests: 300, Period: time.Minute, Burst: 60} // per user or API key, in each shop
+
+	// Server Lifecycle Configuration
+	ReadHeaderTimeout = time.Second * 5
+	ReadTimeout       = time.Second * 15 // whole request, body included
+	WriteTimeout      = time.Second * 30 // from the end of the request headers to the end of the response
+	IdleTimeout       = time.Minute * 2  // keep-alive connections
+	ShutdownTimeout   = time.Second * 8  // drain time on SIGTERM, below Docker's 10s before SIGKILL
+	ReadinessTimeout  = time.Second * 2  // for every readiness check together
 )
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Requests per Period
+type RateLimit struct {
+	Group    string // route group; each has its own buckets
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// OIDCGroupMapping grants a role in a shop to members of an IdP group
+type OIDCGroupMapping struct {
+	Group  string
+	ShopID int
+	Role   string
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// parseList reads comma-separated values, ignoring blanks
+func parseList(value string) []string {
+	var values []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			values = append(values, entry)
+		}
+	}
+	return values
+}
+
+// parseGroupMappings reads "group=shopID:Role" pairs separated by commas,
+// e.g. "casablanca-managers=1:SuperAdmin,rabat-staff=2:Admin"
+func parseGroupMappings(value string) []OIDCGroupMapping {
+	var mappings []OIDCGroupMapping
+	for _, entry := range strings.Split(value, ",") {
+		group, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		shop, role, ok := strings.Cut(target, ":")
+		if !ok {
+			continue
+		}
+		shopID, err := strconv.Atoi(shop)
+		if err != nil {
+			continue
+		}
+		mappings = append(mappings, OIDCGroupMapping{Group: group, ShopID: shopID, Role: role})
+	}
+	return mappings
+}
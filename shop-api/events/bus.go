@@ -0,0 +1,377 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"shop-api/config"
+	"shop-api/metrics"
+	"shop-api/tracing"
+	"shop-api/utils"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Handler reacts to an event. An error makes the bus deliver the event again
+// later; as a delivery can also repeat after a restart, handlers must
+// tolerate duplicates, e.g. by remembering the event IDs they applied.
+type Handler func(ctx context.Context, event Event) error
+
+type subscriber struct {
+	name   string // identifies the subscriber in the outbox across restarts
+	types  map[string]bool
+	handle Handler
+}
+
+// pendingEvent is an event some subscribers have yet to handle
+type pendingEvent struct {
+	event   Event
+	settled map[string]bool // subscribers that handled it or gave up on it
+	waiting int             // subscribers still to settle, once deliveries are queued
+}
+
+// delivery is a pending event for one subscriber
+type delivery struct {
+	event      *pendingEvent
+	subscriber *subscriber
+	attempts   int
+	next       time.Time
+}
+
+// Bus delivers events to subscribers at least once. Publish appends the event
+// to an outbox file before returning, and it stays there until every
+// subscriber has handled it, so a restart resumes the deliveries it left.
+type Bus struct {
+	mu          sync.Mutex
+	outbox      *outbox
+	subscribers []*subscriber
+	pending     map[string]*pendingEvent
+	order       []string // pending event IDs, in publish order
+	queue       []*delivery
+	appended    int   // records appended since the last compaction
+	lastErr     error // last outbox write failure, cleared by a success
+	started     bool
+	running     bool
+	wake        chan struct{}
+	done        chan struct{}
+}
+
+// NewBus opens the outbox file. Events it holds are delivered again once Run
+// starts, to the subscribers that had not handled them.
+func NewBus(path string) (*Bus, error) {
+	outbox, records, err := openOutbox(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening event outbox: %w", err)
+	}
+
+	b := &Bus{
+		outbox:  outbox,
+		pending: map[string]*pendingEvent{},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for _, r := range records {
+		switch r.Op {
+		case opPublish:
+			if r.Event != nil {
+				b.pending[r.Event.ID] = &pendingEvent{event: *r.Event, settled: map[string]bool{}}
+				b.order = append(b.order, r.Event.ID)
+			}
+		case opAck, opDrop:
+			if pending, ok := b.pending[r.ID]; ok {
+				pending.settled[r.Subscriber] = true
+			}
+		}
+	}
+	return b, nil
+}
+
+// Subscribe registers a handler for the given event types. Subscribers must
+// be registered before Run, under a name that stays the same across releases.
+func (b *Bus) Subscribe(name string, handle Handler, types ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &subscriber{name: name, types: map[string]bool{}, handle: handle}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	b.subscribers = append(b.subscribers, s)
+}
+
+// On subscribes to one event type, with its payload decoded
+func On[T Payload](b *Bus, name string, handle func(ctx context.Context, event Event, payload T) error) {
+	var zero T
+	b.Subscribe(name, func(ctx context.Context, event Event) error {
+		var payload T
+		if err := event.Decode(&payload); err != nil {
+			return err
+		}
+		return handle(ctx, event, payload)
+	}, zero.EventType())
+}
+
+// Publish stores an event in the outbox and queues it for its subscribers.
+// It fails, and the caller must not apply its change, when the event could
+// not be stored.
+func (b *Bus) Publish(ctx context.Context, shopID int, payload Payload) error {
+	ctx, span := tracing.Start(ctx, "publish "+payload.EventType(), attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return tracing.Error(span, err)
+	}
+	id, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return tracing.Error(span, err)
+	}
+	event := Event{
+		ID:         id,
+		Type:       payload.EventType(),
+		ShopID:     shopID,
+		OccurredAt: time.Now(),
+		Payload:    data,
+		Trace:      tracing.Inject(ctx),
+	}
+	span.SetAttributes(attribute.String("event.id", id))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.append(record{Op: opPublish, Event: &event}); err != nil {
+		return tracing.Error(span, fmt.Errorf("storing event in outbox: %w", err))
+	}
+	metrics.RecordEventPublished(event.Type)
+
+	pending := &pendingEvent{event: event, settled: map[string]bool{}}
+	b.pending[id] = pending
+	b.order = append(b.order, id)
+	if b.running {
+		b.enqueue(pending)
+	}
+	return nil
+}
+
+// Run delivers events until ctx is done. Failed deliveries are retried with
+// an exponential backoff, until config.EventMaxAttempts.
+func (b *Bus) Run(ctx context.Context) {
+	defer close(b.done)
+
+	b.mu.Lock()
+	b.started, b.running = true, true
+	for _, id := range b.order {
+		b.enqueue(b.pending[id])
+	}
+	b.compact()
+	slog.Info("event bus started", "pending_deliveries", len(b.queue), "subscribers", len(b.subscribers))
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.running = false
+		b.mu.Unlock()
+	}()
+
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		due, wait := b.due(time.Now())
+		b.mu.Unlock()
+
+		for _, d := range due {
+			if ctx.Err() != nil {
+				break // still in the outbox for the next start
+			}
+			b.deliver(ctx, d)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-b.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Shutdown waits for Run to return, then removes settled events from the
+// outbox and closes it. Undelivered events are kept for the next start.
+func (b *Bus) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+
+	if started {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.compact()
+	return b.outbox.close()
+}
+
+// Ping reports, for readiness, whether events are being delivered and the
+// outbox can be written
+func (b *Bus) Ping(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.running {
+		return errors.New("event delivery is not running")
+	}
+	return b.lastErr
+}
+
+// enqueue queues deliveries of an event to the subscribers that have not
+// settled it yet; the caller holds the lock
+func (b *Bus) enqueue(pending *pendingEvent) {
+	for _, s := range b.subscribers {
+		if s.types[pending.event.Type] && !pending.settled[s.name] {
+			pending.waiting++
+			b.queue = append(b.queue, &delivery{event: pending, subscriber: s})
+		}
+	}
+	if pending.waiting == 0 {
+		// Nobody is interested any more: nothing left to deliver
+		delete(b.pending, pending.event.ID)
+		return
+	}
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due removes and returns the deliveries whose time has come, and how long
+// to wait for the next one; the caller holds the lock
+func (b *Bus) due(now time.Time) ([]*delivery, time.Duration) {
+	var due []*delivery
+	wait := time.Hour
+	remaining := b.queue[:0]
+	for _, d := range b.queue {
+		if !d.next.After(now) {
+			due = append(due, d)
+			continue
+		}
+		wait = min(wait, d.next.Sub(now))
+		remaining = append(remaining, d)
+	}
+	b.queue = remaining
+	return due, wait
+}
+
+func (b *Bus) deliver(ctx context.Context, d *delivery) {
+	event := d.event.event
+	err := b.handle(ctx, d.subscriber, event)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		metrics.RecordEventDelivery(d.subscriber.name, metrics.EventDelivered)
+		b.settle(d, opAck)
+		return
+	}
+
+	d.attempts++
+	if d.attempts >= config.EventMaxAttempts {
+		metrics.RecordEventDelivery(d.subscriber.name, metrics.EventDropped)
+		slog.Error("event dropped after repeated delivery failures", "event_id", event.ID, "event_type", event.Type,
+			"subscriber", d.subscriber.name, "attempts", d.attempts, "payload", string(event.Payload), "error", err)
+		b.settle(d, opDrop)
+		return
+	}
+
+	metrics.RecordEventDelivery(d.subscriber.name, metrics.EventFailed)
+	delay := min(config.EventRetryBase<<(d.attempts-1), config.EventRetryMax)
+	d.next = time.Now().Add(delay)
+	b.queue = append(b.queue, d)
+	slog.Warn("event delivery failed, retrying", "event_id", event.ID, "event_type", event.Type,
+		"subscriber", d.subscriber.name, "attempt", d.attempts, "retry_in", delay, "error", err)
+}
+
+// handle runs a subscriber's handler, turning a panic into an error
+func (b *Bus) handle(ctx context.Context, s *subscriber, event Event) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.EventHandlerTimeout)
+	defer cancel()
+
+	ctx, span := tracing.StartConsumer(ctx, event.Trace, "process "+event.Type,
+		attribute.String("event.id", event.ID), attribute.String("event.subscriber", s.name), attribute.Int("shop.id", event.ShopID))
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+		if err != nil {
+			tracing.Error(span, err)
+		}
+	}()
+	return s.handle(ctx, event)
+}
+
+// settle records that a subscriber is done with an event; the caller holds the lock
+func (b *Bus) settle(d *delivery, op string) {
+	pending := d.event
+	if err := b.append(record{Op: op, ID: pending.event.ID, Subscriber: d.subscriber.name}); err != nil {
+		// The event is delivered again after a restart, which handlers tolerate
+		slog.Error("recording event delivery in outbox failed", "event_id", pending.event.ID, "subscriber", d.subscriber.name, "error", err)
+	}
+
+	pending.settled[d.subscriber.name] = true
+	pending.waiting--
+	if pending.waiting == 0 {
+		delete(b.pending, pending.event.ID)
+	}
+	if b.appended >= config.EventOutboxCompaction {
+		b.compact()
+	}
+}
+
+func (b *Bus) append(r record) error {
+	err := b.outbox.append(r)
+	b.lastErr = err
+	if err == nil {
+		b.appended++
+	}
+	return err
+}
+
+// compact rewrites the outbox with the pending events only; the caller holds the lock
+func (b *Bus) compact() {
+	var records []record
+	order := b.order[:0]
+	for _, id := range b.order {
+		pending, ok := b.pending[id]
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		records = append(records, record{Op: opPublish, Event: &pending.event})
+		for name := range pending.settled {
+			records = append(records, record{Op: opAck, ID: id, Subscriber: name})
+		}
+	}
+	b.order = order
+
+	if err := b.outbox.rewrite(records); err != nil {
+		slog.Error("compacting event outbox failed", "error", err)
+		return
+	}
+	b.appended = 0
+}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"shop-api/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBusRetriesFailedDeliveries(t *testing.T) {
+	previous := config.EventRetryBase
+	config.EventRetryBase = time.Millisecond
+	t.Cleanup(func() { config.EventRetryBase = previous })
+
+	bus := openTestBus(t, filepath.Join(t.TempDir(), "outbox.jsonl"))
+	var attempts atomic.Int32
+	delivered := make(chan ProductCreated, 1)
+	On(bus, "test", func(ctx context.Context, event Event, created ProductCreated) error {
+		if attempts.Add(1) == 1 {
+			return errors.New("temporary failure")
+		}
+		delivered <- created
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go bus.Run(ctx)
+
+	if err := bus.Publish(context.Background(), 1, ProductCreated{ProductID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case created := <-delivered:
+		if created.ProductID != 7 {
+			t.Errorf("product ID = %d, want 7", created.ProductID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	cancel()
+	if err := bus.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("%d attempts, want 2", n)
+	}
+}
+
+func TestBusReplaysUndeliveredEventsAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	// First run: one event is handled, then the process stops before the
+	// other two are delivered
+	bus := openTestBus(t, path)
+	delivered := make(chan SaleRecorded, 3)
+	On(bus, "test", func(ctx context.Context, event Event, sale SaleRecorded) error {
+		delivered <- sale
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go bus.Run(ctx)
+	if err := bus.Publish(context.Background(), 1, SaleRecorded{TransactionID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-delivered
+	cancel()
+	if err := bus.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	bus = openTestBus(t, path)
+	for i := 2; i <= 3; i++ {
+		if err := bus.Publish(context.Background(), 1, SaleRecorded{TransactionID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bus.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run: only the undelivered events are replayed, in order
+	bus = openTestBus(t, path)
+	var replayed []int
+	done := make(chan struct{})
+	On(bus, "test", func(ctx context.Context, event Event, sale SaleRecorded) error {
+		replayed = append(replayed, sale.TransactionID)
+		if len(replayed) == 2 {
+			close(done)
+		}
+		return nil
+	})
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("replayed %v, want [2 3]", replayed)
+	}
+	if replayed[0] != 2 || replayed[1] != 3 {
+		t.Errorf("replayed %v, want [2 3]", replayed)
+	}
+}
+
+func TestBusPublishFailsWhenOutboxCannotBeWritten(t *testing.T) {
+	bus := openTestBus(t, filepath.Join(t.TempDir(), "outbox.jsonl"))
+	bus.outbox.file.Close()
+
+	if err := bus.Publish(context.Background(), 1, ProductCreated{ProductID: 7}); err == nil {
+		t.Fatal("publish succeeded without storing the event")
+	}
+	if len(bus.pending) != 0 {
+		t.Errorf("%d events pending, want none", len(bus.pending))
+	}
+}
+
+// openTestBus opens a bus on the outbox at path
+func openTestBus(t *testing.T, path string) *Bus {
+	t.Helper()
+
+	bus, err := NewBus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bus
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Event types
+const (
+	TypeSaleRecorded   = "sale.recorded"
+	TypeStockChanged   = "stock.changed"
+	TypeProductCreated = "product.created"
+	TypeShopUpdated    = "shop.updated"
+)
+
+// Payload is the typed content of an event
+type Payload interface {
+	EventType() string
+}
+
+// Publisher records events. Services publish while holding their own lock,
+// before applying the change, so an event is stored if and only if the change
+// it describes is.
+type Publisher interface {
+	Publish(ctx context.Context, shopID int, payload Payload) error
+}
+
+// Event is the envelope stored in the outbox and handed to subscribers
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	ShopID     int               `json:"shop_id"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Payload    json.RawMessage   `json:"payload"`
+	Trace      map[string]string `json:"trace,omitempty"` // trace context of the publishing request
+}
+
+// Decode unmarshals the payload into v
+func (e Event) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// SaleRecorded follows a sale transaction
+type SaleRecorded struct {
+	TransactionID int     `json:"transaction_id"`
+	ProductID     int     `json:"product_id"`
+	Quantity      int     `json:"quantity"`
+	Amount        float64 `json:"amount"`
+}
+
+func (SaleRecorded) EventType() string { return TypeSaleRecorded }
+
+// StockChanged follows any change of a product's stock
+type StockChanged struct {
+	ProductID int    `json:"product_id"`
+	Before    int    `json:"before"`
+	After     int    `json:"after"`
+	Reason    string `json:"reason"` // "sale" or "update"
+}
+
+func (StockChanged) EventType() string { return TypeStockChanged }
+
+// ProductCreated follows the creation of a product
+type ProductCreated struct {
+	ProductID    int     `json:"product_id"`
+	Name         string  `json:"name"`
+	Category     string  `json:"category"`
+	SellingPrice float64 `json:"selling_price"`
+	Stock        int     `json:"stock"`
+}
+
+func (ProductCreated) EventType() string { return TypeProductCreated }
+
+// ShopUpdated follows a change of a shop's settings, and carries them all
+type ShopUpdated struct {
+	WhatsAppNumber   string `json:"whatsapp_number"`
+	RequireTwoFactor bool   `json:"require_two_factor"`
+	Version          int    `json:"version"`
+}
+
+func (ShopUpdated) EventType() string { return TypeShopUpdated }
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Outbox record operations
+const (
+	opPublish = "publish" // an event was published
+	opAck     = "ack"     // a subscriber handled it
+	opDrop    = "drop"    // a subscriber gave up on it after repeated failures
+)
+
+// record is one line of the outbox file
+type record struct {
+	Op         string `json:"op"`
+	Event      *Event `json:"event,omitempty"`
+	ID         string `json:"id,omitempty"` // event acknowledged or dropped
+	Subscriber string `json:"subscriber,omitempty"`
+}
+
+// outbox is an append-only JSON Lines file. Each record is synced to disk
+// before the call returns.
+type outbox struct {
+	path string
+	file *os.File
+}
+
+// openOutbox opens the file, creating it if needed, and returns the records
+// it holds. A line cut short by a crash is skipped.
+func openOutbox(path string) (*outbox, []record, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	var records []record
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var r record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				slog.Warn("skipping unreadable outbox record", "file", path, "error", err)
+				continue
+			}
+			records = append(records, r)
+		}
+		err := scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &outbox{path: path, file: file}, records, nil
+}
+
+func (o *outbox) append(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+// rewrite replaces the file with records, atomically
+func (o *outbox) rewrite(records []record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	o.file.Close()
+	o.file = file
+	return nil
+}
+
+func (o *outbox) close() error {
+	return o.file.Close()
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"strconv"
+)
+
+type APIKeyHandler struct {
+	apiKeyService services.APIKeyService
+	auditService  services.AuditService
+}
+
+func NewAPIKeyHandler(apiKeyService services.APIKeyService, auditService services.AuditService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		auditService:  auditService,
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string         `json:"name" validate:"required,max=100"`
+	Scopes []models.Scope `json:"scopes" validate:"required,max=20"`
+}
+
+// CreateAPIKeyResponse includes the clear-text key, which is never shown again
+type CreateAPIKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// GetAll - GET /api-keys (SuperAdmin only)
+func (h *APIKeyHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	keys := h.apiKeyService.GetByShopID(claims.ShopID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// Create - POST /api-keys (SuperAdmin only)
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	apiKey, key, err := h.apiKeyService.Create(claims.ShopID, req.Name, req.Scopes, claims.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "api_key.create", Entity: "api_key", EntityID: apiKey.ID}, nil, apiKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{
+		APIKey: *apiKey,
+		Key:    key,
+	})
+}
+
+// Revoke - DELETE /api-keys/{id} (SuperAdmin only)
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid API key ID")
+		return
+	}
+
+	if err := h.apiKeyService.Revoke(claims.ShopID, id); err != nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "API key not found")
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "api_key.revoke", Entity: "api_key", EntityID: id}, nil, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"strconv"
+	"time"
+)
+
+type AuditHandler struct {
+	auditService services.AuditService
+}
+
+func NewAuditHandler(auditService services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+// AuditQuery lists the filters of GET /audit
+type AuditQuery struct {
+	Actor    int    `query:"actor"`     // user ID
+	APIKey   int    `query:"api_key"`   // API key ID
+	Action   string `query:"action"`    // e.g. product.update
+	Entity   string `query:"entity"`    // e.g. product
+	EntityID int    `query:"entity_id"` // requires entity
+	From     string `query:"from"`      // date (2024-05-01) or RFC 3339 time, inclusive
+	To       string `query:"to"`        // date, inclusive, or RFC 3339 time, exclusive
+	Limit    int    `query:"limit"`     // 100 by default, at most 1000
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAll - GET /audit (SuperAdmin only)
+// Lists the active shop's audit entries, newest first
+func (h *AuditHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		ShopID: claims.ShopID,
+		Action: query.Get("action"),
+		Entity: query.Get("entity"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var fieldErrors []problem.FieldError
+	parseInt := func(name string, target *int) {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				fieldErrors = append(fieldErrors, problem.FieldError{Field: name, Code: "invalid", Message: "must be a positive integer"})
+				return
+			}
+			*target = n
+		}
+	}
+	parseTime := func(name string, target *time.Time, endOfDay bool) {
+		if value := query.Get(name); value != "" {
+			t, err := parseAuditTime(value, endOfDay)
+			if err != nil {
+				fieldErrors = append(fieldErrors, problem.FieldError{Field: name, Code: "invalid", Message: "must be a date (YYYY-MM-DD) or an RFC 3339 time"})
+				return
+			}
+			*target = t
+		}
+	}
+	parseInt("actor", &filter.ActorID)
+	parseInt("api_key", &filter.APIKeyID)
+	parseInt("entity_id", &filter.EntityID)
+	parseInt("limit", &filter.Limit)
+	parseTime("from", &filter.From, false)
+	parseTime("to", &filter.To, true)
+
+	if filter.EntityID != 0 && filter.Entity == "" {
+		fieldErrors = append(fieldErrors, problem.FieldError{Field: "entity", Code: "required", Message: "is required with entity_id"})
+	}
+	if filter.Limit > maxAuditLimit {
+		fieldErrors = append(fieldErrors, problem.FieldError{Field: "limit", Code: "max", Message: "must be at most " + strconv.Itoa(maxAuditLimit)})
+	}
+	if len(fieldErrors) > 0 {
+		writeFieldErrors(w, r, fieldErrors...)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.auditService.List(filter))
+}
+
+// parseAuditTime reads an RFC 3339 time, or a date meaning its start, or with
+// endOfDay the start of the next day, so that "to" includes the whole date
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// recordAudit appends a successful mutating call to the audit log, with the
+// caller and IP of the request. before and after are states of the entity
+// (nil before a create or after a delete), compared field by field.
+func recordAudit(audit services.AuditService, r *http.Request, entry models.AuditEntry, before, after any) {
+	if claims, ok := middleware.GetClaims(r); ok {
+		entry.ActorID, entry.APIKeyID = claims.UserID, claims.APIKeyID
+		if entry.ShopID == 0 {
+			entry.ShopID = claims.ShopID
+		}
+	}
+	entry.IP = middleware.ClientIP(r)
+	entry.Changes = services.AuditChanges(before, after)
+	audit.Record(entry)
+}
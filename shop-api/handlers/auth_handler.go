@@ -2,34 +2,44 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"shop-api/middleware"
 	"shop-api/models"
+	"shop-api/problem"
 	"shop-api/services"
+	"shop-api/utils"
+	"strconv"
 )
 
 type AuthHandler struct {
-	userService services.UserService
-	shopService services.ShopService
+	userService       services.UserService
+	shopService       services.ShopService
+	membershipService services.MembershipService
+	auditService      services.AuditService
 }
 
-func NewAuthHandler(userService services.UserService, shopService services.ShopService) *AuthHandler {
+func NewAuthHandler(userService services.UserService, shopService services.ShopService, membershipService services.MembershipService, auditService services.AuditService) *AuthHandler {
 	return &AuthHandler{
-		userService: userService,
-		shopService: shopService,
+		userService:       userService,
+		shopService:       shopService,
+		membershipService: membershipService,
+		auditService:      auditService,
 	}
 }
 
 type RegisterRequest struct {
-	Name     string      `json:"name"`
-	Email    string      `json:"email"`
-	Password string      `json:"password"`
-	Role     models.Role `json:"role"`
-	ShopID   int         `json:"shop_id"`
+	Name     string      `json:"name" validate:"required,max=100"`
+	Email    string      `json:"email" validate:"required,email,max=254"`
+	Password string      `json:"password" validate:"required"`
+	Role     models.Role `json:"role" validate:"oneof=SuperAdmin Admin"` // Admin when empty; only Admin is granted
+	ShopID   int         `json:"shop_id" validate:"required,min=1"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,max=254"`
+	Password string `json:"password" validate:"required"`
 }
 
 type AuthResponse struct {
@@ -37,39 +47,76 @@ type AuthResponse struct {
 	Token string              `json:"token"`
 }
 
+// TwoFactorChallengeResponse is returned by /login when a second factor is needed
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=32"` // TOTP code or recovery code
+}
+
+type TOTPSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TOTPDisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type SwitchShopRequest struct {
+	ShopID int `json:"shop_id" validate:"required,min=1"`
+}
+
+type SwitchShopResponse struct {
+	User       models.UserResponse `json:"user"`
+	Membership models.Membership   `json:"membership"`
+	Token      string              `json:"token"`
+}
+
 // Register - POST /register
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Validate input
-	if req.Name == "" || req.Email == "" || req.Password == "" {
-		http.Error(w, `{"error": "Name, email, and password are required"}`, http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Validate role
-	if req.Role != models.RoleSuperAdmin && req.Role != models.RoleAdmin {
-		http.Error(w, `{"error": "Invalid role. Must be SuperAdmin or Admin"}`, http.StatusBadRequest)
+	// Anyone may register in any shop, so only the lowest role is on offer:
+	// a SuperAdmin of the shop promotes members through PUT /users/{id}
+	if req.Role == models.RoleSuperAdmin {
+		p := problem.New(http.StatusForbidden, problem.CodeForbidden, "registration only grants the Admin role")
+		p.Errors = []problem.FieldError{{Field: "role", Code: "forbidden", Message: "must be Admin; a SuperAdmin of the shop can promote you"}}
+		problem.Write(w, r, p)
 		return
 	}
 
 	// Validate shop exists
 	_, err := h.shopService.GetByID(req.ShopID)
 	if err != nil {
-		http.Error(w, `{"error": "Shop not found"}`, http.StatusBadRequest)
+		writeFieldErrors(w, r, problem.FieldError{Field: "shop_id", Code: "not_found", Message: "does not match an existing shop"})
 		return
 	}
 
 	// Register user
-	user, err := h.userService.Register(req.Name, req.Email, req.Password, req.Role, req.ShopID)
+	user, err := h.userService.Register(req.Name, req.Email, req.Password, models.RoleAdmin, req.ShopID)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		writePasswordError(w, r, err, "password")
 		return
 	}
+	// Registration is anonymous: the new user is the actor
+	recordAudit(h.auditService, r, models.AuditEntry{ShopID: req.ShopID, ActorID: user.ID, Action: "user.register", Entity: "user", EntityID: user.ID}, nil, user.ToResponse())
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -79,21 +126,194 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 // Login - POST /login
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Validate input
-	if req.Email == "" || req.Password == "" {
-		http.Error(w, `{"error": "Email and password are required"}`, http.StatusBadRequest)
+	// Login
+	user, token, err := h.userService.Login(req.Email, req.Password, middleware.ClientIP(r))
+	if writeThrottled(w, r, err) {
+		return
+	}
+	if errors.Is(err, services.ErrTwoFactorRequired) {
+		writeTwoFactorChallenge(w, r, user)
+		return
+	}
+	if err != nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
-	// Login
-	user, token, err := h.userService.Login(req.Email, req.Password)
+	response := AuthResponse{
+		User:  user.ToResponse(),
+		Token: token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SwitchShop - POST /shops/switch (private - requires auth)
+func (h *AuthHandler) SwitchShop(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SwitchShopRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, membership, token, err := h.userService.SwitchShop(claims.UserID, req.ShopID, claims.TwoFactor)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid credentials"}`, http.StatusUnauthorized)
+		writeError(w, r, err)
+		return
+	}
+
+	response := SwitchShopResponse{
+		User:       user.ToResponse(),
+		Membership: *membership,
+		Token:      token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MyShops - GET /me/shops (private - requires auth)
+func (h *AuthHandler) MyShops(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	memberships := h.membershipService.GetByUserID(claims.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memberships)
+}
+
+// MyInvitations - GET /me/invitations (private - requires auth)
+func (h *AuthHandler) MyInvitations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	invitations := h.membershipService.GetInvitations(claims.UserID)
+	if invitations == nil {
+		invitations = []models.Invitation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitation - POST /me/invitations/{id}/accept (private - requires auth)
+// Joins the inviting shop; switch to it with POST /shops/switch
+func (h *AuthHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid invitation ID")
+		return
+	}
+
+	membership, err := h.membershipService.AcceptInvitation(id, claims.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Audited in the shop joined, where its SuperAdmins look for it
+	recordAudit(h.auditService, r, models.AuditEntry{ShopID: membership.ShopID, Action: "membership.create", Entity: "membership", EntityID: membership.ID},
+		nil, membership)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(membership)
+}
+
+// DeclineInvitation - POST /me/invitations/{id}/decline (private - requires auth)
+func (h *AuthHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid invitation ID")
+		return
+	}
+
+	invitation, err := h.membershipService.DeclineInvitation(id, claims.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{ShopID: invitation.ShopID, Action: "membership.decline", Entity: "invitation", EntityID: invitation.ID},
+		invitation, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeThrottled answers 429 with Retry-After when err is a login throttling error
+func writeThrottled(w http.ResponseWriter, r *http.Request, err error) bool {
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	problem.Error(w, r, http.StatusTooManyRequests, problem.CodeTooManyAttempts, "Too many failed login attempts, try again later")
+	return true
+}
+
+// writeTwoFactorChallenge answers a first login step that needs a second factor
+func writeTwoFactorChallenge(w http.ResponseWriter, r *http.Request, user *models.User) {
+	challenge, err := utils.GenerateTwoFactorChallenge(user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+// LoginTwoFactor - POST /login/2fa
+// Second login step: exchanges the challenge token and a TOTP or recovery code for a JWT
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	userID, err := utils.ValidateTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid or expired challenge token")
+		return
+	}
+
+	user, token, err := h.userService.CompleteTwoFactorLogin(userID, req.Code, middleware.ClientIP(r))
+	if writeThrottled(w, r, err) {
+		return
+	}
+	if err != nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid two-factor code")
 		return
 	}
 
@@ -105,3 +325,80 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// SetupTOTP - POST /me/2fa/setup (private - requires auth)
+// Returns a new secret and its otpauth:// URI to render as a QR code
+func (h *AuthHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	secret, uri, err := h.userService.BeginTOTPEnrollment(claims.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.2fa_setup", Entity: "user", EntityID: claims.UserID}, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+	})
+}
+
+// EnableTOTP - POST /me/2fa/enable (private - requires auth)
+// Confirms enrollment with a first code and returns the recovery codes, shown only once
+func (h *AuthHandler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TOTPCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	recoveryCodes, err := h.userService.EnableTOTP(claims.UserID, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.2fa_enable", Entity: "user", EntityID: claims.UserID},
+		map[string]bool{"two_factor_enabled": false}, map[string]bool{"two_factor_enabled": true})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// DisableTOTP - POST /me/2fa/disable (private - requires auth)
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TOTPDisableRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.userService.DisableTOTP(claims.UserID, req.Password, req.Code); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.2fa_disable", Entity: "user", EntityID: claims.UserID},
+		map[string]bool{"two_factor_enabled": true}, map[string]bool{"two_factor_enabled": false})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor authentication disabled",
+	})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/config"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAuthHandler(t *testing.T) (*AuthHandler, services.UserService, services.MembershipService) {
+	t.Helper()
+	previous := config.PasswordBcryptCost
+	config.PasswordBcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { config.PasswordBcryptCost = previous })
+
+	memberships := services.NewMembershipService()
+	users := services.NewUserService(memberships, services.NewLoginAttemptService())
+	handler := NewAuthHandler(users, services.NewShopService(discardEvents{}), memberships, services.NewAuditService())
+	return handler, users, memberships
+}
+
+func register(handler *AuthHandler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.Register(w, httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(body)))
+	return w
+}
+
+func TestRegisterGrantsOnlyTheAdminRole(t *testing.T) {
+	tests := []struct {
+		name string
+		role string // omitted when empty
+	}{
+		{"role left out", ""},
+		{"Admin", `"Admin"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _, memberships := newTestAuthHandler(t)
+			body := `{"name": "Jane", "email": "jane@shop2.com", "password": "Correct7Horse", "shop_id": 2`
+			if tt.role != "" {
+				body += `, "role": ` + tt.role
+			}
+
+			w := register(handler, body+"}")
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201 (%s)", w.Code, w.Body.String())
+			}
+			var user models.UserResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+				t.Fatal(err)
+			}
+			membership, err := memberships.Get(user.ID, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != models.RoleAdmin || membership.Role != models.RoleAdmin {
+				t.Errorf("registered as %s with a %s membership, want Admin", user.Role, membership.Role)
+			}
+		})
+	}
+}
+
+func TestRegisterRefusesSuperAdmin(t *testing.T) {
+	handler, users, _ := newTestAuthHandler(t)
+
+	w := register(handler, `{"name": "Mallory", "email": "mallory@example.com", "password": "Correct7Horse", "role": "SuperAdmin", "shop_id": 1}`)
+
+	p := decodeProblem(t, w)
+	if w.Code != http.StatusForbidden || p.Code != problem.CodeForbidden {
+		t.Fatalf("got %d %s, want 403 %s", w.Code, p.Code, problem.CodeForbidden)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "role" {
+		t.Errorf("errors = %+v, want the role field", p.Errors)
+	}
+	if _, err := users.GetByEmail("mallory@example.com"); err == nil {
+		t.Error("the account was created")
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"shop-api/logging"
+	"shop-api/problem"
+	"shop-api/services"
+	"shop-api/utils"
+)
+
+// writeError maps a service error to its problem+json response. Every
+// handler goes through here so a sentinel always yields the same status and code.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var policy *utils.PasswordPolicyError
+	if errors.As(err, &policy) {
+		problem.Error(w, r, http.StatusUnprocessableEntity, problem.CodeValidationFailed, policy.Error())
+		return
+	}
+
+	status, code := http.StatusInternalServerError, problem.CodeInternal
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		status, code = http.StatusNotFound, problem.CodeNotFound
+	case errors.Is(err, services.ErrConflict):
+		status, code = http.StatusConflict, problem.CodeConflict
+	case errors.Is(err, services.ErrVersionConflict):
+		status, code = http.StatusPreconditionFailed, problem.CodePreconditionFailed
+	case errors.Is(err, services.ErrInsufficientStock):
+		status, code = http.StatusConflict, problem.CodeInsufficientStock
+	case errors.Is(err, services.ErrForbiddenTenant):
+		status, code = http.StatusForbidden, problem.CodeForbiddenTenant
+	case errors.Is(err, services.ErrInvalidCredentials):
+		status, code = http.StatusUnauthorized, problem.CodeInvalidCredentials
+	case errors.Is(err, services.ErrAccountDisabled):
+		status, code = http.StatusForbidden, problem.CodeAccountDisabled
+	case errors.Is(err, services.ErrInvalidInput):
+		status, code = http.StatusBadRequest, problem.CodeInvalidInput
+	}
+
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		// Unexpected errors are logged, not leaked to the client
+		logging.FromContext(r.Context()).Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		detail = "internal server error"
+	}
+	problem.Error(w, r, status, code, detail)
+}
+
+// writePasswordError is writeError for handlers that validate a password: policy
+// violations are reported against the request field that carried it
+func writePasswordError(w http.ResponseWriter, r *http.Request, err error, field string) {
+	var policy *utils.PasswordPolicyError
+	if !errors.As(err, &policy) {
+		writeError(w, r, err)
+		return
+	}
+
+	p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed, "password does not meet the policy")
+	for _, message := range policy.Problems {
+		p.Errors = append(p.Errors, problem.FieldError{Field: field, Code: "password_policy", Message: message})
+	}
+	problem.Write(w, r, p)
+}
+
+// writeInvalidJSON answers a request body that could not be decoded
+func writeInvalidJSON(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "request body is not valid JSON")
+}
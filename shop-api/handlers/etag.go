@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"shop-api/config"
+	"shop-api/problem"
+	"strconv"
+	"strings"
+)
+
+// etag formats a resource version as a strong entity tag
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion checks If-Match against the current version of a resource.
+// It returns the version the write must still find (0 for an unconditional
+// write), or writes 412/428 and returns false.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, current int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if config.RequireIfMatch {
+			problem.Error(w, r, http.StatusPreconditionRequired, problem.CodePreconditionRequired,
+				"send If-Match with the ETag of the resource you are changing")
+			return 0, false
+		}
+		return 0, true
+	}
+	if strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+
+	// If-Match uses the strong comparison: weak tags never match
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(current) {
+			return current, true
+		}
+	}
+
+	w.Header().Set("ETag", etag(current))
+	problem.Error(w, r, http.StatusPreconditionFailed, problem.CodePreconditionFailed,
+		"the resource has changed since it was read, fetch it again")
+	return 0, false
+}
+
+// writeCached writes a JSON body with an ETag derived from its content,
+// answering 304 when it matches If-None-Match
+func writeCached(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache") // cache, but revalidate every time
+
+	if noneMatch(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// noneMatch reports whether an If-None-Match header matches tag, using the weak comparison
+func noneMatch(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
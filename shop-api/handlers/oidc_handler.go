@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"shop-api/config"
+	"shop-api/logging"
+	"shop-api/middleware"
+	"shop-api/problem"
+	"shop-api/services"
+	"shop-api/utils"
+)
+
+// oidcStateCookie holds the state of the login started by this browser, so a
+// callback carrying someone else's code and state is refused
+const oidcStateCookie = "shop_oidc_state"
+
+type OIDCHandler struct {
+	oidcService services.OIDCService
+}
+
+func NewOIDCHandler(oidcService services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: oidcService,
+	}
+}
+
+// Login - GET /auth/oidc/login
+// Redirects the browser to the identity provider
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.oidcService.AuthCodeURL(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("identity provider unavailable", "error", err)
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeBadGateway, "Identity provider unavailable")
+		return
+	}
+
+	// Lax, because the callback is a cross-site top-level redirect from the IdP
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(config.OIDCLoginExpiration.Seconds()),
+		HttpOnly: true,
+		Secure:   middleware.IsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback - GET /auth/oidc/callback
+// The identity provider redirects here with an authorization code
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Identity provider returned "+url.QueryEscape(errorCode))
+		return
+	}
+
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Code and state are required")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   middleware.IsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		logging.FromContext(r.Context()).Warn("single sign-on failed", "error", "state does not match this browser")
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Login was not started from this browser")
+		return
+	}
+
+	user, token, err := h.oidcService.Exchange(r.Context(), code, state)
+	if errors.Is(err, services.ErrTwoFactorRequired) {
+		// Same second step as a password login, through POST /login/2fa
+		if config.OIDCFrontendURL != "" {
+			challenge, err := utils.GenerateTwoFactorChallenge(user)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, config.OIDCFrontendURL+"#challenge_token="+url.QueryEscape(challenge), http.StatusFound)
+			return
+		}
+		writeTwoFactorChallenge(w, r, user)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("single sign-on failed", "error", err)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Single sign-on failed")
+		return
+	}
+
+	// Hand the token to the frontend in the fragment, which never reaches servers or logs
+	if config.OIDCFrontendURL != "" {
+		http.Redirect(w, r, config.OIDCFrontendURL+"#token="+url.QueryEscape(token), http.StatusFound)
+		return
+	}
+
+	response := AuthResponse{
+		User:  user.ToResponse(),
+		Token: token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
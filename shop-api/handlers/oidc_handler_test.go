@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/models"
+	"shop-api/services"
+	"testing"
+)
+
+// stubOIDCService starts logins with a fixed state and records the exchanges
+type stubOIDCService struct {
+	state     string
+	user      *models.User
+	err       error
+	exchanged []string
+}
+
+func (s *stubOIDCService) AuthCodeURL(ctx context.Context) (string, string, error) {
+	return "https://idp.example/authorize?state=" + s.state, s.state, nil
+}
+
+func (s *stubOIDCService) Exchange(ctx context.Context, code, state string) (*models.User, string, error) {
+	s.exchanged = append(s.exchanged, state)
+	if s.err != nil {
+		return s.user, "", s.err
+	}
+	return s.user, "session-token", nil
+}
+
+func startOIDCLogin(t *testing.T, handler *OIDCHandler) *http.Cookie {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.Login(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302", rec.Code)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("state cookie is not HttpOnly and SameSite=Lax: %+v", cookie)
+			}
+			return cookie
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+	return nil
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	user := &models.User{ID: 7, Email: "sso@shop1.com", Active: true}
+
+	tests := []struct {
+		name   string
+		cookie string // "" sends none
+		want   int
+	}{
+		{"same browser", "browser-state", http.StatusOK},
+		{"no cookie", "", http.StatusUnauthorized},
+		{"another login's cookie", "other-state", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oidcSvc := &stubOIDCService{state: "browser-state", user: user}
+			handler := NewOIDCHandler(oidcSvc)
+			cookie := startOIDCLogin(t, handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?code=abc&state=browser-state", nil)
+			if tt.cookie != "" {
+				cookie.Value = tt.cookie
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			handler.Callback(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if exchanged := len(oidcSvc.exchanged) > 0; exchanged != (tt.want == http.StatusOK) {
+				t.Errorf("code exchanged = %v", exchanged)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackAsksForSecondFactor(t *testing.T) {
+	oidcSvc := &stubOIDCService{
+		state: "browser-state",
+		user:  &models.User{ID: 1, Email: "super@shop1.com", Active: true, TOTPEnabled: true},
+		err:   services.ErrTwoFactorRequired,
+	}
+	handler := NewOIDCHandler(oidcSvc)
+	cookie := startOIDCLogin(t, handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?code=abc&state=browser-state", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	handler.Callback(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var response TwoFactorChallengeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.TwoFactorRequired || response.ChallengeToken == "" {
+		t.Errorf("response = %+v, want a two-factor challenge", response)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"shop-api/config"
+	"shop-api/ledger"
+	"shop-api/models"
+	"shop-api/openapi"
+	"shop-api/services"
+)
+
+// message is the body of operations that only confirm a change
+type message = map[string]string
+
+// PatchProductRequest documents the merge patch of PATCH /products/{id}: the
+// fields of CreateProductRequest, each optional, null resetting it
+type PatchProductRequest CreateProductRequest
+
+// Operations describes every API route for the OpenAPI document. The app
+// tests fail when it disagrees with the routes registered in package app, or
+// with the access and scopes those routes enforce.
+func Operations() []openapi.Operation {
+	ops := []openapi.Operation{
+		// Auth
+		{Method: "POST", Path: "/register", Tag: "Auth", Summary: "Create a user with the Admin role in a shop", Access: openapi.Public,
+			Request: RegisterRequest{}, Response: models.UserResponse{}, Status: http.StatusCreated},
+		{Method: "POST", Path: "/login", Tag: "Auth", Summary: "Sign in; answers a two-factor challenge instead of a token when a second factor is needed", Access: openapi.Public,
+			Request: LoginRequest{}, Response: AuthResponse{}},
+		{Method: "POST", Path: "/login/2fa", Tag: "Auth", Summary: "Complete sign-in with a TOTP or recovery code", Access: openapi.Public,
+			Request: TwoFactorLoginRequest{}, Response: AuthResponse{}},
+		{Method: "POST", Path: "/password/reset", Tag: "Auth", Summary: "Set a new password with a reset token", Access: openapi.Public,
+			Request: ResetPasswordRequest{}, Response: message{}},
+
+		// Public catalog
+		{Method: "GET", Path: "/public/{shopID}/products", Tag: "Public", Summary: "List a shop's catalog with WhatsApp order links", Access: openapi.Public,
+			Response: []models.PublicProductResponse{}, Conditional: true},
+
+		// Products
+		{Method: "GET", Path: "/products", Tag: "Products", Summary: "List the active shop's products; purchase_price is only shown to SuperAdmins", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsRead}, Response: []models.Product{}},
+		{Method: "POST", Path: "/products", Tag: "Products", Summary: "Create a product; only SuperAdmins may set purchase_price", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsWrite}, Request: CreateProductRequest{}, Response: models.Product{}, Status: http.StatusCreated, Idempotent: true},
+		{Method: "GET", Path: "/products/{id}", Tag: "Products", Summary: "Get a product", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsRead}, Response: models.Product{}, Conditional: true},
+		{Method: "PUT", Path: "/products/{id}", Tag: "Products", Summary: "Replace a product", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsWrite}, Request: CreateProductRequest{}, Response: models.Product{}, Conditional: true},
+		{Method: "PATCH", Path: "/products/{id}", Tag: "Products", Summary: "Change some fields of a product (JSON Merge Patch)", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsWrite}, Request: PatchProductRequest{}, RequestType: "application/merge-patch+json", Response: models.Product{}, Conditional: true},
+		{Method: "DELETE", Path: "/products/{id}", Tag: "Products", Summary: "Delete a product", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeProductsWrite}, Status: http.StatusNoContent, Conditional: true},
+
+		// Transactions
+		{Method: "GET", Path: "/transactions", Tag: "Transactions", Summary: "List the active shop's transactions", Access: openapi.Admin,
+			Scopes: []models.Scope{models.ScopeTransactionsRead}, Response: []models.Transaction{}},
+		{Method: "POST", Path: "/transactions", Tag: "Transactions", Summary: "Record a sale, expense or withdrawal", Access: openapi.Admin,
+			Scopes: []models.Scope{models.ScopeTransactionsWrite}, Request: CreateTransactionRequest{}, Response: models.Transaction{}, Status: http.StatusCreated, Idempotent: true},
+		{Method: "GET", Path: "/reports/dashboard", Tag: "Transactions", Summary: "Sales and profit totals of the active shop", Access: openapi.SuperAdmin,
+			Scopes: []models.Scope{models.ScopeReportsRead}, Response: services.DashboardStats{}},
+		{Method: "GET", Path: "/reports/sales", Tag: "Transactions", Summary: "Sales totals of the active shop per day, newest first", Access: openapi.SuperAdmin,
+			Scopes: []models.Scope{models.ScopeReportsRead}, Response: []models.DailySales{}},
+		{Method: "GET", Path: "/reports/low-stock", Tag: "Transactions", Summary: "Products of the active shop whose stock fell below the low-stock threshold", Access: openapi.Admin,
+			Scopes: []models.Scope{models.ScopeProductsRead}, Response: []models.LowStockAlert{}},
+		{Method: "GET", Path: "/transactions/verify", Tag: "Transactions", Summary: "Verify the active shop's transaction hash chain and checkpoints", Access: openapi.SuperAdmin,
+			Scopes: []models.Scope{models.ScopeTransactionsRead}, Response: ledger.Verification{}},
+		{Method: "GET", Path: "/transactions/checkpoints", Tag: "Transactions", Summary: "Export the signed checkpoints of the chain with their public key", Access: openapi.SuperAdmin,
+			Scopes: []models.Scope{models.ScopeTransactionsRead}, Response: ledger.CheckpointExport{}},
+		{Method: "POST", Path: "/transactions/checkpoints", Tag: "Transactions", Summary: "Sign the current head of the chain", Access: openapi.SuperAdmin,
+			Response: models.ChainCheckpoint{}, Status: http.StatusCreated},
+
+		// Shops
+		{Method: "GET", Path: "/shops", Tag: "Shops", Summary: "List shops", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeShopsRead}, Response: []models.Shop{}},
+		{Method: "GET", Path: "/shops/current", Tag: "Shops", Summary: "Get the active shop", Access: openapi.Authenticated,
+			Scopes: []models.Scope{models.ScopeShopsRead}, Response: models.Shop{}, Conditional: true},
+		{Method: "PUT", Path: "/shops/whatsapp", Tag: "Shops", Summary: "Set the WhatsApp number used for orders", Access: openapi.SuperAdmin,
+			Request: UpdateWhatsAppRequest{}, Response: message{}, Conditional: true},
+		{Method: "PUT", Path: "/shops/2fa", Tag: "Shops", Summary: "Require SuperAdmins to sign in with a second factor", Access: openapi.SuperAdmin,
+			Request: UpdateTwoFactorPolicyRequest{}, Response: message{}, Conditional: true},
+		{Method: "POST", Path: "/shops/members", Tag: "Shops", Summary: "Invite an existing user to the active shop; they join once they accept", Access: openapi.SuperAdmin,
+			Request: AddMemberRequest{}, Response: models.Invitation{}, Status: http.StatusCreated, Idempotent: true},
+		{Method: "POST", Path: "/shops/switch", Tag: "Shops", Summary: "Get a token for another shop the user belongs to", Access: openapi.Authenticated,
+			Request: SwitchShopRequest{}, Response: SwitchShopResponse{}},
+
+		// Account
+		{Method: "GET", Path: "/me/shops", Tag: "Account", Summary: "List the caller's shop memberships", Access: openapi.Authenticated,
+			Response: []models.Membership{}},
+		{Method: "GET", Path: "/me/invitations", Tag: "Account", Summary: "List the shops inviting the caller", Access: openapi.Authenticated,
+			Response: []models.Invitation{}},
+		{Method: "POST", Path: "/me/invitations/{id}/accept", Tag: "Account", Summary: "Accept an invitation and join its shop", Access: openapi.Authenticated,
+			Response: models.Membership{}, Status: http.StatusCreated},
+		{Method: "POST", Path: "/me/invitations/{id}/decline", Tag: "Account", Summary: "Decline an invitation", Access: openapi.Authenticated,
+			Status: http.StatusNoContent},
+		{Method: "PUT", Path: "/me/password", Tag: "Account", Summary: "Change the caller's password; other sessions are signed out", Access: openapi.Authenticated,
+			Request: ChangePasswordRequest{}, Response: ChangePasswordResponse{}},
+		{Method: "POST", Path: "/me/2fa/setup", Tag: "Account", Summary: "Start TOTP enrollment", Access: openapi.Authenticated,
+			Response: TOTPSetupResponse{}},
+		{Method: "POST", Path: "/me/2fa/enable", Tag: "Account", Summary: "Confirm TOTP enrollment and get recovery codes", Access: openapi.Authenticated,
+			Request: TOTPCodeRequest{}, Response: RecoveryCodesResponse{}},
+		{Method: "POST", Path: "/me/2fa/disable", Tag: "Account", Summary: "Turn off two-factor authentication", Access: openapi.Authenticated,
+			Request: TOTPDisableRequest{}, Response: message{}},
+
+		// Users
+		{Method: "GET", Path: "/users", Tag: "Users", Summary: "List the members of the active shop", Access: openapi.SuperAdmin,
+			Response: []ShopUserResponse{}},
+		{Method: "GET", Path: "/users/{id}", Tag: "Users", Summary: "Get a member of the active shop", Access: openapi.SuperAdmin,
+			Response: ShopUserResponse{}, Conditional: true},
+		{Method: "PUT", Path: "/users/{id}", Tag: "Users", Summary: "Update a member's name, email and role", Access: openapi.SuperAdmin,
+			Request: UpdateUserRequest{}, Response: ShopUserResponse{}, Conditional: true},
+		{Method: "POST", Path: "/users/{id}/deactivate", Tag: "Users", Summary: "Deactivate an account", Access: openapi.SuperAdmin,
+			Response: message{}, Conditional: true},
+		{Method: "POST", Path: "/users/{id}/reactivate", Tag: "Users", Summary: "Reactivate an account", Access: openapi.SuperAdmin,
+			Response: message{}, Conditional: true},
+		{Method: "POST", Path: "/users/{id}/password-reset", Tag: "Users", Summary: "Issue a password reset token", Access: openapi.SuperAdmin,
+			Response: PasswordResetResponse{}, Status: http.StatusCreated},
+		{Method: "POST", Path: "/users/{id}/unlock", Tag: "Users", Summary: "Clear failed login attempts", Access: openapi.SuperAdmin,
+			Response: message{}},
+
+		// API keys
+		{Method: "GET", Path: "/api-keys", Tag: "API keys", Summary: "List the active shop's API keys", Access: openapi.SuperAdmin,
+			Response: []models.APIKey{}},
+		{Method: "POST", Path: "/api-keys", Tag: "API keys", Summary: "Create an API key; the key is only returned once", Access: openapi.SuperAdmin,
+			Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Status: http.StatusCreated},
+		{Method: "DELETE", Path: "/api-keys/{id}", Tag: "API keys", Summary: "Revoke an API key", Access: openapi.SuperAdmin,
+			Status: http.StatusNoContent},
+
+		// Audit
+		{Method: "GET", Path: "/audit", Tag: "Audit", Summary: "List the active shop's audit log, newest first", Access: openapi.SuperAdmin,
+			Query: AuditQuery{}, Response: []models.AuditEntry{}},
+	}
+
+	if config.OIDCIssuerURL != "" {
+		ops = append(ops,
+			openapi.Operation{Method: "GET", Path: "/auth/oidc/login", Tag: "Auth", Summary: "Redirect to the identity provider", Access: openapi.Public,
+				Status: http.StatusFound},
+			openapi.Operation{Method: "GET", Path: "/auth/oidc/callback", Tag: "Auth", Summary: "Finish single sign-on; redirects to the frontend when one is configured", Access: openapi.Public,
+				Response: AuthResponse{}},
+		)
+	}
+	return ops
+}
@@ -2,23 +2,29 @@ package handlers
 
 import (
 	"encoding/json"
+	"maps"
+	"mime"
 	"net/http"
 	"shop-api/middleware"
 	"shop-api/models"
+	"shop-api/problem"
 	"shop-api/services"
+	"shop-api/utils"
+	"slices"
 	"strconv"
-	"strings"
 )
 
 type ProductHandler struct {
 	productService services.ProductService
 	shopService    services.ShopService
+	auditService   services.AuditService
 }
 
-func NewProductHandler(productService services.ProductService, shopService services.ShopService) *ProductHandler {
+func NewProductHandler(productService services.ProductService, shopService services.ShopService, auditService services.AuditService) *ProductHandler {
 	return &ProductHandler{
 		productService: productService,
 		shopService:    shopService,
+		auditService:   auditService,
 	}
 }
 
@@ -26,12 +32,12 @@ func NewProductHandler(productService services.ProductService, shopService servi
 func (h *ProductHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	// Get products for the user's shop
-	products := h.productService.GetAll(claims.ShopID)
+	products := h.productService.GetAll(r.Context(), claims.ShopID)
 
 	// Filter response based on role
 	if claims.Role == models.RoleSuperAdmin {
@@ -50,195 +56,294 @@ func (h *ProductHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 }
 
 type CreateProductRequest struct {
-	Name          string  `json:"name"`
-	Description   string  `json:"description"`
-	Category      string  `json:"category"`
-	PurchasePrice float64 `json:"purchase_price"`
-	SellingPrice  float64 `json:"selling_price"`
-	Stock         int     `json:"stock"`
-	ImageURL      string  `json:"image_url"`
+	Name          string   `json:"name" validate:"required,max=200"`
+	Description   string   `json:"description" validate:"max=2000"`
+	Category      string   `json:"category" validate:"max=100"`
+	PurchasePrice *float64 `json:"purchase_price,omitempty" validate:"min=0,max=100000000"` // SuperAdmin only
+	SellingPrice  float64  `json:"selling_price" validate:"required,min=0,max=100000000"`
+	Stock         int      `json:"stock" validate:"min=0,max=1000000"`
+	ImageURL      string   `json:"image_url" validate:"url,max=2048"`
+}
+
+func (req CreateProductRequest) toProduct() models.Product {
+	product := models.Product{
+		Name:         req.Name,
+		Description:  req.Description,
+		Category:     req.Category,
+		SellingPrice: req.SellingPrice,
+		Stock:        req.Stock,
+		ImageURL:     req.ImageURL,
+	}
+	if req.PurchasePrice != nil {
+		product.PurchasePrice = *req.PurchasePrice
+	}
+	return product
+}
+
+// productRequest is the writable representation of an existing product,
+// the document a merge patch is applied to
+func productRequest(product *models.Product) CreateProductRequest {
+	purchasePrice := product.PurchasePrice
+	return CreateProductRequest{
+		Name:          product.Name,
+		Description:   product.Description,
+		Category:      product.Category,
+		PurchasePrice: &purchasePrice,
+		SellingPrice:  product.SellingPrice,
+		Stock:         product.Stock,
+		ImageURL:      product.ImageURL,
+	}
 }
 
 // Create - POST /products (private - requires auth)
 func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	var req CreateProductRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-
-	// Validate
-	if req.Name == "" || req.SellingPrice <= 0 {
-		http.Error(w, `{"error": "Name and selling price are required"}`, http.StatusBadRequest)
+	if !canSetPurchasePrice(w, r, claims, req.PurchasePrice != nil) {
 		return
 	}
 
 	// Create product with user's ShopID
-	product := models.Product{
-		Name:          req.Name,
-		Description:   req.Description,
-		Category:      req.Category,
-		PurchasePrice: req.PurchasePrice,
-		SellingPrice:  req.SellingPrice,
-		Stock:         req.Stock,
-		ImageURL:      req.ImageURL,
-		ShopID:        claims.ShopID,
-	}
+	product := req.toProduct()
+	product.ShopID = claims.ShopID
 
-	created, err := h.productService.Create(product)
+	created, err := h.productService.Create(r.Context(), product)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "product.create", Entity: "product", EntityID: created.ID}, nil, created)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	writeProduct(w, claims, created, http.StatusCreated)
+}
 
-	// Return based on role
-	if claims.Role == models.RoleSuperAdmin {
-		json.NewEncoder(w).Encode(created)
-	} else {
-		json.NewEncoder(w).Encode(created.ToAdminResponse())
+// Get - GET /products/{id} (private - requires auth)
+func (h *ProductHandler) Get(w http.ResponseWriter, r *http.Request) {
+	claims, existing, ok := h.ownedProduct(w, r)
+	if !ok {
+		return
 	}
+
+	if noneMatch(r.Header.Get("If-None-Match"), etag(existing.Version)) {
+		w.Header().Set("ETag", etag(existing.Version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeProduct(w, claims, existing, http.StatusOK)
 }
 
-// Update - PUT /products/:id (private - requires auth)
+// Update - PUT /products/{id} (private - requires auth)
+// Replaces every field; an Admin's replacement keeps the purchase price they cannot see
 func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
-	claims, ok := middleware.GetClaims(r)
+	claims, existing, ok := h.ownedProduct(w, r)
+	if !ok {
+		return
+	}
+	expectedVersion, ok := ifMatchVersion(w, r, existing.Version)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	// Extract ID from URL
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(pathParts) < 2 {
-		http.Error(w, `{"error": "Invalid URL"}`, http.StatusBadRequest)
+	var req CreateProductRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
+	if !canSetPurchasePrice(w, r, claims, req.PurchasePrice != nil) {
+		return
+	}
+
+	product := req.toProduct()
+	if claims.Role != models.RoleSuperAdmin {
+		product.PurchasePrice = existing.PurchasePrice
+	}
 
-	id, err := strconv.Atoi(pathParts[len(pathParts)-1])
+	updated, err := h.productService.Update(r.Context(), existing.ID, product, expectedVersion)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid product ID"}`, http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "product.update", Entity: "product", EntityID: existing.ID}, existing, updated)
 
-	// Check if product exists and belongs to user's shop
-	existing, err := h.productService.GetByID(id)
-	if err != nil {
-		http.Error(w, `{"error": "Product not found"}`, http.StatusNotFound)
+	writeProduct(w, claims, updated, http.StatusOK)
+}
+
+// Patch - PATCH /products/{id} (private - requires auth)
+// Applies a JSON Merge Patch (RFC 7396): only the fields present are changed,
+// null resets a field to its default
+func (h *ProductHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	claims, existing, ok := h.ownedProduct(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := ifMatchVersion(w, r, existing.Version); !ok {
 		return
 	}
 
-	if existing.ShopID != claims.ShopID {
-		http.Error(w, `{"error": "Unauthorized - product belongs to different shop"}`, http.StatusForbidden)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		problem.Error(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+			"use application/merge-patch+json")
 		return
 	}
 
-	var req CreateProductRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	patch, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
-	// Update product
-	product := models.Product{
-		Name:          req.Name,
-		Description:   req.Description,
-		Category:      req.Category,
-		PurchasePrice: req.PurchasePrice,
-		SellingPrice:  req.SellingPrice,
-		Stock:         req.Stock,
-		ImageURL:      req.ImageURL,
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "merge patch must be a JSON object")
+		return
+	}
+	// The merge matches keys exactly but decoding doesn't, so "Stock" would sit
+	// next to the current "stock" and one of the two would silently win
+	known := fieldNames(&CreateProductRequest{})
+	var keyErrors []problem.FieldError
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if !slices.Contains(known, key) {
+			keyErrors = append(keyErrors, problem.FieldError{Field: key, Code: "unknown_field", Message: "is not a product field (names are case-sensitive)"})
+		}
+	}
+	if len(keyErrors) > 0 {
+		p := problem.New(http.StatusBadRequest, problem.CodeInvalidInput, "merge patch keys must be product field names")
+		p.Errors = keyErrors
+		problem.Write(w, r, p)
+		return
+	}
+
+	_, touchesPurchasePrice := fields["purchase_price"]
+	if !canSetPurchasePrice(w, r, claims, touchesPurchasePrice) {
+		return
 	}
 
-	updated, err := h.productService.Update(id, product)
+	current, err := json.Marshal(productRequest(existing))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	merged, err := utils.MergePatch(current, patch)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeInvalidJSON(w, r)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	// The patched document is validated like a full replacement
+	var req CreateProductRequest
+	if !decodeBody(w, r, merged, &req) {
+		return
+	}
 
-	// Return based on role
-	if claims.Role == models.RoleSuperAdmin {
-		json.NewEncoder(w).Encode(updated)
-	} else {
-		json.NewEncoder(w).Encode(updated.ToAdminResponse())
+	// The patch was applied to this version, so it must still be current
+	updated, err := h.productService.Update(r.Context(), existing.ID, req.toProduct(), existing.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "product.update", Entity: "product", EntityID: existing.ID}, existing, updated)
+
+	writeProduct(w, claims, updated, http.StatusOK)
 }
 
-// Delete - DELETE /products/:id (private - requires auth)
-func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
+// ownedProduct loads the product named in the path and checks it belongs to the caller's shop
+func (h *ProductHandler) ownedProduct(w http.ResponseWriter, r *http.Request) (*utils.Claims, *models.Product, bool) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Extract ID from URL
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(pathParts) < 2 {
-		http.Error(w, `{"error": "Invalid URL"}`, http.StatusBadRequest)
-		return
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return nil, nil, false
 	}
 
-	id, err := strconv.Atoi(pathParts[len(pathParts)-1])
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, `{"error": "Invalid product ID"}`, http.StatusBadRequest)
-		return
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid product ID")
+		return nil, nil, false
 	}
 
-	// Check if product exists and belongs to user's shop
-	existing, err := h.productService.GetByID(id)
+	existing, err := h.productService.GetByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, `{"error": "Product not found"}`, http.StatusNotFound)
-		return
+		writeError(w, r, err)
+		return nil, nil, false
 	}
 
 	if existing.ShopID != claims.ShopID {
-		http.Error(w, `{"error": "Unauthorized - product belongs to different shop"}`, http.StatusForbidden)
+		writeError(w, r, services.ErrForbiddenTenant)
+		return nil, nil, false
+	}
+	return claims, existing, true
+}
+
+// canSetPurchasePrice rejects requests from Admins that include the purchase price
+func canSetPurchasePrice(w http.ResponseWriter, r *http.Request, claims *utils.Claims, present bool) bool {
+	if !present || claims.Role == models.RoleSuperAdmin {
+		return true
+	}
+
+	p := problem.New(http.StatusForbidden, problem.CodeForbidden, "only a SuperAdmin can set the purchase price")
+	p.Errors = []problem.FieldError{{Field: "purchase_price", Code: "forbidden", Message: "cannot be set by an Admin"}}
+	problem.Write(w, r, p)
+	return false
+}
+
+// writeProduct encodes a product with its ETag, hiding the purchase price from Admins
+func writeProduct(w http.ResponseWriter, claims *utils.Claims, product *models.Product, status int) {
+	w.Header().Set("ETag", etag(product.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// Return based on role
+	if claims.Role == models.RoleSuperAdmin {
+		json.NewEncoder(w).Encode(product)
+	} else {
+		json.NewEncoder(w).Encode(product.ToAdminResponse())
+	}
+}
+
+// Delete - DELETE /products/{id} (private - requires auth)
+func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	_, existing, ok := h.ownedProduct(w, r)
+	if !ok {
+		return
+	}
+	expectedVersion, ok := ifMatchVersion(w, r, existing.Version)
+	if !ok {
 		return
 	}
 
-	if err := h.productService.Delete(id); err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+	if err := h.productService.Delete(r.Context(), existing.ID, expectedVersion); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "product.delete", Entity: "product", EntityID: existing.ID}, existing, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetPublicProducts - GET /public/:shopID/products (public - no auth required)
+// GetPublicProducts - GET /public/{shopID}/products (public - no auth required)
 func (h *ProductHandler) GetPublicProducts(w http.ResponseWriter, r *http.Request) {
-	// Extract shopID from URL
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(pathParts) < 2 {
-		http.Error(w, `{"error": "Invalid URL"}`, http.StatusBadRequest)
-		return
-	}
-
-	shopID, err := strconv.Atoi(pathParts[1])
+	shopID, err := strconv.Atoi(r.PathValue("shopID"))
 	if err != nil {
-		http.Error(w, `{"error": "Invalid shop ID"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid shop ID")
 		return
 	}
 
 	// Verify shop exists
 	shop, err := h.shopService.GetByID(shopID)
 	if err != nil {
-		http.Error(w, `{"error": "Shop not found"}`, http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Shop not found")
 		return
 	}
 
 	// Get products for this shop
-	products := h.productService.GetPublicProducts(shopID)
+	products := h.productService.GetPublicProducts(r.Context(), shopID)
 
 	// Convert to public response (no purchase price, with WhatsApp link)
 	var publicProducts []models.PublicProductResponse
@@ -249,6 +354,11 @@ func (h *ProductHandler) GetPublicProducts(w http.ResponseWriter, r *http.Reques
 		// }
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(publicProducts)
+	// The catalog is polled by storefronts: clients revalidate with If-None-Match
+	body, err := json.Marshal(publicProducts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCached(w, r, append(body, '\n'))
 }
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"shop-api/utils"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// discardEvents accepts every event without storing it
+type discardEvents struct{}
+
+func (discardEvents) Publish(ctx context.Context, shopID int, payload events.Payload) error {
+	return nil
+}
+
+var (
+	shop1Admin      = &utils.Claims{UserID: 2, ShopID: 1, Role: models.RoleAdmin}
+	shop1SuperAdmin = &utils.Claims{UserID: 1, ShopID: 1, Role: models.RoleSuperAdmin}
+)
+
+// newTestProductHandler serves fresh seeded stores; product 1 belongs to
+// shop 1 and is bought at 8000
+func newTestProductHandler() (*ProductHandler, services.ProductService) {
+	products := services.NewProductService(discardEvents{})
+	return NewProductHandler(products, services.NewShopService(discardEvents{}), services.NewAuditService()), products
+}
+
+// callProduct sends a request for product id to handler as the given caller
+func callProduct(handler http.HandlerFunc, claims *utils.Claims, method, id, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/v1/products/"+id, strings.NewReader(body))
+	r.SetPathValue("id", id)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsContextKey, claims))
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) problem.Problem {
+	t.Helper()
+	var p problem.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body %q is not a problem: %v", w.Body.String(), err)
+	}
+	return p
+}
+
+func getProduct(t *testing.T, products services.ProductService, id int) *models.Product {
+	t.Helper()
+	product, err := products.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func TestPatchProductChangesOnlyTheGivenFields(t *testing.T) {
+	handler, products := newTestProductHandler()
+	before := getProduct(t, products, 1)
+
+	w := callProduct(handler.Patch, shop1Admin, http.MethodPatch, "1", `{"stock": 10, "description": null}`,
+		http.Header{"Content-Type": {"application/merge-patch+json"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+	}
+
+	after := getProduct(t, products, 1)
+	if after.Stock != 10 || after.Description != "" {
+		t.Errorf("stock = %d, description = %q, want 10 and the null reset to empty", after.Stock, after.Description)
+	}
+	// The fields left out are kept, the purchase price the Admin cannot see included
+	if after.Name != before.Name || after.SellingPrice != before.SellingPrice || after.ImageURL != before.ImageURL || after.PurchasePrice != before.PurchasePrice {
+		t.Errorf("patch changed other fields: %+v, was %+v", after, before)
+	}
+	if strings.Contains(w.Body.String(), "purchase_price") {
+		t.Errorf("the purchase price was sent to an Admin: %s", w.Body.String())
+	}
+}
+
+func TestPatchProductRejections(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      *utils.Claims
+		contentType string
+		body        string
+		status      int
+		code        string
+		fields      []string // of the problem's errors
+	}{
+		{"keys are case-sensitive", shop1Admin, "", `{"Stock": 3, "colour": "red", "stock": 4}`, http.StatusBadRequest, problem.CodeInvalidInput, []string{"Stock", "colour"}},
+		{"Admin sets the purchase price", shop1Admin, "", `{"purchase_price": 1}`, http.StatusForbidden, problem.CodeForbidden, []string{"purchase_price"}},
+		{"Admin clears the purchase price", shop1Admin, "", `{"purchase_price": null}`, http.StatusForbidden, problem.CodeForbidden, []string{"purchase_price"}},
+		{"null on a required field", shop1Admin, "", `{"name": null}`, http.StatusUnprocessableEntity, problem.CodeValidationFailed, []string{"name"}},
+		{"invalid value", shop1Admin, "", `{"stock": -1}`, http.StatusUnprocessableEntity, problem.CodeValidationFailed, []string{"stock"}},
+		{"not an object", shop1Admin, "", `[{"stock": 1}]`, http.StatusBadRequest, problem.CodeInvalidJSON, nil},
+		{"other media type", shop1Admin, "application/json-patch+json", `[]`, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, products := newTestProductHandler()
+			header := http.Header{}
+			if tt.contentType != "" {
+				header.Set("Content-Type", tt.contentType)
+			}
+
+			w := callProduct(handler.Patch, tt.claims, http.MethodPatch, "1", tt.body, header)
+
+			p := decodeProblem(t, w)
+			if w.Code != tt.status || p.Code != tt.code {
+				t.Fatalf("got %d %s, want %d %s (%s)", w.Code, p.Code, tt.status, tt.code, p.Detail)
+			}
+			var fields []string
+			for _, fieldErr := range p.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("invalid fields = %q, want %q", fields, tt.fields)
+			}
+			if version := getProduct(t, products, 1).Version; version != 1 {
+				t.Errorf("a rejected patch changed the product (version %d)", version)
+			}
+		})
+	}
+}
+
+func TestSuperAdminPatchesThePurchasePrice(t *testing.T) {
+	handler, products := newTestProductHandler()
+
+	w := callProduct(handler.Patch, shop1SuperAdmin, http.MethodPatch, "1", `{"purchase_price": 7500}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+	}
+	if price := getProduct(t, products, 1).PurchasePrice; price != 7500 {
+		t.Errorf("purchase price = %v, want 7500", price)
+	}
+
+	w = callProduct(handler.Patch, shop1SuperAdmin, http.MethodPatch, "1", `{"purchase_price": null}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+	}
+	if price := getProduct(t, products, 1).PurchasePrice; price != 0 {
+		t.Errorf("purchase price = %v after null, want 0", price)
+	}
+}
+
+func TestProductWritesCheckIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{"current version", `"1"`, http.StatusOK},
+		{"one of several", `"7", "1"`, http.StatusOK},
+		{"any version", `*`, http.StatusOK},
+		{"stale version", `"2"`, http.StatusPreconditionFailed},
+		{"weak tags never match", `W/"1"`, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writes := []struct {
+				method string
+				call   func(*ProductHandler) http.HandlerFunc
+				body   string
+				ok     int
+			}{
+				{http.MethodPut, func(h *ProductHandler) http.HandlerFunc { return h.Update }, `{"name": "iPhone 15", "selling_price": 11000}`, http.StatusOK},
+				{http.MethodPatch, func(h *ProductHandler) http.HandlerFunc { return h.Patch }, `{"stock": 3}`, http.StatusOK},
+				{http.MethodDelete, func(h *ProductHandler) http.HandlerFunc { return h.Delete }, ``, http.StatusNoContent},
+			}
+			for _, write := range writes {
+				handler, products := newTestProductHandler()
+				w := callProduct(write.call(handler), shop1Admin, write.method, "1", write.body, http.Header{"If-Match": {tt.ifMatch}})
+
+				want := tt.status
+				if want == http.StatusOK {
+					want = write.ok
+				}
+				if w.Code != want {
+					t.Fatalf("%s: status = %d, want %d (%s)", write.method, w.Code, want, w.Body.String())
+				}
+				if want != http.StatusPreconditionFailed {
+					continue
+				}
+
+				// The client is told the version to fetch, and nothing changed
+				if p := decodeProblem(t, w); p.Code != problem.CodePreconditionFailed {
+					t.Errorf("%s: code = %s, want %s", write.method, p.Code, problem.CodePreconditionFailed)
+				}
+				if tag := w.Header().Get("ETag"); tag != `"1"` {
+					t.Errorf("%s: ETag = %s, want the current \"1\"", write.method, tag)
+				}
+				if product := getProduct(t, products, 1); product.Version != 1 {
+					t.Errorf("%s: the product changed to version %d", write.method, product.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestProductWriteReturnsTheNewETag(t *testing.T) {
+	handler, _ := newTestProductHandler()
+
+	w := callProduct(handler.Patch, shop1Admin, http.MethodPatch, "1", `{"stock": 3}`, http.Header{"If-Match": {`"1"`}})
+	if tag := w.Header().Get("ETag"); w.Code != http.StatusOK || tag != `"2"` {
+		t.Fatalf("status = %d, ETag = %s, want 200 and \"2\"", w.Code, tag)
+	}
+	// The tag read before the change is now stale
+	w = callProduct(handler.Patch, shop1Admin, http.MethodPatch, "1", `{"stock": 4}`, http.Header{"If-Match": {`"1"`}})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("status = %d with the old ETag, want 412", w.Code)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	previous := config.RequireIfMatch
+	config.RequireIfMatch = true
+	t.Cleanup(func() { config.RequireIfMatch = previous })
+
+	handler, _ := newTestProductHandler()
+	w := callProduct(handler.Patch, shop1Admin, http.MethodPatch, "1", `{"stock": 3}`, nil)
+	if p := decodeProblem(t, w); w.Code != http.StatusPreconditionRequired || p.Code != problem.CodePreconditionRequired {
+		t.Errorf("got %d %s, want 428 %s", w.Code, p.Code, problem.CodePreconditionRequired)
+	}
+}
+
+func TestGetProductIfNoneMatch(t *testing.T) {
+	handler, _ := newTestProductHandler()
+
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{"", http.StatusOK},
+		{`"1"`, http.StatusNotModified},
+		{`W/"1"`, http.StatusNotModified}, // If-None-Match uses the weak comparison
+		{`"0", "1"`, http.StatusNotModified},
+		{`*`, http.StatusNotModified},
+		{`"2"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.ifNoneMatch != "" {
+			header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		w := callProduct(handler.Get, shop1Admin, http.MethodGet, "1", "", header)
+
+		if w.Code != tt.status {
+			t.Errorf("If-None-Match %s: status = %d, want %d", tt.ifNoneMatch, w.Code, tt.status)
+		}
+		if tag := w.Header().Get("ETag"); tag != `"1"` {
+			t.Errorf("If-None-Match %s: ETag = %s, want \"1\"", tt.ifNoneMatch, tag)
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("If-None-Match %s: 304 with a body", tt.ifNoneMatch)
+		}
+	}
+}
+
+func TestPublicCatalogIfNoneMatch(t *testing.T) {
+	handler, _ := newTestProductHandler()
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/public/1/products", nil)
+		r.SetPathValue("shopID", "1")
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.GetPublicProducts(w, r)
+		return w
+	}
+
+	first := get("")
+	tag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || tag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with an ETag", first.Code, tag)
+	}
+
+	if w := get(tag); w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+		t.Errorf("revalidation: status = %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+	}
+	if w := get("W/" + tag); w.Code != http.StatusNotModified {
+		t.Errorf("weak revalidation: status = %d, want 304", w.Code)
+	}
+	if w := get(`"stale"`); w.Code != http.StatusOK || w.Header().Get("ETag") != tag {
+		t.Errorf("stale tag: status = %d, want 200 with the same ETag", w.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"shop-api/middleware"
+	"shop-api/problem"
+	"shop-api/services"
+)
+
+type ReportHandler struct {
+	reportService services.ReportService
+}
+
+func NewReportHandler(reportService services.ReportService) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+	}
+}
+
+// GetDailySales - GET /reports/sales (private - SuperAdmin only)
+// Lists the active shop's sales totals per day, newest first
+func (h *ReportHandler) GetDailySales(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.reportService.DailySales(r.Context(), claims.ShopID))
+}
+
+// GetLowStock - GET /reports/low-stock (private - requires admin)
+// Lists the active shop's products whose stock fell below the threshold
+func (h *ReportHandler) GetLowStock(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.reportService.LowStockAlerts(r.Context(), claims.ShopID))
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"shop-api/config"
+	"shop-api/problem"
+	"shop-api/validate"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// decodeJSON reads a size-limited JSON body into dst, rejecting unknown
+// fields, and validates it. It writes the problem response and returns
+// false when the request is unusable; every invalid field is reported at once.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	body, ok := readBody(w, r)
+	return ok && decodeBody(w, r, body, dst)
+}
+
+// readBody reads the request body up to config.MaxRequestBodyBytes
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, config.MaxRequestBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge,
+				"request body must not exceed "+formatBytes(tooLarge.Limit))
+			return nil, false
+		}
+		writeInvalidJSON(w, r)
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeBody is decodeJSON for a body that has already been read
+func decodeBody(w http.ResponseWriter, r *http.Request, body []byte, dst any) bool {
+	var fieldErrors []problem.FieldError
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+	if err == nil && decoder.More() {
+		writeInvalidJSON(w, r)
+		return false
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if err != nil {
+		if !errors.As(err, &typeErr) && !strings.HasPrefix(err.Error(), "json: unknown field ") {
+			writeInvalidJSON(w, r)
+			return false
+		}
+		// The decoder keeps only its first error: list every unknown field,
+		// then decode leniently so the known fields are validated too
+		fieldErrors = append(fieldErrors, unknownFields(body, dst)...)
+		typeErr = nil
+		if err := json.Unmarshal(body, dst); err != nil && !errors.As(err, &typeErr) {
+			writeInvalidJSON(w, r)
+			return false
+		}
+	}
+
+	if typeErr != nil && typeErr.Field == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "request body must be a JSON "+jsonType(typeErr.Type))
+		return false
+	}
+
+	mistyped := ""
+	if typeErr != nil {
+		mistyped = typeErr.Field
+		fieldErrors = append(fieldErrors, problem.FieldError{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: "must be a JSON " + jsonType(typeErr.Type),
+		})
+	}
+	for _, fieldErr := range validate.Struct(dst) {
+		if fieldErr.Field != mistyped {
+			fieldErrors = append(fieldErrors, fieldErr)
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeFieldErrors(w, r, fieldErrors...)
+		return false
+	}
+	return true
+}
+
+// writeFieldErrors answers 422 with the given invalid fields
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, fieldErrors ...problem.FieldError) {
+	p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed, "request contains invalid fields")
+	p.Errors = fieldErrors
+	problem.Write(w, r, p)
+}
+
+// unknownFields lists the top-level keys of body that dst has no field for
+func unknownFields(body []byte, dst any) []problem.FieldError {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil
+	}
+
+	var errs []problem.FieldError
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		found := false
+		for _, name := range fieldNames(dst) {
+			// encoding/json matches field names case-insensitively
+			if strings.EqualFold(key, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, problem.FieldError{Field: key, Code: "unknown_field", Message: "is not a recognised field"})
+		}
+	}
+	return errs
+}
+
+// fieldNames returns the JSON names of the fields of the struct dst points to
+func fieldNames(dst any) []string {
+	var names []string
+	dstType := reflect.TypeOf(dst).Elem()
+	for i := 0; i < dstType.NumField(); i++ {
+		if field := dstType.Field(i); field.IsExported() {
+			names = append(names, validate.FieldName(field))
+		}
+	}
+	return names
+}
+
+// jsonType names the JSON type that decodes into t
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "number"
+}
+
+func formatBytes(n int64) string {
+	if n%(1<<20) == 0 {
+		return strconv.FormatInt(n>>20, 10) + " MiB"
+	}
+	if n%(1<<10) == 0 {
+		return strconv.FormatInt(n>>10, 10) + " KiB"
+	}
+	return strconv.FormatInt(n, 10) + " bytes"
+}
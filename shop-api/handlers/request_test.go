@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/config"
+	"shop-api/problem"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// decodeProduct runs decodeJSON on body and returns the problem it answered,
+// nil when the request was accepted
+func decodeProduct(t *testing.T, body string) (int, *problem.Problem) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/products", strings.NewReader(body))
+	var req CreateProductRequest
+	if decodeJSON(w, r, &req) {
+		return 0, nil
+	}
+
+	if got := w.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, problem.ContentType)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body %q is not a problem: %v", w.Body.String(), err)
+	}
+	if p.Status != w.Code {
+		t.Errorf("problem status = %d, response status = %d", p.Status, w.Code)
+	}
+	return w.Code, &p
+}
+
+func TestDecodeJSONReportsEveryInvalidField(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		errors []problem.FieldError
+	}{
+		{
+			name: "rules",
+			body: `{"name": "", "selling_price": -1, "stock": -5, "image_url": "ftp://example.com/a.jpg"}`,
+			errors: []problem.FieldError{
+				{Field: "name", Code: "required", Message: "is required"},
+				{Field: "selling_price", Code: "min", Message: "must be at least 0"},
+				{Field: "stock", Code: "min", Message: "must be at least 0"},
+				{Field: "image_url", Code: "url", Message: "must be an absolute http or https URL"},
+			},
+		},
+		{
+			name: "lengths",
+			body: `{"name": "` + strings.Repeat("é", 201) + `", "selling_price": 1}`,
+			errors: []problem.FieldError{
+				{Field: "name", Code: "max", Message: "must be at most 200 characters long"},
+			},
+		},
+		{
+			// Unknown fields come first, and the known ones are still checked
+			name: "unknown fields",
+			body: `{"colour": "red", "Extra": 1, "selling_price": 1}`,
+			errors: []problem.FieldError{
+				{Field: "Extra", Code: "unknown_field", Message: "is not a recognised field"},
+				{Field: "colour", Code: "unknown_field", Message: "is not a recognised field"},
+				{Field: "name", Code: "required", Message: "is required"},
+			},
+		},
+		{
+			// A mistyped field is not also reported as missing
+			name: "wrong type",
+			body: `{"name": "Case", "selling_price": "ten"}`,
+			errors: []problem.FieldError{
+				{Field: "selling_price", Code: "invalid_type", Message: "must be a JSON number"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, p := decodeProduct(t, tt.body)
+			if p == nil {
+				t.Fatal("invalid request accepted")
+			}
+			if status != http.StatusUnprocessableEntity || p.Code != problem.CodeValidationFailed {
+				t.Fatalf("status = %d, code = %s, want 422 %s", status, p.Code, problem.CodeValidationFailed)
+			}
+			if !slices.Equal(p.Errors, tt.errors) {
+				t.Errorf("errors = %+v\nwant %+v", p.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONRejectsMalformedBodies(t *testing.T) {
+	previous := config.MaxRequestBodyBytes
+	config.MaxRequestBodyBytes = 64
+	t.Cleanup(func() { config.MaxRequestBodyBytes = previous })
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		detail string
+	}{
+		{"syntax error", `{"name": `, http.StatusBadRequest, problem.CodeInvalidJSON, "request body is not valid JSON"},
+		{"trailing data", `{"name": "Case", "selling_price": 1} {}`, http.StatusBadRequest, problem.CodeInvalidJSON, "request body is not valid JSON"},
+		{"not an object", `[1, 2]`, http.StatusBadRequest, problem.CodeInvalidJSON, "request body must be a JSON object"},
+		{"too large", `{"name": "` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, "request body must not exceed 64 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, p := decodeProduct(t, tt.body)
+			if p == nil {
+				t.Fatal("malformed request accepted")
+			}
+			if status != tt.status || p.Code != tt.code || p.Detail != tt.detail {
+				t.Errorf("got %d %s %q, want %d %s %q", status, p.Code, p.Detail, tt.status, tt.code, tt.detail)
+			}
+			if len(p.Errors) > 0 {
+				t.Errorf("errors = %+v, want none", p.Errors)
+			}
+		})
+	}
+
+	if _, p := decodeProduct(t, `{"name": "Case", "selling_price": 1}`); p != nil {
+		t.Errorf("valid request rejected: %+v", p)
+	}
+}
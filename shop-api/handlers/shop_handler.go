@@ -5,60 +5,96 @@ import (
 	"net/http"
 	"shop-api/middleware"
 	"shop-api/models"
+	"shop-api/problem"
 	"shop-api/services"
 )
 
 type ShopHandler struct {
-	shopService services.ShopService
+	shopService       services.ShopService
+	userService       services.UserService
+	membershipService services.MembershipService
+	auditService      services.AuditService
 }
 
-func NewShopHandler(shopService services.ShopService) *ShopHandler {
+func NewShopHandler(shopService services.ShopService, userService services.UserService, membershipService services.MembershipService, auditService services.AuditService) *ShopHandler {
 	return &ShopHandler{
-		shopService: shopService,
+		shopService:       shopService,
+		userService:       userService,
+		membershipService: membershipService,
+		auditService:      auditService,
 	}
 }
 
+type UpdateTwoFactorPolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+type AddMemberRequest struct {
+	Email string      `json:"email" validate:"required,email,max=254"`
+	Role  models.Role `json:"role" validate:"required,oneof=SuperAdmin Admin"`
+}
+
 type UpdateWhatsAppRequest struct {
-	WhatsAppNumber string `json:"whatsapp_number"`
+	WhatsAppNumber string `json:"whatsapp_number" validate:"required,max=20"`
 }
 
 // UpdateWhatsApp - PUT /shops/whatsapp (SuperAdmin only)
 func (h *ShopHandler) UpdateWhatsApp(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	// Only SuperAdmin can update WhatsApp
 	if claims.Role != models.RoleSuperAdmin {
-		http.Error(w, `{"error": "Only SuperAdmin can update WhatsApp number"}`, http.StatusForbidden)
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Only SuperAdmin can update WhatsApp number")
 		return
 	}
 
 	var req UpdateWhatsAppRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	if req.WhatsAppNumber == "" {
-		http.Error(w, `{"error": "WhatsApp number is required"}`, http.StatusBadRequest)
+	before, expectedVersion, ok := h.ifMatchShop(w, r, claims.ShopID)
+	if !ok {
 		return
 	}
 
 	// Update the shop's WhatsApp number
-	if err := h.shopService.UpdateWhatsApp(claims.ShopID, req.WhatsAppNumber); err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusNotFound)
+	if err := h.shopService.UpdateWhatsApp(r.Context(), claims.ShopID, req.WhatsAppNumber, expectedVersion); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	h.shopChanged(w, r, "shop.update_whatsapp", before)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "WhatsApp number updated successfully",
 	})
 }
 
+// GetCurrent - GET /shops/current (private)
+// Returns the active shop with its ETag, for conditional updates
+func (h *ShopHandler) GetCurrent(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	shop, err := h.shopService.GetByID(claims.ShopID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(shop.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shop)
+}
+
 // GetAll - GET /shops (private)
 func (h *ShopHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	shops := h.shopService.GetAll()
@@ -66,3 +102,100 @@ func (h *ShopHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(shops)
 }
+
+// AddMember - POST /shops/members (SuperAdmin only)
+// Invites an existing user to the active shop. They only get access once they
+// accept; the role of a current member is changed with PUT /users/{id}.
+func (h *ShopHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req AddMemberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := h.userService.GetByEmail(req.Email)
+	if err != nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return
+	}
+
+	invitation, err := h.membershipService.Invite(models.Invitation{
+		UserID:    user.ID,
+		ShopID:    claims.ShopID,
+		Role:      req.Role,
+		InvitedBy: claims.UserID,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "membership.invite", Entity: "invitation", EntityID: invitation.ID}, nil, invitation)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// UpdateTwoFactorPolicy - PUT /shops/2fa (SuperAdmin only)
+// Requires SuperAdmins of the active shop to sign in with a second factor
+func (h *ShopHandler) UpdateTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req UpdateTwoFactorPolicyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Enforcing requires proving it works for the caller, so they don't lock themselves out
+	if req.Required && !claims.TwoFactor {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeTwoFactorRequired, "Sign in with two-factor authentication before requiring it")
+		return
+	}
+
+	before, expectedVersion, ok := h.ifMatchShop(w, r, claims.ShopID)
+	if !ok {
+		return
+	}
+
+	if err := h.shopService.SetRequireTwoFactor(r.Context(), claims.ShopID, req.Required, expectedVersion); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.shopChanged(w, r, "shop.update_2fa_policy", before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor policy updated successfully",
+	})
+}
+
+// ifMatchShop checks If-Match against the current version of a shop, which it returns
+func (h *ShopHandler) ifMatchShop(w http.ResponseWriter, r *http.Request, shopID int) (*models.Shop, int, bool) {
+	shop, err := h.shopService.GetByID(shopID)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, 0, false
+	}
+	version, ok := ifMatchVersion(w, r, shop.Version)
+	return shop, version, ok
+}
+
+// shopChanged sends the ETag of a shop's version after a change and audits it
+func (h *ShopHandler) shopChanged(w http.ResponseWriter, r *http.Request, action string, before *models.Shop) {
+	shop, err := h.shopService.GetByID(before.ID)
+	if err != nil {
+		return
+	}
+	w.Header().Set("ETag", etag(shop.Version))
+	recordAudit(h.auditService, r, models.AuditEntry{Action: action, Entity: "shop", EntityID: shop.ID}, before, shop)
+}
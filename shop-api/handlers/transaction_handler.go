@@ -5,35 +5,38 @@ import (
 	"net/http"
 	"shop-api/middleware"
 	"shop-api/models"
+	"shop-api/problem"
 	"shop-api/services"
 )
 
 type TransactionHandler struct {
 	transactionService services.TransactionService
+	auditService       services.AuditService
 }
 
-func NewTransactionHandler(transactionService services.TransactionService) *TransactionHandler {
+func NewTransactionHandler(transactionService services.TransactionService, auditService services.AuditService) *TransactionHandler {
 	return &TransactionHandler{
 		transactionService: transactionService,
+		auditService:       auditService,
 	}
 }
 
 type CreateTransactionRequest struct {
-	Type      models.TransactionType `json:"type"`
-	ProductID *int                   `json:"product_id,omitempty"`
-	Quantity  int                    `json:"quantity"`
-	Amount    float64                `json:"amount"`
+	Type      models.TransactionType `json:"type" validate:"required,oneof=Sale Expense Withdrawal"`
+	ProductID *int                   `json:"product_id,omitempty" validate:"min=1"`
+	Quantity  int                    `json:"quantity" validate:"required,min=1,max=1000000"`
+	Amount    float64                `json:"amount" validate:"required,min=0,max=100000000"`
 }
 
 // GetAll - GET /transactions (private - requires admin)
 func (h *TransactionHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
-	transactions := h.transactionService.GetAll(claims.ShopID)
+	transactions := h.transactionService.GetAll(r.Context(), claims.ShopID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(transactions)
@@ -43,33 +46,18 @@ func (h *TransactionHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 func (h *TransactionHandler) Create(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	var req CreateTransactionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Validate transaction type
-	if req.Type != models.TransactionSale &&
-		req.Type != models.TransactionExpense &&
-		req.Type != models.TransactionWithdrawal {
-		http.Error(w, `{"error": "Invalid transaction type"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if req.Quantity <= 0 || req.Amount <= 0 {
-		http.Error(w, `{"error": "Quantity and amount must be positive"}`, http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Sales must have a product ID
 	if req.Type == models.TransactionSale && req.ProductID == nil {
-		http.Error(w, `{"error": "Product ID required for sales"}`, http.StatusBadRequest)
+		writeFieldErrors(w, r, problem.FieldError{Field: "product_id", Code: "required", Message: "is required for sales"})
 		return
 	}
 
@@ -82,28 +70,72 @@ func (h *TransactionHandler) Create(w http.ResponseWriter, r *http.Request) {
 		ShopID:    claims.ShopID,
 	}
 
-	created, err := h.transactionService.Create(transaction)
+	created, err := h.transactionService.Create(r.Context(), transaction)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "transaction.create", Entity: "transaction", EntityID: created.ID}, nil, created)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(created)
 }
 
+// VerifyChain - GET /transactions/verify (SuperAdmin only)
+// Walks the shop's hash chain and reports the first break, if any
+func (h *TransactionHandler) VerifyChain(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.transactionService.VerifyChain(r.Context(), claims.ShopID))
+}
+
+// GetCheckpoints - GET /transactions/checkpoints (SuperAdmin only)
+// Exports the shop's signed checkpoints with the public key, for the accountant
+func (h *TransactionHandler) GetCheckpoints(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.transactionService.ExportCheckpoints(r.Context(), claims.ShopID))
+}
+
+// CreateCheckpoint - POST /transactions/checkpoints (SuperAdmin only)
+// Signs the current head of the chain, e.g. when closing a period
+func (h *TransactionHandler) CreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	checkpoint := h.transactionService.Checkpoint(r.Context(), claims.ShopID)
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "checkpoint.create", Entity: "checkpoint", EntityID: checkpoint.ID}, nil, checkpoint)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(checkpoint)
+}
+
 // GetDashboard - GET /reports/dashboard (private - SuperAdmin only)
 func (h *TransactionHandler) GetDashboard(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetClaims(r)
 	if !ok {
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
-	stats, err := h.transactionService.GetDashboard(claims.ShopID)
+	stats, err := h.transactionService.GetDashboard(r.Context(), claims.ShopID)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
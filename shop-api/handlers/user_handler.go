@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"shop-api/config"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"strconv"
+)
+
+type UserHandler struct {
+	userService       services.UserService
+	membershipService services.MembershipService
+	auditService      services.AuditService
+}
+
+func NewUserHandler(userService services.UserService, membershipService services.MembershipService, auditService services.AuditService) *UserHandler {
+	return &UserHandler{
+		userService:       userService,
+		membershipService: membershipService,
+		auditService:      auditService,
+	}
+}
+
+type UpdateUserRequest struct {
+	Name  string      `json:"name" validate:"required,max=100"`
+	Email string      `json:"email" validate:"required,email,max=254"`
+	Role  models.Role `json:"role" validate:"required,oneof=SuperAdmin Admin"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required,max=256"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// ChangePasswordResponse carries a new token, as the change revokes the
+// caller's previous ones
+type ChangePasswordResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+}
+
+type PasswordResetResponse struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// ShopUserResponse is a user together with their role in the active shop
+type ShopUserResponse struct {
+	models.UserResponse
+	ShopRole   models.Role `json:"shop_role"`
+	ShopActive bool        `json:"shop_active"` // false while the active shop suspends their access
+}
+
+func shopUser(user *models.User, membership *models.Membership) ShopUserResponse {
+	return ShopUserResponse{
+		UserResponse: user.ToResponse(),
+		ShopRole:     membership.Role,
+		ShopActive:   membership.Active,
+	}
+}
+
+// GetAll - GET /users (SuperAdmin only)
+func (h *UserHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Members of the active shop, whatever their home shop
+	users := []ShopUserResponse{}
+	for _, membership := range h.membershipService.GetByShopID(claims.ShopID) {
+		user, err := h.userService.GetByID(membership.UserID)
+		if err != nil {
+			continue
+		}
+		users = append(users, shopUser(user, &membership))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// shopMember reads the {id} path value and checks the user belongs to the
+// caller's active shop
+func (h *UserHandler) shopMember(w http.ResponseWriter, r *http.Request) (*models.User, *models.Membership, bool) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return nil, nil, false
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "Invalid user ID")
+		return nil, nil, false
+	}
+
+	membership, err := h.membershipService.Get(id, claims.ShopID)
+	if err != nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return nil, nil, false
+	}
+
+	user, err := h.userService.GetByID(id)
+	if err != nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return nil, nil, false
+	}
+
+	return user, membership, true
+}
+
+// Get - GET /users/{id} (SuperAdmin only)
+func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
+	user, membership, ok := h.shopMember(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shopUser(user, membership))
+}
+
+// ownsAccount reports whether the caller may change the user's account, which
+// every shop of the user shares: it must be their own, or the caller must be a
+// SuperAdmin of all those shops. Other changes stay within the active shop.
+func (h *UserHandler) ownsAccount(r *http.Request, user *models.User) bool {
+	claims, _ := middleware.GetClaims(r)
+	return user.ID == claims.UserID || h.membershipService.AdministersAllShops(claims.UserID, user.ID)
+}
+
+// Update - PUT /users/{id} (SuperAdmin only)
+// The name and email can only be changed by a SuperAdmin of every shop of the user
+func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
+	user, membership, ok := h.shopMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateUserRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	expectedVersion, ok := ifMatchVersion(w, r, user.Version)
+	if !ok {
+		return
+	}
+
+	updated := user
+	if req.Name != user.Name || req.Email != user.Email {
+		if !h.ownsAccount(r, user) {
+			problem.Error(w, r, http.StatusForbidden, problem.CodeForbiddenTenant,
+				"The user also belongs to shops you don't administer: only their role here can be changed")
+			return
+		}
+
+		var err error
+		updated, err = h.userService.Update(user.ID, req.Name, req.Email, expectedVersion)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	// The role is per shop, so it is changed on the membership
+	before := shopUser(user, membership)
+	membership.Role = req.Role
+	if _, err := h.membershipService.Create(*membership); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.update", Entity: "user", EntityID: user.ID},
+		before, shopUser(updated, membership))
+
+	w.Header().Set("ETag", etag(updated.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shopUser(updated, membership))
+}
+
+// Deactivate - POST /users/{id}/deactivate (SuperAdmin only)
+func (h *UserHandler) Deactivate(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, false)
+}
+
+// Reactivate - POST /users/{id}/reactivate (SuperAdmin only)
+func (h *UserHandler) Reactivate(w http.ResponseWriter, r *http.Request) {
+	h.setActive(w, r, true)
+}
+
+// setActive changes the account when the caller administers every shop of the
+// user, and otherwise only the user's access to the active shop
+func (h *UserHandler) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	user, membership, ok := h.shopMember(w, r)
+	if !ok {
+		return
+	}
+
+	claims, _ := middleware.GetClaims(r)
+	if user.ID == claims.UserID && !active {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput, "You cannot deactivate your own account")
+		return
+	}
+
+	expectedVersion, ok := ifMatchVersion(w, r, user.Version)
+	if !ok {
+		return
+	}
+
+	if !h.ownsAccount(r, user) {
+		if err := h.membershipService.SetActive(user.ID, claims.ShopID, active); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		action, message := "membership.deactivate", "User deactivated in this shop"
+		if active {
+			action, message = "membership.reactivate", "User reactivated in this shop"
+		}
+		recordAudit(h.auditService, r, models.AuditEntry{Action: action, Entity: "membership", EntityID: membership.ID},
+			map[string]bool{"active": membership.Active}, map[string]bool{"active": active})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": message,
+		})
+		return
+	}
+
+	if err := h.userService.SetActive(user.ID, active, expectedVersion); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	action, message := "user.deactivate", "User deactivated successfully"
+	if active {
+		action, message = "user.reactivate", "User reactivated successfully"
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: action, Entity: "user", EntityID: user.ID},
+		map[string]bool{"active": user.Active}, map[string]bool{"active": active})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
+
+// CreatePasswordReset - POST /users/{id}/password-reset (SuperAdmin only)
+// The returned token is handed to the user, who redeems it at POST /password/reset.
+// Only a SuperAdmin of every shop of the user may issue it.
+func (h *UserHandler) CreatePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.shopMember(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.ownsAccount(r, user) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbiddenTenant,
+			"The user also belongs to shops you don't administer: ask them to change their password")
+		return
+	}
+
+	token, err := h.userService.CreatePasswordReset(user.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.password_reset", Entity: "user", EntityID: user.ID}, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PasswordResetResponse{
+		Token:     token,
+		ExpiresIn: int(config.PasswordResetExpiration.Seconds()),
+	})
+}
+
+// Unlock - POST /users/{id}/unlock (SuperAdmin only)
+// Clears failed login attempts so a locked-out user can sign in again. The
+// lockout guards every shop of the user, so only a SuperAdmin of all of them may lift it.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.shopMember(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.ownsAccount(r, user) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbiddenTenant,
+			"The user also belongs to shops you don't administer: ask a SuperAdmin of all of them to unlock the account")
+		return
+	}
+
+	if err := h.userService.Unlock(user.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.unlock", Entity: "user", EntityID: user.ID}, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User unlocked successfully",
+	})
+}
+
+// ChangePassword - PUT /me/password (private - requires auth)
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.userService.ChangePassword(claims.UserID, req.OldPassword, req.NewPassword); err != nil {
+		writePasswordError(w, r, err, "new_password")
+		return
+	}
+	recordAudit(h.auditService, r, models.AuditEntry{Action: "user.password_change", Entity: "user", EntityID: claims.UserID}, nil, nil)
+
+	// The change revoked every token of the user, this session's included
+	_, _, token, err := h.userService.SwitchShop(claims.UserID, claims.ShopID, claims.TwoFactor)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ChangePasswordResponse{
+		Message: "Password changed successfully",
+		Token:   token,
+	})
+}
+
+// ResetPassword - POST /password/reset (public - requires a reset token)
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.userService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		writePasswordError(w, r, err, "new_password")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password reset successfully",
+	})
+}
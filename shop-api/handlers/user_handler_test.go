@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/config"
+	"shop-api/middleware"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"testing"
+)
+
+// lockedOutUser returns a user handler whose admin@shop1.com (user 2) is
+// locked out, with the login attempts that lock it
+func lockedOutUser(t *testing.T) (*UserHandler, services.MembershipService, services.LoginAttemptService) {
+	t.Helper()
+	memberships := services.NewMembershipService()
+	attempts := services.NewLoginAttemptService()
+	users := services.NewUserService(memberships, attempts)
+
+	for range config.LoginMaxAccountFailures {
+		attempts.RecordFailure("admin@shop1.com", "203.0.113.7")
+	}
+	if attempts.Check("admin@shop1.com", "198.51.100.1") == nil {
+		t.Fatal("the account is not locked out")
+	}
+	return NewUserHandler(users, memberships, services.NewAuditService()), memberships, attempts
+}
+
+func unlock(handler *UserHandler, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+id+"/unlock", nil)
+	r.SetPathValue("id", id)
+	r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsContextKey, shop1SuperAdmin))
+
+	w := httptest.NewRecorder()
+	handler.Unlock(w, r)
+	return w
+}
+
+func TestUnlock(t *testing.T) {
+	handler, _, attempts := lockedOutUser(t)
+
+	if w := unlock(handler, "2"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+	}
+	if err := attempts.Check("admin@shop1.com", "198.51.100.1"); err != nil {
+		t.Errorf("still locked out: %v", err)
+	}
+}
+
+func TestUnlockNeedsEveryShopOfTheUser(t *testing.T) {
+	handler, memberships, attempts := lockedOutUser(t)
+	// The SuperAdmin of shop 1 does not administer shop 2
+	if _, err := memberships.Create(models.Membership{UserID: 2, ShopID: 2, Role: models.RoleAdmin, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := unlock(handler, "2")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403 (%s)", w.Code, w.Body.String())
+	}
+	if p := decodeProblem(t, w); p.Code != problem.CodeForbiddenTenant {
+		t.Errorf("code = %s, want %s", p.Code, problem.CodeForbiddenTenant)
+	}
+	if attempts.Check("admin@shop1.com", "198.51.100.1") == nil {
+		t.Error("the account was unlocked")
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Check reports whether a dependency can serve requests
+type Check func(ctx context.Context) error
+
+// Status is the body of /healthz and /readyz
+type Status struct {
+	Status string            `json:"status"` // ok, ready or unavailable
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Checker answers the liveness and readiness probes
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+// NewChecker returns a Checker whose checks must all answer within timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Register adds a readiness check. Checks are registered before serving.
+func (c *Checker) Register(name string, check Check) {
+	c.names = append(c.names, name)
+	c.checks[name] = check
+}
+
+// Live - GET /healthz (public). The process is up and serving HTTP.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, Status{Status: "ok"})
+}
+
+// Ready - GET /readyz (public). Every registered check passed; the failing ones are named.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+
+	status := Status{Status: "ready", Checks: make(map[string]string, len(c.names))}
+	code := http.StatusOK
+	for _, name := range c.names {
+		if err := c.checks[name](ctx); err != nil {
+			status.Checks[name] = err.Error()
+			status.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		status.Checks[name] = "ok"
+	}
+	write(w, code, status)
+}
+
+func write(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,103 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"shop-api/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GenesisHash is the previous hash of a shop's first transaction
+var GenesisHash = strings.Repeat("0", 64)
+
+// Reasons a chain verification fails
+const (
+	BreakPrevHash            = "prev_hash_mismatch"   // a transaction was inserted, removed or reordered
+	BreakHash                = "hash_mismatch"        // a transaction was altered
+	BreakSignature           = "bad_signature"        // a checkpoint was forged or altered
+	BreakCheckpoint          = "checkpoint_mismatch"  // the chain was rewritten since a checkpoint
+	BreakMissingTransactions = "missing_transactions" // the chain is shorter than a checkpoint
+)
+
+// Verification is the result of walking a shop's chain
+type Verification struct {
+	ShopID       int    `json:"shop_id"`
+	Valid        bool   `json:"valid"`
+	Transactions int    `json:"transactions"` // transactions in the chain
+	Checkpoints  int    `json:"checkpoints"`  // checkpoints checked against it
+	Head         string `json:"head"`         // hash of the last transaction verified
+	Break        *Break `json:"break,omitempty"`
+}
+
+// Break describes the first inconsistency found
+type Break struct {
+	Reason        string `json:"reason"`
+	Position      int    `json:"position"` // 1-based position in the chain
+	TransactionID int    `json:"transaction_id,omitempty"`
+	CheckpointID  int    `json:"checkpoint_id,omitempty"` // set when a checkpoint disagrees
+	Expected      string `json:"expected,omitempty"`
+	Actual        string `json:"actual,omitempty"`
+}
+
+// Hash computes the hash of a transaction chained to its PrevHash. Every
+// field but Hash is covered, so changing any of them breaks the chain.
+func Hash(t models.Transaction) string {
+	productID := ""
+	if t.ProductID != nil {
+		productID = strconv.Itoa(*t.ProductID)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%s\n%s\n%d\n%s\n%s\n",
+		t.PrevHash, t.ID, t.ShopID, t.Type, productID, t.Quantity,
+		strconv.FormatFloat(t.Amount, 'f', -1, 64), t.CreatedAt.UTC().Format(time.RFC3339Nano))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify walks a shop's transactions in chain order, then checks each
+// checkpoint's signature and that the chain still holds what it signed.
+// It stops at the first break.
+func Verify(shopID int, transactions []models.Transaction, checkpoints []models.ChainCheckpoint, publicKey []byte) Verification {
+	result := Verification{ShopID: shopID, Transactions: len(transactions), Head: GenesisHash}
+
+	for i, t := range transactions {
+		if t.PrevHash != result.Head {
+			result.Break = &Break{Reason: BreakPrevHash, Position: i + 1, TransactionID: t.ID, Expected: result.Head, Actual: t.PrevHash}
+			return result
+		}
+		if hash := Hash(t); hash != t.Hash {
+			result.Break = &Break{Reason: BreakHash, Position: i + 1, TransactionID: t.ID, Expected: hash, Actual: t.Hash}
+			return result
+		}
+		result.Head = t.Hash
+	}
+
+	for _, c := range checkpoints {
+		result.Checkpoints++
+		if !VerifyCheckpoint(publicKey, c) {
+			result.Break = &Break{Reason: BreakSignature, Position: c.Count, TransactionID: c.TransactionID, CheckpointID: c.ID}
+			return result
+		}
+		if c.Count > len(transactions) {
+			result.Break = &Break{Reason: BreakMissingTransactions, Position: c.Count, TransactionID: c.TransactionID, CheckpointID: c.ID,
+				Expected: c.Hash}
+			return result
+		}
+
+		hash, transactionID := GenesisHash, 0
+		if c.Count > 0 {
+			hash, transactionID = transactions[c.Count-1].Hash, transactions[c.Count-1].ID
+		}
+		if hash != c.Hash || transactionID != c.TransactionID {
+			result.Break = &Break{Reason: BreakCheckpoint, Position: c.Count, TransactionID: c.TransactionID, CheckpointID: c.ID,
+				Expected: c.Hash, Actual: hash}
+			return result
+		}
+	}
+
+	result.Valid = true
+	return result
+}
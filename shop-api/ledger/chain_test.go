@@ -0,0 +1,194 @@
+package ledger
+
+import (
+	"shop-api/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testChain returns n chained transactions of shop 1
+func testChain(n int) []models.Transaction {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	productID := 1
+
+	var transactions []models.Transaction
+	head := GenesisHash
+	for i := range n {
+		t := models.Transaction{
+			ID:        i + 1,
+			Type:      models.TransactionSale,
+			ProductID: &productID,
+			Quantity:  1,
+			Amount:    float64(100 * (i + 1)),
+			ShopID:    1,
+			CreatedAt: start.Add(time.Duration(i) * time.Hour),
+			PrevHash:  head,
+		}
+		t.Hash = Hash(t)
+		head = t.Hash
+		transactions = append(transactions, t)
+	}
+	return transactions
+}
+
+// rechain recomputes every hash, as someone rewriting the history would
+func rechain(transactions []models.Transaction) {
+	head := GenesisHash
+	for i := range transactions {
+		transactions[i].PrevHash = head
+		transactions[i].Hash = Hash(transactions[i])
+		head = transactions[i].Hash
+	}
+}
+
+// checkpoint signs the head of the chain after its first count transactions
+func checkpoint(signer *Signer, transactions []models.Transaction, count int) models.ChainCheckpoint {
+	c := models.ChainCheckpoint{
+		ID:            1,
+		ShopID:        1,
+		TransactionID: transactions[count-1].ID,
+		Count:         count,
+		Hash:          transactions[count-1].Hash,
+		CreatedAt:     time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+	}
+	signer.Sign(&c)
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	signer, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// tamper changes the chain and its checkpoint, signed over the first
+		// 4 of 5 transactions
+		tamper        func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction
+		reason        string // "" when the chain is valid
+		position      int
+		transactionID int
+	}{
+		{
+			name: "untouched",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				return transactions
+			},
+		},
+		{
+			name: "modified amount",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions[2].Amount = 1
+				return transactions
+			},
+			reason: BreakHash, position: 3, transactionID: 3,
+		},
+		{
+			name: "modified date",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions[1].CreatedAt = transactions[1].CreatedAt.Add(-24 * time.Hour)
+				return transactions
+			},
+			reason: BreakHash, position: 2, transactionID: 2,
+		},
+		{
+			name: "modified amount with every hash recomputed",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions[2].Amount = 1
+				rechain(transactions)
+				return transactions
+			},
+			reason: BreakCheckpoint, position: 4, transactionID: 4,
+		},
+		{
+			name: "deleted transaction",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				return slices.Delete(transactions, 1, 2)
+			},
+			reason: BreakPrevHash, position: 2, transactionID: 3,
+		},
+		{
+			name: "deleted transaction with every hash recomputed",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions = slices.Delete(transactions, 1, 2)
+				rechain(transactions)
+				return transactions
+			},
+			reason: BreakCheckpoint, position: 4, transactionID: 4,
+		},
+		{
+			name: "reordered transactions",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions[1], transactions[2] = transactions[2], transactions[1]
+				return transactions
+			},
+			reason: BreakPrevHash, position: 2, transactionID: 3,
+		},
+		{
+			name: "truncated chain",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				return transactions[:3]
+			},
+			reason: BreakMissingTransactions, position: 4, transactionID: 4,
+		},
+		{
+			name: "forged checkpoint",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				c.Hash = transactions[4].Hash
+				c.Count, c.TransactionID = 5, 5
+				return transactions
+			},
+			reason: BreakSignature, position: 5, transactionID: 5,
+		},
+		{
+			name: "checkpoint re-signed with another key",
+			tamper: func(transactions []models.Transaction, c *models.ChainCheckpoint) []models.Transaction {
+				transactions[2].Amount = 1
+				rechain(transactions)
+				*c = checkpoint(otherSigner, transactions, 4)
+				return transactions
+			},
+			reason: BreakSignature, position: 4, transactionID: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactions := testChain(5)
+			c := checkpoint(signer, transactions, 4)
+			transactions = tt.tamper(transactions, &c)
+
+			result := Verify(1, transactions, []models.ChainCheckpoint{c}, signer.PublicKey())
+
+			if tt.reason == "" {
+				if !result.Valid || result.Break != nil {
+					t.Fatalf("chain broken: %+v", result.Break)
+				}
+				if result.Head != transactions[4].Hash || result.Checkpoints != 1 {
+					t.Errorf("head %s after %d checkpoints, want %s after 1", result.Head, result.Checkpoints, transactions[4].Hash)
+				}
+				return
+			}
+			if result.Valid || result.Break == nil {
+				t.Fatal("tampering not detected")
+			}
+			if b := result.Break; b.Reason != tt.reason || b.Position != tt.position || b.TransactionID != tt.transactionID {
+				t.Errorf("break = %s at %d (transaction %d), want %s at %d (transaction %d)",
+					b.Reason, b.Position, b.TransactionID, tt.reason, tt.position, tt.transactionID)
+			}
+		})
+	}
+}
+
+func TestVerifyEmptyChain(t *testing.T) {
+	result := Verify(1, nil, nil, nil)
+	if !result.Valid || result.Head != GenesisHash {
+		t.Errorf("result = %+v, want a valid chain at the genesis hash", result)
+	}
+}
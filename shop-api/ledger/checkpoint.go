@@ -0,0 +1,125 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shop-api/models"
+	"strings"
+	"time"
+)
+
+// Algorithm signs checkpoints
+const Algorithm = "Ed25519"
+
+// CheckpointExport is what an accountant needs to check a shop's checkpoints:
+// the public key, and every checkpoint signed with it
+type CheckpointExport struct {
+	ShopID      int                      `json:"shop_id"`
+	Algorithm   string                   `json:"algorithm"`
+	PublicKey   string                   `json:"public_key"` // base64
+	Checkpoints []models.ChainCheckpoint `json:"checkpoints"`
+}
+
+// Signer signs checkpoints with an Ed25519 key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner reads a base64 Ed25519 seed (32 bytes)
+func NewSigner(seed string) (*Signer, error) {
+	if seed == "" {
+		return nil, errors.New("no signing key")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not base64: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, errors.New("signing key must be a 32-byte Ed25519 seed")
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(raw)}, nil
+}
+
+// GenerateSigner signs with a new key, which only lasts as long as the
+// process: checkpoints it signs cannot be verified once it is gone
+func GenerateSigner() (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key: key}, nil
+}
+
+// LoadSigner reads the seed stored in a file, first writing a new one there
+// if the file does not exist, so that the key outlives restarts. created
+// reports that the key was generated.
+func LoadSigner(path string) (signer *Signer, created bool, err error) {
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := NewSigner(string(seed))
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return signer, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	signer, err = GenerateSigner()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, false, err
+	}
+	// O_EXCL: never replace a key another process just wrote
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(signer.key.Seed()) + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, false, err
+	}
+	return signer, true, nil
+}
+
+// PublicKey returns the key that verifies the signatures
+func (s *Signer) PublicKey() []byte {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign sets the signature of a checkpoint
+func (s *Signer) Sign(c *models.ChainCheckpoint) {
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedPayload(*c)))
+}
+
+// VerifyCheckpoint reports whether a checkpoint was signed by the key's owner
+// and left unchanged since
+func VerifyCheckpoint(publicKey []byte, c models.ChainCheckpoint) bool {
+	signature, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(publicKey, signedPayload(c), signature)
+}
+
+// signedPayload covers every field of a checkpoint but its signature
+func signedPayload(c models.ChainCheckpoint) []byte {
+	return fmt.Appendf(nil, "shop-api checkpoint v1\n%d\n%d\n%d\n%d\n%s\n%s\n",
+		c.ID, c.ShopID, c.TransactionID, c.Count, c.Hash, c.CreatedAt.UTC().Format(time.RFC3339Nano))
+}
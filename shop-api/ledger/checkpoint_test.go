@@ -0,0 +1,57 @@
+package ledger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSignerRequiresAKey(t *testing.T) {
+	for _, seed := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := NewSigner(seed); err == nil {
+			t.Errorf("NewSigner(%q) succeeded", seed)
+		}
+	}
+}
+
+func TestLoadSignerKeepsItsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "chain-signing-key")
+
+	first, created, err := LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("created = false for a missing file")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// After a restart, checkpoints are signed with the same key
+	second, created, err := LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("created = true for an existing file")
+	}
+	if !bytes.Equal(first.PublicKey(), second.PublicKey()) {
+		t.Error("a restart changed the signing key")
+	}
+}
+
+func TestLoadSignerRejectsACorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain-signing-key")
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadSigner(path); err == nil {
+		t.Fatal("a corrupt key file was accepted")
+	}
+}
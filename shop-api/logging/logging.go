@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are substrings of attribute keys whose values are never logged
+var sensitiveKeys = []string{"password", "authorization", "token", "secret", "api-key", "api_key", "cookie"}
+
+// New builds a logger writing to w at level ("debug", "info", "warn" or
+// "error") in format ("text" or "json"), with sensitive attributes redacted
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel, ReplaceAttr: redact}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// redact hides the values of attributes named like a secret, at any depth
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup || len(groups) == 0 && isBuiltin(attr.Key) {
+		return attr
+	}
+
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(attr.Key, Redacted)
+		}
+	}
+	return attr
+}
+
+func isBuiltin(key string) bool {
+	return key == slog.TimeKey || key == slog.LevelKey || key == slog.MessageKey || key == slog.SourceKey
+}
+
+// Headers returns request headers as a log group; credentials among them are redacted
+func Headers(header http.Header) slog.Attr {
+	attrs := make([]any, 0, len(header))
+	for name, values := range header {
+		attrs = append(attrs, slog.String(name, strings.Join(values, ", ")))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying a request-scoped logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the request-scoped logger, or the default one
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
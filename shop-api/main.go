@@ -1,165 +1,329 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"shop-api/app"
 	"shop-api/config"
-	"shop-api/handlers"
+	"shop-api/events"
+	"shop-api/health"
+	"shop-api/ledger"
+	"shop-api/logging"
+	"shop-api/metrics"
 	"shop-api/middleware"
-	"shop-api/services"
-	"strings"
+	"shop-api/router"
+	"shop-api/tracing"
+	"shop-api/utils"
+	"syscall"
+	"time"
 )
 
 func main() {
-	// Initialize services
-	shopService := services.NewShopService()
-	userService := services.NewUserService()
-	productService := services.NewProductService()
-	transactionService := services.NewTransactionService(productService)
-
-	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userService, shopService)
-	productHandler := handlers.NewProductHandler(productService, shopService)
-	transactionHandler := handlers.NewTransactionHandler(transactionService)
-	shopHandler := handlers.NewShopHandler(shopService)
-
-	// Setup routes
-	mux := http.NewServeMux()
-
-	// Auth routes (public)
-	mux.HandleFunc("/register", methodHandler("POST", authHandler.Register))
-	mux.HandleFunc("/login", methodHandler("POST", authHandler.Login))
-
-	// Public routes (no auth required)
-	mux.HandleFunc("/public/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/products") && r.Method == http.MethodGet {
-			productHandler.GetPublicProducts(w, r)
-		} else {
-			http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
-		}
-	})
-
-	// Product routes (private - requires auth)
-	mux.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			middleware.AuthMiddleware(productHandler.GetAll)(w, r)
-		case http.MethodPost:
-			middleware.AuthMiddleware(productHandler.Create)(w, r)
-		default:
-			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	logger, err := logging.New(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatal("Invalid logging configuration: ", err)
+	}
+	slog.SetDefault(logger)
+
+	// With a single TLS file set, serving plain HTTP would silently drop the
+	// HTTPS the operator asked for
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		fatal("invalid TLS configuration", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TraceExporter, config.TraceServiceName)
+	if err != nil {
+		fatal("invalid tracing configuration", err)
+	}
+
+	// Checkpoints of the transaction chain are signed with this key. It must
+	// survive restarts, or exported checkpoints could no longer be verified.
+	checkpointSigner, err := loadCheckpointSigner()
+	if err != nil {
+		fatal("invalid checkpoint signing key", err)
+	}
+	slog.Info("checkpoints are signed with Ed25519", "public_key", base64.StdEncoding.EncodeToString(checkpointSigner.PublicKey()))
+
+	// Domain events stay in the outbox file until every subscriber handled them
+	eventBus, err := events.NewBus(config.EventOutboxFile)
+	if err != nil {
+		fatal("invalid EVENT_OUTBOX_FILE", err)
+	}
+
+	// Client IPs (logs, rate limits, login throttling) come from
+	// X-Forwarded-For only when the connection is from a trusted proxy
+	proxies, err := middleware.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		fatal("invalid reverse proxy configuration", err)
+	}
+	middleware.UseTrustedProxies(proxies)
+
+	// Services, handlers and routes (see package app)
+	api, err := app.New(eventBus, checkpointSigner)
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	r := api.Router
+
+	// Probes: liveness, and readiness of every store
+	checker := health.NewChecker(config.ReadinessTimeout)
+	checker.Register("users", api.Users.Ping)
+	checker.Register("shops", api.Shops.Ping)
+	checker.Register("memberships", api.Memberships.Ping)
+	checker.Register("api_keys", api.APIKeys.Ping)
+	checker.Register("products", api.Products.Ping)
+	checker.Register("transactions", api.Transactions.Ping)
+	checker.Register("audit", api.Audit.Ping)
+	checker.Register("reports", api.Reports.Ping)
+	checker.Register("events", eventBus.Ping)
+	r.HandleRoot("GET", "/healthz", checker.Live)
+	r.HandleRoot("GET", "/readyz", checker.Ready)
+
+	// Prometheus metrics. Business figures (revenue per shop) are read from the
+	// services at scrape time, and only exported behind METRICS_TOKEN.
+	if config.MetricsToken != "" {
+		metrics.RegisterBusinessMetrics(api.Shops, api.Products, api.Transactions)
+	} else {
+		slog.Warn("METRICS_TOKEN is not set: /metrics is open and leaves out the sales and stock figures")
+	}
+	r.HandleRoot("GET", "/metrics", metrics.Handler())
+
+	// Root handler - serves static files for non-API routes
+	r.Fallback(staticHandler("./frontend"))
+
+	// Browsers may embed the public catalog anywhere; the rest of the API only
+	// answers the configured frontend origins
+	publicCatalog := middleware.CORSPolicy{
+		Origins: []string{"*"},
+		Methods: []string{"GET", "HEAD"},
+		MaxAge:  config.CORSMaxAge,
+	}
+	frontends := middleware.CORSPolicy{
+		Origins:          config.CORSAllowedOrigins,
+		Methods:          []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowCredentials: config.CORSAllowCredentials,
+		MaxAge:           config.CORSMaxAge,
+	}
+	handler := middleware.CORS(frontends, []middleware.CORSRoute{
+		{Prefix: config.APIPrefix + "/public/", Policy: publicCatalog},
+		{Prefix: "/public/", Policy: publicCatalog},
+	}, middleware.SecurityHeaders(middleware.AccessLog(middleware.Metrics(middleware.Tracing(r)))))
+
+	// Start server, over TLS when a certificate is configured
+	server := &http.Server{
+		Addr:              config.ServerPort,
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Deliver events, starting with those the last run left in the outbox
+	go eventBus.Run(ctx)
+
+	// Sign the head of every shop's transaction chain that moved since its last checkpoint
+	go func() {
+		ticker := time.NewTicker(config.ChainCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				api.Transactions.CheckpointAll(context.Background())
+			}
 		}
-	})
-
-	mux.HandleFunc("/products/", func(w http.ResponseWriter, r *http.Request) {
-		// Handle /products/:id
-		switch r.Method {
-		case http.MethodPut:
-			middleware.AuthMiddleware(productHandler.Update)(w, r)
-		case http.MethodDelete:
-			middleware.AuthMiddleware(productHandler.Delete)(w, r)
-		default:
-			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}()
+
+	serverErr := make(chan error, 2)
+	var redirectServer *http.Server
+	if config.TLSCertFile != "" {
+		certificates, err := utils.NewCertificateReloader(config.TLSCertFile, config.TLSKeyFile, config.TLSReloadInterval)
+		if err != nil {
+			fatal("invalid TLS configuration", err)
 		}
-	})
-
-	// Transaction routes (private - requires admin)
-	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			middleware.RequireAdmin(transactionHandler.GetAll)(w, r)
-		case http.MethodPost:
-			middleware.RequireAdmin(transactionHandler.Create)(w, r)
-		default:
-			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certificates.GetCertificate}
+		go func() {
+			serverErr <- server.ListenAndServeTLS("", "")
+		}()
+
+		if config.HTTPRedirectAddr != "" {
+			redirectServer = &http.Server{
+				Addr:              config.HTTPRedirectAddr,
+				Handler:           middleware.RedirectHTTPS(config.ServerPort),
+				ReadHeaderTimeout: config.ReadHeaderTimeout,
+				IdleTimeout:       config.IdleTimeout,
+				ErrorLog:          server.ErrorLog,
+			}
+			go func() {
+				serverErr <- redirectServer.ListenAndServe()
+			}()
 		}
-	})
+	} else {
+		go func() {
+			serverErr <- server.ListenAndServe()
+		}()
+	}
 
-	// Dashboard route (SuperAdmin only)
-	mux.HandleFunc(
-		"/reports/dashboard",
-		methodHandler(
-			"GET",
-			middleware.RequireSuperAdmin(transactionHandler.GetDashboard),
-		),
-	)
+	if config.LogFormat == "text" {
+		printBanner()
+	}
+	slog.Info("server started", "addr", config.ServerPort, "tls", server.TLSConfig != nil, "api_prefix", config.APIPrefix, "log_level", config.LogLevel, "trace_exporter", config.TraceExporter)
 
-	// Shop routes
-	mux.HandleFunc("/shops", methodHandler("GET",
-		middleware.AuthMiddleware(shopHandler.GetAll)))
+	select {
+	case err := <-serverErr:
+		fatal("server failed to start", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process without waiting
+	stop()
 
-	mux.HandleFunc("/shops/whatsapp", methodHandler("PUT",
-		middleware.RequireSuperAdmin(shopHandler.UpdateWhatsApp)))
+	// Stop accepting connections, let in-flight requests (sales included)
+	// complete, then flush what is still buffered
+	slog.Info("shutting down", "timeout", config.ShutdownTimeout)
 
-	// Root handler - serves static files for non-API routes
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Only serve static files for GET requests
-		if r.Method != http.MethodGet {
-			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
-			return
-		}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
 
-		// Serve static files
-		http.FileServer(http.Dir("./frontend")).ServeHTTP(w, r)
-	})
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("requests still in flight at shutdown", "error", err)
+	}
+	if err := eventBus.Shutdown(shutdownCtx); err != nil {
+		slog.Error("closing the event outbox failed", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+	slog.Info("server stopped")
+}
 
-	// Start server
+// loadCheckpointSigner reads the key from CHAIN_SIGNING_KEY, or else from
+// CHAIN_SIGNING_KEY_FILE, which is created with a new key on the first start
+func loadCheckpointSigner() (*ledger.Signer, error) {
+	if config.ChainSigningKey != "" {
+		return ledger.NewSigner(config.ChainSigningKey)
+	}
+
+	signer, created, err := ledger.LoadSigner(config.ChainSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		slog.Warn("CHAIN_SIGNING_KEY is not set: generated a checkpoint signing key; keep its file, or checkpoints signed so far can no longer be verified",
+			"file", config.ChainSigningKeyFile)
+	}
+	return signer, nil
+}
+
+// fatal logs an error that prevents the server from running, and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// printBanner lists the endpoints for developers running the server locally
+func printBanner() {
 	fmt.Println("🚀 Shop Management API Server Started")
 	fmt.Printf("📍 Server running on http://localhost%s\n", config.ServerPort)
-	fmt.Println("\n📋 Available Endpoints:")
+	fmt.Printf("\n📋 Available Endpoints (prefix %s, unprefixed paths are deprecated):\n", config.APIPrefix)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println("\n🔓 PUBLIC ROUTES:")
 	fmt.Println("   POST   /register")
 	fmt.Println("   POST   /login")
-	fmt.Println("   GET    /public/:shopID/products")
+	fmt.Println("   POST   /login/2fa")
+	fmt.Println("   GET    /public/{shopID}/products")
+	fmt.Println("   POST   /password/reset")
+	if config.OIDCIssuerURL != "" {
+		fmt.Println("   GET    /auth/oidc/login")
+		fmt.Println("   GET    /auth/oidc/callback")
+	}
 	fmt.Println("\n🔒 PRIVATE ROUTES (requires auth):")
 	fmt.Println("   GET    /products")
 	fmt.Println("   POST   /products")
-	fmt.Println("   PUT    /products/:id")
-	fmt.Println("   DELETE /products/:id")
+	fmt.Println("   GET    /products/{id}")
+	fmt.Println("   PUT    /products/{id}")
+	fmt.Println("   PATCH  /products/{id}")
+	fmt.Println("   DELETE /products/{id}")
+	fmt.Println("   GET    /me/shops")
+	fmt.Println("   GET    /me/invitations")
+	fmt.Println("   POST   /me/invitations/{id}/accept")
+	fmt.Println("   POST   /me/invitations/{id}/decline")
+	fmt.Println("   GET    /shops/current")
+	fmt.Println("   POST   /shops/switch")
+	fmt.Println("   PUT    /me/password")
+	fmt.Println("   POST   /me/2fa/setup")
+	fmt.Println("   POST   /me/2fa/enable")
+	fmt.Println("   POST   /me/2fa/disable")
 	fmt.Println("\n👥 ADMIN ROUTES:")
 	fmt.Println("   GET    /transactions")
 	fmt.Println("   POST   /transactions")
+	fmt.Println("   GET    /reports/low-stock")
 	fmt.Println("\n👑 SUPER ADMIN ROUTES:")
 	fmt.Println("   GET    /reports/dashboard")
+	fmt.Println("   GET    /reports/sales")
+	fmt.Println("   GET    /transactions/verify")
+	fmt.Println("   GET    /transactions/checkpoints")
+	fmt.Println("   POST   /transactions/checkpoints")
 	fmt.Println("   PUT    /shops/whatsapp")
 	fmt.Println("   GET    /shops")
+	fmt.Println("   PUT    /shops/2fa")
+	fmt.Println("   POST   /shops/members")
+	fmt.Println("   GET    /users")
+	fmt.Println("   GET    /users/{id}")
+	fmt.Println("   PUT    /users/{id}")
+	fmt.Println("   POST   /users/{id}/deactivate")
+	fmt.Println("   POST   /users/{id}/reactivate")
+	fmt.Println("   POST   /users/{id}/password-reset")
+	fmt.Println("   POST   /users/{id}/unlock")
+	fmt.Println("   GET    /api-keys")
+	fmt.Println("   POST   /api-keys")
+	fmt.Println("   DELETE /api-keys/{id}")
+	fmt.Println("   GET    /audit")
+	fmt.Println("\n📖 API DOCUMENTATION (unprefixed):")
+	fmt.Println("   GET    /openapi.json")
+	fmt.Println("   GET    /docs")
+	fmt.Println("\n📈 MONITORING (unprefixed):")
+	fmt.Println("   GET    /metrics")
+	fmt.Println("   GET    /healthz")
+	fmt.Println("   GET    /readyz")
 	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println("\n📝 Test Accounts:")
 	fmt.Println("   SuperAdmin: super@shop1.com / admin123")
 	fmt.Println("   Admin:      admin@shop1.com / admin123")
 	fmt.Println("\n💡 Tip: Use Authorization header with 'Bearer <token>'")
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-
-	if err := http.ListenAndServe(config.ServerPort, corsMiddleware(mux)); err != nil {
-		log.Fatal("Server failed to start:", err)
-	}
+	fmt.Println("        or X-API-Key header with a shop API key")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println()
 }
 
-// Helper function to restrict HTTP methods
-func methodHandler(method string, handler http.HandlerFunc) http.HandlerFunc {
+// staticHandler serves files from dir, answering missing files with the API's JSON 404
+func staticHandler(dir string) http.HandlerFunc {
+	fileServer := http.FileServer(http.Dir(dir))
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		name := path.Clean("/" + r.URL.Path)
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			router.NotFound(w, r)
 			return
 		}
-		handler(w, r)
+		w.Header().Set("Content-Security-Policy", config.FrontendContentSecurityPolicy)
+		fileServer.ServeHTTP(w, r)
 	}
 }
-
-// CORS middleware for development
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"shop-api/config"
+	"shop-api/models"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShopLister lists the shops to report on
+type ShopLister interface {
+	GetAll() []models.Shop
+}
+
+// ProductLister lists a shop's products
+type ProductLister interface {
+	GetAll(ctx context.Context, shopID int) []models.Product
+}
+
+// TransactionLister lists a shop's transactions
+type TransactionLister interface {
+	GetAll(ctx context.Context, shopID int) []models.Transaction
+}
+
+var (
+	salesDesc = prometheus.NewDesc("shop_sales_total",
+		"Sales recorded, per shop.", []string{"shop_id"}, nil)
+	salesAmountDesc = prometheus.NewDesc("shop_sales_amount_total",
+		"Amount of the sales recorded, per shop.", []string{"shop_id"}, nil)
+	productsDesc = prometheus.NewDesc("shop_products",
+		"Products in the catalog, per shop.", []string{"shop_id"}, nil)
+	lowStockDesc = prometheus.NewDesc("shop_low_stock_products",
+		"Products whose stock is below the low-stock threshold, per shop.", []string{"shop_id"}, nil)
+)
+
+// businessCollector reads its figures from the services at each scrape, so
+// they always agree with the data the API serves
+type businessCollector struct {
+	shops        ShopLister
+	products     ProductLister
+	transactions TransactionLister
+}
+
+// RegisterBusinessMetrics exports sales and stock figures fed from the services
+func RegisterBusinessMetrics(shops ShopLister, products ProductLister, transactions TransactionLister) {
+	Registry.MustRegister(&businessCollector{
+		shops:        shops,
+		products:     products,
+		transactions: transactions,
+	})
+}
+
+func (c *businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- salesDesc
+	ch <- salesAmountDesc
+	ch <- productsDesc
+	ch <- lowStockDesc
+}
+
+func (c *businessCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	for _, shop := range c.shops.GetAll() {
+		shopID := strconv.Itoa(shop.ID)
+
+		var sales, amount float64
+		for _, transaction := range c.transactions.GetAll(ctx, shop.ID) {
+			if transaction.Type == models.TransactionSale {
+				sales++
+				amount += transaction.Amount
+			}
+		}
+
+		products := c.products.GetAll(ctx, shop.ID)
+		lowStock := 0
+		for _, product := range products {
+			if product.Stock < config.LowStockThreshold {
+				lowStock++
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(salesDesc, prometheus.CounterValue, sales, shopID)
+		ch <- prometheus.MustNewConstMetric(salesAmountDesc, prometheus.CounterValue, amount, shopID)
+		ch <- prometheus.MustNewConstMetric(productsDesc, prometheus.GaugeValue, float64(len(products)), shopID)
+		ch <- prometheus.MustNewConstMetric(lowStockDesc, prometheus.GaugeValue, float64(lowStock), shopID)
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"shop-api/config"
+	"shop-api/problem"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+// Login results counted by shop_logins_total
+const (
+	LoginSucceeded         = "success"
+	LoginFailed            = "failure"
+	LoginThrottled         = "throttled"
+	LoginTwoFactorRequired = "two_factor_required"
+)
+
+// Event delivery results counted by shop_event_deliveries_total
+const (
+	EventDelivered = "delivered"
+	EventFailed    = "failed"
+	EventDropped   = "dropped"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_http_requests_total",
+		Help: "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shop_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route pattern.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"method", "route"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_logins_total",
+		Help: "Password and second-factor login attempts by result.",
+	}, []string{"result"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_rate_limited_requests_total",
+		Help: "Requests refused with 429 by the rate limiter, by route group.",
+	}, []string{"group"})
+
+	eventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_events_published_total",
+		Help: "Domain events written to the outbox, by event type.",
+	}, []string{"type"})
+
+	eventDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_event_deliveries_total",
+		Help: "Event deliveries to subscribers by result: delivered, failed (retried later) or dropped.",
+	}, []string{"subscriber", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		logins,
+		rateLimited,
+		eventsPublished,
+		eventDeliveries,
+	)
+
+	// Export every result from the start, so rates work before the first failure
+	for _, result := range []string{LoginSucceeded, LoginFailed, LoginThrottled, LoginTwoFactorRequired} {
+		logins.WithLabelValues(result)
+	}
+}
+
+// knownMethods keeps arbitrary methods from creating new series
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// ObserveRequest records a served request. route is the matched pattern,
+// or empty for requests no route matched.
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// RecordLogin counts a login attempt by its result
+func RecordLogin(result string) {
+	logins.WithLabelValues(result).Inc()
+}
+
+// RecordRateLimited counts a request refused by the rate limiter
+func RecordRateLimited(group string) {
+	rateLimited.WithLabelValues(group).Inc()
+}
+
+// RecordEventPublished counts an event written to the outbox
+func RecordEventPublished(eventType string) {
+	eventsPublished.WithLabelValues(eventType).Inc()
+}
+
+// RecordEventDelivery counts a delivery attempt to a subscriber by its result
+func RecordEventDelivery(subscriber, result string) {
+	eventDeliveries.WithLabelValues(subscriber, result).Inc()
+}
+
+// Handler serves the registry in the Prometheus exposition format. When
+// config.MetricsToken is set, scrapers must send it as a bearer token.
+func Handler() http.HandlerFunc {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.MetricsToken != "" {
+			expected := []byte("Bearer " + config.MetricsToken)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "a valid metrics token is required")
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"shop-api/logging"
+	"shop-api/utils"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader identifies a request in its response and in the logs. A
+// well-formed ID sent by the client or a proxy is kept, so logs can be joined.
+const RequestIDHeader = "X-Request-ID"
+
+// requestLog collects what inner middleware learn about a request, for its access log line
+type requestLog struct {
+	userID   int
+	shopID   int
+	apiKeyID int
+	traceID  string
+}
+
+type requestLogKey struct{}
+
+// AccessLog logs one line per request with its route, status, latency and
+// caller, and gives handlers a logger tagged with the request ID
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID, _ = utils.GenerateRandomToken(8)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		entry := &requestLog{}
+		logger := slog.Default().With("request_id", requestID)
+		ctx := logging.NewContext(r.Context(), logger)
+		ctx = context.WithValue(ctx, requestLogKey{}, entry)
+		r = r.WithContext(ctx)
+
+		recorder := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// The mux records the matched pattern on the request, e.g. "GET /api/v1/products/{id}"
+		_, route, _ := strings.Cut(r.Pattern, " ")
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", ClientIP(r)),
+		}
+		if entry.shopID != 0 {
+			attrs = append(attrs, slog.Int("shop_id", entry.shopID))
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", entry.userID))
+		}
+		if entry.apiKeyID != 0 {
+			attrs = append(attrs, slog.Int("api_key_id", entry.apiKeyID))
+		}
+		if entry.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", entry.traceID))
+		}
+		if logger.Enabled(ctx, slog.LevelDebug) {
+			attrs = append(attrs, logging.Headers(r.Header))
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// authenticated attaches the caller's claims to the request, and their
+// identity to its logger, access log line and span
+func authenticated(r *http.Request, claims *utils.Claims) *http.Request {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.userID, entry.shopID, entry.apiKeyID = claims.UserID, claims.ShopID, claims.APIKeyID
+	}
+	traceCaller(r.Context(), claims.ShopID, claims.UserID, claims.APIKeyID)
+
+	logger := logging.FromContext(r.Context()).With("shop_id", claims.ShopID)
+	if claims.APIKeyID != 0 {
+		logger = logger.With("api_key_id", claims.APIKeyID)
+	} else {
+		logger = logger.With("user_id", claims.UserID)
+	}
+
+	ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+	return r.WithContext(logging.NewContext(ctx, logger))
+}
+
+// validRequestID accepts short IDs of letters, digits, dashes and underscores
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// accessRecorder captures the status and size of a response as it is written
+type accessRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *accessRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *accessRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (r *accessRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
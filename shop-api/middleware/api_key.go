@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/utils"
+	"slices"
+)
+
+// APIKeyHeader carries machine credentials, as an alternative to a Bearer token
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyLookup resolves a clear-text API key
+type APIKeyLookup interface {
+	Authenticate(key string) (*models.APIKey, error)
+}
+
+var apiKeys APIKeyLookup
+
+// UseAPIKeys makes AuthMiddleware accept the X-API-Key header
+func UseAPIKeys(lookup APIKeyLookup) {
+	apiKeys = lookup
+}
+
+// apiKeyMiddleware authenticates an API key and checks it has every scope the
+// route was registered with; routes registered without scopes refuse keys.
+// Keys act with Admin rights in their shop, limited by their scopes, and as
+// SuperAdmins on the SuperAdmin routes open to keys holding ScopeAdmin.
+func apiKeyMiddleware(key string, scopes []models.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiKeys == nil {
+			deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "api keys are not supported")
+			return
+		}
+
+		apiKey, err := apiKeys.Authenticate(key)
+		if err != nil {
+			deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "invalid or revoked api key")
+			return
+		}
+
+		if len(scopes) == 0 {
+			deny(w, r, http.StatusForbidden, problem.CodeForbidden, "api keys cannot call this route")
+			return
+		}
+		for _, scope := range scopes {
+			if !apiKey.HasScope(scope) {
+				deny(w, r, http.StatusForbidden, problem.CodeForbidden, "api key lacks the "+string(scope)+" scope")
+				return
+			}
+		}
+
+		// Routes requiring the admin scope are SuperAdmin routes
+		role := models.RoleAdmin
+		if slices.Contains(scopes, models.ScopeAdmin) {
+			role = models.RoleSuperAdmin
+		}
+
+		claims := &utils.Claims{
+			Role:     role,
+			ShopID:   apiKey.ShopID,
+			APIKeyID: apiKey.ID,
+		}
+
+		r = authenticated(r, claims)
+		if !allowCaller(w, r, claims.ShopID, claims.UserID, claims.APIKeyID) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/services"
+	"shop-api/utils"
+	"testing"
+)
+
+// useTestAPIKeys backs the API key hook with a fresh store for one test
+func useTestAPIKeys(t *testing.T) services.APIKeyService {
+	t.Helper()
+	previous := apiKeys
+	store := services.NewAPIKeyService()
+	UseAPIKeys(store)
+	t.Cleanup(func() { apiKeys = previous })
+	return store
+}
+
+func createTestKey(t *testing.T, store services.APIKeyService, scopes ...models.Scope) string {
+	t.Helper()
+	_, plaintext, err := store.Create(1, "pos", scopes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+// callWithKey sends key to handler and returns the response and the claims
+// the route saw, nil when it was not reached
+func callWithKey(t *testing.T, route func(http.HandlerFunc) http.HandlerFunc, key string) (*httptest.ResponseRecorder, *utils.Claims) {
+	t.Helper()
+	var seen *utils.Claims
+	handler := route(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = GetClaims(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/products", nil)
+	r.Header.Set(APIKeyHeader, key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w, seen
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	store := useTestAPIKeys(t)
+	reader := createTestKey(t, store, models.ScopeProductsRead)
+	writer := createTestKey(t, store, models.ScopeProductsRead, models.ScopeProductsWrite)
+	admin := createTestKey(t, store, models.ScopeShopsRead, models.ScopeAdmin)
+
+	adminRoute := func(next http.HandlerFunc) http.HandlerFunc {
+		return RequireAdmin(next, models.ScopeProductsRead, models.ScopeProductsWrite)
+	}
+	superAdminRoute := func(next http.HandlerFunc) http.HandlerFunc {
+		return RequireSuperAdmin(next, models.ScopeShopsRead)
+	}
+	usersOnlyRoute := func(next http.HandlerFunc) http.HandlerFunc {
+		return RequireAdmin(next)
+	}
+
+	tests := []struct {
+		name   string
+		route  func(http.HandlerFunc) http.HandlerFunc
+		key    string
+		status int
+		detail string
+		role   models.Role // granted when the route is reached
+	}{
+		{"every scope", adminRoute, writer, http.StatusNoContent, "", models.RoleAdmin},
+		{"missing scope", adminRoute, reader, http.StatusForbidden, "api key lacks the products:write scope", ""},
+		{"route without scopes", usersOnlyRoute, writer, http.StatusForbidden, "api keys cannot call this route", ""},
+		{"super admin route with the admin scope", superAdminRoute, admin, http.StatusNoContent, "", models.RoleSuperAdmin},
+		{"super admin route without the admin scope", superAdminRoute, createTestKey(t, store, models.ScopeShopsRead), http.StatusForbidden, "api key lacks the admin scope", ""},
+		{"unknown key", adminRoute, "sk_unknown", http.StatusUnauthorized, "invalid or revoked api key", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, claims := callWithKey(t, tt.route, tt.key)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusNoContent {
+				if claims == nil || claims.Role != tt.role || claims.ShopID != 1 || claims.APIKeyID == 0 || claims.UserID != 0 {
+					t.Errorf("claims = %+v, want role %s in shop 1 for the key", claims, tt.role)
+				}
+				return
+			}
+			if claims != nil {
+				t.Error("the route was reached")
+			}
+			var body problem.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Detail != tt.detail {
+				t.Errorf("detail = %q, want %q", body.Detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestRevokedAPIKeyIsRejected(t *testing.T) {
+	store := useTestAPIKeys(t)
+	key := createTestKey(t, store, models.ScopeProductsRead)
+	route := func(next http.HandlerFunc) http.HandlerFunc {
+		return AuthMiddleware(next, models.ScopeProductsRead)
+	}
+
+	if w, _ := callWithKey(t, route, key); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d before revocation, want %d", w.Code, http.StatusNoContent)
+	}
+
+	if err := store.Revoke(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	w, claims := callWithKey(t, route, key)
+	if w.Code != http.StatusUnauthorized || claims != nil {
+		t.Fatalf("status = %d after revocation, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if got := w.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, problem.ContentType)
+	}
+}
+
+func TestAPIKeysWithoutAStore(t *testing.T) {
+	previous := apiKeys
+	apiKeys = nil
+	t.Cleanup(func() { apiKeys = previous })
+
+	route := func(next http.HandlerFunc) http.HandlerFunc {
+		return AuthMiddleware(next, models.ScopeProductsRead)
+	}
+	if w, _ := callWithKey(t, route, "sk_anything"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -1,10 +1,12 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
+	"shop-api/logging"
 	"shop-api/models"
+	"shop-api/problem"
 	"shop-api/utils"
+	"slices"
 	"strings"
 )
 
@@ -12,69 +14,170 @@ type contextKey string
 
 const ClaimsContextKey contextKey = "claims"
 
-// AuthMiddleware validates JWT token and adds claims to context
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// MembershipLookup resolves a user's membership in a shop
+type MembershipLookup interface {
+	Get(userID, shopID int) (*models.Membership, error)
+}
+
+// UserLookup resolves a user by ID
+type UserLookup interface {
+	GetByID(id int) (*models.User, error)
+}
+
+// ShopLookup resolves a shop by ID
+type ShopLookup interface {
+	GetByID(id int) (*models.Shop, error)
+}
+
+var (
+	memberships MembershipLookup
+	users       UserLookup
+	shops       ShopLookup
+)
+
+// UseMemberships makes AuthMiddleware check the token's shop against the user's
+// current memberships, so revoked or changed roles take effect immediately
+func UseMemberships(lookup MembershipLookup) {
+	memberships = lookup
+}
+
+// UseShops makes AuthMiddleware enforce shop policies such as mandatory
+// two-factor authentication for SuperAdmins
+func UseShops(lookup ShopLookup) {
+	shops = lookup
+}
+
+// UseUsers makes AuthMiddleware reject tokens of deactivated accounts
+func UseUsers(lookup UserLookup) {
+	users = lookup
+}
+
+// AuthMiddleware validates JWT token (or X-API-Key) and adds claims to context.
+// API keys are only accepted when they hold every scope the route is
+// registered with; without scopes, the route is for users only.
+func AuthMiddleware(next http.HandlerFunc, scopes ...models.Scope) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			apiKeyMiddleware(key, scopes, next)(w, r)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, `{"error": "authorization header required"}`, http.StatusUnauthorized)
+			deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "authorization header required")
 			return
 		}
 
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, `{"error": "invalid authorization header format"}`, http.StatusUnauthorized)
+			deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "invalid authorization header format")
 			return
 		}
 
 		token := parts[1]
 		claims, err := utils.ValidateToken(token)
 		if err != nil {
-			http.Error(w, `{"error": "invalid or expired token"}`, http.StatusUnauthorized)
+			deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "invalid or expired token")
 			return
 		}
 
-		// Add claims to context
-		ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
-		next(w, r.WithContext(ctx))
+		if users != nil {
+			user, err := users.GetByID(claims.UserID)
+			if err != nil || !user.Active {
+				deny(w, r, http.StatusUnauthorized, problem.CodeAccountDisabled, "account is deactivated")
+				return
+			}
+			if user.SessionVersion != claims.SessionVersion {
+				deny(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "session revoked by a password change, sign in again")
+				return
+			}
+		}
+
+		// The active membership is the source of truth for shop and role
+		if memberships != nil {
+			membership, err := memberships.Get(claims.UserID, claims.ShopID)
+			if err != nil || !membership.Active {
+				deny(w, r, http.StatusForbidden, problem.CodeForbiddenTenant, "no active membership for this shop")
+				return
+			}
+			claims.Role = membership.Role
+		}
+
+		// Without the second factor a shop requires, a SuperAdmin only gets Admin rights
+		if shops != nil && claims.Role == models.RoleSuperAdmin && !claims.TwoFactor {
+			if shop, err := shops.GetByID(claims.ShopID); err == nil && shop.RequireTwoFactor {
+				claims.Role = models.RoleAdmin
+				claims.TwoFactorRequired = true
+			}
+		}
+
+		r = authenticated(r, claims)
+		if !allowCaller(w, r, claims.ShopID, claims.UserID, claims.APIKeyID) {
+			return
+		}
+		next(w, r)
 	}
 }
 
-// RequireSuperAdmin middleware ensures the user is a SuperAdmin
-func RequireSuperAdmin(next http.HandlerFunc) http.HandlerFunc {
+// RequireSuperAdmin middleware ensures the user is a SuperAdmin. API keys
+// need the admin scope on top of the route's scopes.
+func RequireSuperAdmin(next http.HandlerFunc, scopes ...models.Scope) http.HandlerFunc {
+	if len(scopes) > 0 {
+		scopes = append(slices.Clip(scopes), models.ScopeAdmin)
+	}
+
 	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(ClaimsContextKey).(*utils.Claims)
 		if !ok {
-			http.Error(w, `{"error": "invalid context"}`, http.StatusInternalServerError)
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "invalid context")
+			return
+		}
+
+		// Keys only get here with the admin scope
+		if claims.APIKeyID != 0 {
+			next(w, r)
+			return
+		}
+
+		if claims.TwoFactorRequired {
+			deny(w, r, http.StatusForbidden, problem.CodeTwoFactorRequired, "two-factor authentication required for super admin access")
 			return
 		}
 
 		if claims.Role != models.RoleSuperAdmin {
-			http.Error(w, `{"error": "super admin access required"}`, http.StatusForbidden)
+			deny(w, r, http.StatusForbidden, problem.CodeForbidden, "super admin access required")
 			return
 		}
 
 		next(w, r)
-	})
+	}, scopes...)
 }
 
-// RequireAdmin middleware ensures the user is at least an Admin
-func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+// RequireAdmin middleware ensures the user is at least an Admin. API keys,
+// which act as Admins, need the route's scopes.
+func RequireAdmin(next http.HandlerFunc, scopes ...models.Scope) http.HandlerFunc {
 	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(ClaimsContextKey).(*utils.Claims)
 		if !ok {
-			http.Error(w, `{"error": "invalid context"}`, http.StatusInternalServerError)
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "invalid context")
 			return
 		}
 
 		if claims.Role != models.RoleSuperAdmin && claims.Role != models.RoleAdmin {
-			http.Error(w, `{"error": "admin access required"}`, http.StatusForbidden)
+			deny(w, r, http.StatusForbidden, problem.CodeForbidden, "admin access required")
 			return
 		}
 
 		next(w, r)
-	})
+	}, scopes...)
+}
+
+// deny rejects a request that failed authentication or authorization, logging why
+func deny(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	logging.FromContext(r.Context()).Warn("access denied",
+		"method", r.Method, "path", r.URL.Path, "status", status, "code", code, "reason", detail)
+	problem.Error(w, r, status, code, detail)
 }
 
 // GetClaims extracts claims from context
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var trustedProxies []netip.Prefix
+
+// UseTrustedProxies makes ClientIP and IsHTTPS believe the X-Forwarded-For and
+// X-Forwarded-Proto headers set by these proxies, and only by them
+func UseTrustedProxies(proxies []netip.Prefix) {
+	trustedProxies = proxies
+}
+
+// ParseTrustedProxies reads IP addresses and CIDR ranges, e.g. "10.0.0.0/8" or "172.18.0.2"
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, value := range values {
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP address or a CIDR range", value)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// ClientIP returns the IP address of the client that sent the request. Behind
+// trusted proxies, it is the last address in X-Forwarded-For that isn't one
+// of them: the entries before it could have been forged by the client.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// IsHTTPS reports whether the client reached the server over TLS, directly or
+// through a trusted proxy terminating it
+func IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return isTrustedProxy(host) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
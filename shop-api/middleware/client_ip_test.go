@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useTestProxies(t *testing.T, values ...string) {
+	t.Helper()
+	proxies, err := ParseTrustedProxies(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := trustedProxies
+	UseTrustedProxies(proxies)
+	t.Cleanup(func() { trustedProxies = previous })
+}
+
+func TestClientIP(t *testing.T) {
+	useTestProxies(t, "10.0.0.0/8", "172.18.0.2")
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string // one X-Forwarded-For header each
+		want         string
+	}{
+		{"direct client", "203.0.113.7:51000", nil, "203.0.113.7"},
+		{"untrusted peer forging the header", "203.0.113.7:51000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer naming a trusted proxy", "203.0.113.7:51000", []string{"10.0.0.1"}, "203.0.113.7"},
+		{"trusted proxy", "172.18.0.2:40000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client prepending a forged hop", "172.18.0.2:40000", []string{"192.0.2.66, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "172.18.0.2:40000", []string{"198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"hops over several headers", "172.18.0.2:40000", []string{"192.0.2.66", "198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"garbage stops the walk", "172.18.0.2:40000", []string{"198.51.100.1, not-an-ip, 10.1.2.3"}, "10.1.2.3"},
+		{"trusted proxy without the header", "172.18.0.2:40000", nil, "172.18.0.2"},
+		{"IPv4-mapped proxy address", "[::ffff:172.18.0.2]:40000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"IPv6 client", "[2001:db8::1]:40000", []string{"198.51.100.1"}, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutTrustedProxies(t *testing.T) {
+	useTestProxies(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+	r.RemoteAddr = "10.0.0.1:40000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP = %s, want the peer 10.0.0.1", got)
+	}
+}
+
+func TestIsHTTPS(t *testing.T) {
+	useTestProxies(t, "10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		want       bool
+	}{
+		{"direct TLS", "203.0.113.7:51000", "", true, true},
+		{"plain HTTP", "203.0.113.7:51000", "", false, false},
+		{"trusted proxy terminating TLS", "10.0.0.5:40000", "https", false, true},
+		{"untrusted peer claiming HTTPS", "203.0.113.7:51000", "https", false, false},
+		{"trusted proxy over HTTP", "10.0.0.5:40000", "http", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := IsHTTPS(r); got != tt.want {
+				t.Errorf("IsHTTPS = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.1.2.3/8", "172.18.0.2", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "172.18.0.2/32", "2001:db8::/32"}
+	for i, proxy := range proxies {
+		if proxy.String() != want[i] {
+			t.Errorf("proxy %d = %s, want %s", i, proxy, want[i])
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Error("host name accepted")
+	}
+}
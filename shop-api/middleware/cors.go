@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"shop-api/problem"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsAllowedHeaders are the request headers browsers may send cross-origin
+var corsAllowedHeaders = []string{
+	"Content-Type", "Authorization", APIKeyHeader, "If-Match", "If-None-Match", "Idempotency-Key", RequestIDHeader,
+}
+
+// corsExposedHeaders are the response headers cross-origin scripts may read
+var corsExposedHeaders = []string{
+	"ETag", "Location", "Idempotent-Replayed", RequestIDHeader,
+	"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// CORSPolicy decides which origins may call a group of routes from a browser
+type CORSPolicy struct {
+	Origins          []string // exact origins, e.g. "https://shop.example.com"; "*" allows any
+	Methods          []string
+	AllowCredentials bool // only honoured for listed origins, never with "*"
+	MaxAge           time.Duration
+}
+
+// CORSRoute applies a policy to the paths starting with Prefix
+type CORSRoute struct {
+	Prefix string
+	Policy CORSPolicy
+}
+
+// CORS answers preflight requests and sets the CORS headers of responses,
+// using the policy of the first route whose prefix matches the path, or
+// fallback. Requests from origins a policy doesn't allow get no CORS headers,
+// so browsers keep their responses from the calling page.
+func CORS(fallback CORSPolicy, routes []CORSRoute, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := fallback
+		for _, route := range routes {
+			if strings.HasPrefix(r.URL.Path, route.Prefix) {
+				policy = route.Policy
+				break
+			}
+		}
+
+		// Responses differ by origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wildcard := policy.allows(origin)
+		if !allowed {
+			if preflight {
+				problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "origin "+origin+" is not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		if wildcard {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if policy.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		if policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allows reports whether origin may call the routes, and whether it is
+// through the "*" wildcard rather than by name
+func (p CORSPolicy) allows(origin string) (allowed, wildcard bool) {
+	if slices.Contains(p.Origins, origin) {
+		return true, false
+	}
+	return slices.Contains(p.Origins, "*"), true
+}
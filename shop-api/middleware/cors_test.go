@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCORS mirrors main.go: the public catalog accepts any origin, the
+// rest of the API one frontend
+func newTestCORS() (http.Handler, *int) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	publicCatalog := CORSPolicy{Origins: []string{"*"}, Methods: []string{"GET", "HEAD"}, MaxAge: time.Hour}
+	frontends := CORSPolicy{
+		Origins:          []string{"https://admin.example.com"},
+		Methods:          []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowCredentials: true,
+		MaxAge:           2 * time.Hour,
+	}
+	return CORS(frontends, []CORSRoute{{Prefix: "/api/v1/public/", Policy: publicCatalog}}, next), &calls
+}
+
+func preflight(handler http.Handler, path, origin string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodOptions, path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", "PATCH")
+	r.Header.Set("Access-Control-Request-Headers", "authorization, if-match")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		status      int
+		allowOrigin string
+		credentials string
+		methods     string
+		maxAge      string
+	}{
+		{"listed origin", "/api/v1/products/1", "https://admin.example.com", http.StatusNoContent, "https://admin.example.com", "true", "GET, POST, PUT, PATCH, DELETE", "7200"},
+		{"unlisted origin", "/api/v1/products/1", "https://evil.example.com", http.StatusForbidden, "", "", "", ""},
+		{"origins match exactly", "/api/v1/products/1", "https://admin.example.com.evil.example", http.StatusForbidden, "", "", "", ""},
+		{"public catalog from anywhere", "/api/v1/public/1/products", "https://blog.example.org", http.StatusNoContent, "*", "", "GET, HEAD", "3600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, calls := newTestCORS()
+			w := preflight(handler, tt.path, tt.origin)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if *calls != 0 {
+				t.Error("the preflight reached the route")
+			}
+			header := w.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.credentials)
+			}
+			if got := header.Get("Access-Control-Allow-Methods"); got != tt.methods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.methods)
+			}
+			if got := header.Get("Access-Control-Max-Age"); got != tt.maxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.maxAge)
+			}
+			if !slices.Contains(header.Values("Vary"), "Origin") {
+				t.Errorf("Vary = %q, want Origin", header.Values("Vary"))
+			}
+			if tt.status != http.StatusNoContent {
+				return
+			}
+
+			allowedHeaders := strings.ToLower(header.Get("Access-Control-Allow-Headers"))
+			for _, name := range []string{"authorization", "if-match", "idempotency-key", "x-api-key"} {
+				if !strings.Contains(allowedHeaders, name) {
+					t.Errorf("Allow-Headers %q lacks %s", allowedHeaders, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSActualRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		origin      string
+		allowOrigin string
+	}{
+		{"listed origin", "https://admin.example.com", "https://admin.example.com"},
+		// The route still answers; the browser hides the response from the page
+		{"unlisted origin", "https://evil.example.com", ""},
+		{"same-origin or non-browser", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, calls := newTestCORS()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK || *calls != 1 {
+				t.Fatalf("status = %d after %d calls, want the route's 200", w.Code, *calls)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			exposed := w.Header().Get("Access-Control-Expose-Headers")
+			if (tt.allowOrigin != "") != strings.Contains(exposed, "ETag") {
+				t.Errorf("Expose-Headers = %q", exposed)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"shop-api/config"
+	"shop-api/problem"
+	"shop-api/services"
+	"strconv"
+	"strings"
+)
+
+// IdempotencyKeyHeader lets clients retry a create without repeating it
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyStore remembers the first response sent for each key
+type IdempotencyStore interface {
+	Begin(shopID int, key, fingerprint string) (*services.StoredResponse, error)
+	Complete(shopID int, key string, response services.StoredResponse)
+	Release(shopID int, key string)
+}
+
+var idempotency IdempotencyStore
+
+// UseIdempotency enables the Idempotency-Key header on routes wrapped with Idempotent
+func UseIdempotency(store IdempotencyStore) {
+	idempotency = store
+}
+
+// replayedHeaders are the response headers stored and replayed with the body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Idempotent makes a creating route honour the Idempotency-Key header: the
+// first response for a key in the active shop is stored and replayed on
+// retries, and reusing the key for a different request is a conflict.
+// Must run inside AuthMiddleware.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		claims, ok := GetClaims(r)
+		if key == "" || idempotency == nil || !ok {
+			next(w, r)
+			return
+		}
+		if len(key) > config.IdempotencyKeyMaxLength {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidInput,
+				IdempotencyKeyHeader+" must be at most "+strconv.Itoa(config.IdempotencyKeyMaxLength)+" characters")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, config.MaxRequestBodyBytes))
+		if err != nil {
+			problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, "request body is too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := idempotency.Begin(claims.ShopID, key, fingerprint(r, body))
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			problem.Error(w, r, http.StatusConflict, problem.CodeIdempotencyKeyReused, err.Error())
+			return
+		case errors.Is(err, services.ErrRequestInProgress):
+			w.Header().Set("Retry-After", "1")
+			problem.Error(w, r, http.StatusConflict, problem.CodeRequestInProgress, err.Error())
+			return
+		case stored != nil:
+			replay(w, stored)
+			return
+		}
+
+		// Server errors are not stored, so the client may retry with the same key
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if !completed {
+				idempotency.Release(claims.ShopID, key)
+			}
+		}()
+
+		next(recorder, r)
+
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		response := services.StoredResponse{
+			Status:  recorder.status,
+			Headers: make(map[string]string),
+			Body:    recorder.body.Bytes(),
+		}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+		idempotency.Complete(claims.ShopID, key, response)
+		completed = true
+	}
+}
+
+// fingerprint identifies a request by method, path and body. The version
+// prefix is ignored and JSON bodies are compacted, so a retry through the
+// deprecated alias or with different whitespace still matches.
+func fingerprint(r *http.Request, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+
+	sum := sha256.New()
+	sum.Write([]byte(r.Method + " " + strings.TrimPrefix(r.URL.Path, config.APIPrefix) + "\n"))
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, stored *services.StoredResponse) {
+	for name, value := range stored.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
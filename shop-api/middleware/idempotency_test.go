@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shop-api/config"
+	"shop-api/problem"
+	"shop-api/services"
+	"shop-api/utils"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countingCreate answers 201 with a new ID on each call, or status when it is set
+type countingCreate struct {
+	calls  int
+	status int
+}
+
+func (c *countingCreate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.calls++
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/transactions/"+strconv.Itoa(c.calls))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"id":` + strconv.Itoa(c.calls) + `}`))
+}
+
+func useTestIdempotency(t *testing.T) {
+	t.Helper()
+	previous := idempotency
+	UseIdempotency(services.NewIdempotencyService())
+	t.Cleanup(func() { idempotency = previous })
+}
+
+// postIdempotent sends body to route as a caller of shopID
+func postIdempotent(route http.HandlerFunc, shopID int, path, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	claims := &utils.Claims{UserID: 2, ShopID: shopID}
+	r = r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, claims))
+
+	w := httptest.NewRecorder()
+	route(w, r)
+	return w
+}
+
+func TestIdempotentReplaysTheFirstResponse(t *testing.T) {
+	useTestIdempotency(t)
+	create := &countingCreate{}
+	route := Idempotent(create.ServeHTTP)
+
+	first := postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{"product_id": 1, "quantity": 2}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", first.Code)
+	}
+
+	// The same request, through the deprecated alias and with other whitespace
+	for _, path := range []string{"/api/v1/transactions", "/transactions"} {
+		retry := postIdempotent(route, 1, path, "key-1", `{"product_id":1,"quantity":2}`)
+		if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+			t.Errorf("%s: replay = %d %s, want %d %s", path, retry.Code, retry.Body.String(), first.Code, first.Body.String())
+		}
+		if got := retry.Header().Get("Location"); got != first.Header().Get("Location") {
+			t.Errorf("%s: Location = %q, want %q", path, got, first.Header().Get("Location"))
+		}
+		if retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("%s: replay not marked with Idempotent-Replayed", path)
+		}
+	}
+	if create.calls != 1 {
+		t.Errorf("handler ran %d times, want once", create.calls)
+	}
+
+	// Keys are per shop, and requests without a key are never replayed
+	if w := postIdempotent(route, 2, "/api/v1/transactions", "key-1", `{"product_id": 3, "quantity": 1}`); w.Code != http.StatusCreated {
+		t.Errorf("another shop's key: status = %d, want 201", w.Code)
+	}
+	postIdempotent(route, 1, "/api/v1/transactions", "", `{"product_id": 1, "quantity": 2}`)
+	postIdempotent(route, 1, "/api/v1/transactions", "", `{"product_id": 1, "quantity": 2}`)
+	if create.calls != 4 {
+		t.Errorf("handler ran %d times, want 4", create.calls)
+	}
+}
+
+func TestIdempotencyKeyReusedForAnotherRequest(t *testing.T) {
+	useTestIdempotency(t)
+	create := &countingCreate{}
+	route := Idempotent(create.ServeHTTP)
+
+	postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{"product_id": 1, "quantity": 2}`)
+
+	tests := []struct {
+		name, path, body string
+	}{
+		{"other body", "/api/v1/transactions", `{"product_id": 1, "quantity": 3}`},
+		{"other route", "/api/v1/products", `{"product_id": 1, "quantity": 2}`},
+	}
+	for _, tt := range tests {
+		w := postIdempotent(route, 1, tt.path, "key-1", tt.body)
+		if w.Code != http.StatusConflict {
+			t.Fatalf("%s: status = %d, want 409", tt.name, w.Code)
+		}
+		var p problem.Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != problem.CodeIdempotencyKeyReused {
+			t.Errorf("%s: code = %s, want %s", tt.name, p.Code, problem.CodeIdempotencyKeyReused)
+		}
+	}
+	if create.calls != 1 {
+		t.Errorf("handler ran %d times, want once", create.calls)
+	}
+}
+
+func TestIdempotentDoesNotStoreServerErrors(t *testing.T) {
+	useTestIdempotency(t)
+	create := &countingCreate{status: http.StatusInternalServerError}
+	route := Idempotent(create.ServeHTTP)
+
+	postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{}`)
+	create.status = 0
+	if w := postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{}`); w.Code != http.StatusCreated {
+		t.Fatalf("retry after a server error: status = %d, want 201", w.Code)
+	}
+	if create.calls != 2 {
+		t.Errorf("handler ran %d times, want twice", create.calls)
+	}
+}
+
+func TestIdempotentRejectsConcurrentRetries(t *testing.T) {
+	useTestIdempotency(t)
+	var retry *httptest.ResponseRecorder
+	var route http.HandlerFunc
+	route = Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// The client retries while the first request is still running
+		if retry == nil {
+			retry = postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{}`)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	postIdempotent(route, 1, "/api/v1/transactions", "key-1", `{}`)
+	if retry.Code != http.StatusConflict || retry.Header().Get("Retry-After") == "" {
+		t.Fatalf("concurrent retry: status = %d, Retry-After = %q, want 409 with Retry-After", retry.Code, retry.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(retry.Body.String(), problem.CodeRequestInProgress) {
+		t.Errorf("body %s lacks the %s code", retry.Body.String(), problem.CodeRequestInProgress)
+	}
+}
+
+func TestIdempotencyKeyLength(t *testing.T) {
+	useTestIdempotency(t)
+	create := &countingCreate{}
+
+	w := postIdempotent(Idempotent(create.ServeHTTP), 1, "/api/v1/transactions", strings.Repeat("k", config.IdempotencyKeyMaxLength+1), `{}`)
+	if w.Code != http.StatusBadRequest || create.calls != 0 {
+		t.Errorf("status = %d, %d calls, want 400 without calling the handler", w.Code, create.calls)
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"shop-api/metrics"
+	"strings"
+	"time"
+)
+
+// Metrics counts requests and measures their latency per route pattern, so
+// path parameters don't create a series per product or transaction
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		recorder := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		_, route, _ := strings.Cut(r.Pattern, " ")
+		metrics.ObserveRequest(r.Method, route, recorder.status, time.Since(start))
+	})
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"shop-api/config"
+	"shop-api/logging"
+	"shop-api/metrics"
+	"shop-api/problem"
+	"shop-api/services"
+	"strconv"
+	"time"
+)
+
+// RateLimitStore keeps the token buckets. The in-memory store limits each
+// instance on its own; a shared one (e.g. Redis) limits them together.
+type RateLimitStore interface {
+	Take(key string, limit config.RateLimit) (services.RateLimitDecision, error)
+}
+
+var rateLimits RateLimitStore
+
+// UseRateLimits enables RateLimited on public routes, and the private limit
+// AuthMiddleware applies to every authenticated caller
+func UseRateLimits(store RateLimitStore) {
+	rateLimits = store
+}
+
+// RateLimited throttles a public route per client IP, in the buckets of limit's group
+func RateLimited(limit config.RateLimit, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allow(w, r, limit, "ip:"+ClientIP(r)) {
+			next(w, r)
+		}
+	}
+}
+
+// allowCaller applies the private limit to an authenticated caller, per shop
+// and per user or API key
+func allowCaller(w http.ResponseWriter, r *http.Request, shopID, userID, apiKeyID int) bool {
+	key := "shop:" + strconv.Itoa(shopID)
+	if apiKeyID != 0 {
+		key += ":key:" + strconv.Itoa(apiKeyID)
+	} else {
+		key += ":user:" + strconv.Itoa(userID)
+	}
+	return allow(w, r, config.RateLimitPrivate, key)
+}
+
+// allow takes a token for key and sets the RateLimit headers. When the bucket
+// is empty it answers 429 with Retry-After and reports false.
+func allow(w http.ResponseWriter, r *http.Request, limit config.RateLimit, key string) bool {
+	if rateLimits == nil || limit.Requests <= 0 {
+		return true
+	}
+
+	decision, err := rateLimits.Take(key, limit)
+	if err != nil {
+		// An unreachable shared store shouldn't take the API down with it
+		logging.FromContext(r.Context()).Warn("rate limit store unavailable", "group", limit.Group, "error", err)
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", seconds(decision.Reset))
+	if decision.Allowed {
+		return true
+	}
+
+	header.Set("Retry-After", seconds(decision.RetryAfter))
+	metrics.RecordRateLimited(limit.Group)
+	logging.FromContext(r.Context()).Warn("rate limited", "group", limit.Group, "client_ip", ClientIP(r))
+	problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "rate limit exceeded, retry later")
+	return false
+}
+
+// seconds rounds a delay up to whole seconds, as the headers expect
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"shop-api/logging"
+	"shop-api/tracing"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span per request, continuing the caller's trace
+// when it sends a W3C traceparent header, and tags the request's logs with
+// the trace ID. It must wrap the router directly: the span is named after
+// the matched route once the router has run.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.StartServer(r.Context(), propagation.HeaderCarrier(r.Header), r.Method,
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(ClientIP(r)),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		)
+		defer span.End()
+
+		if spanContext := span.SpanContext(); spanContext.IsValid() {
+			traceID := spanContext.TraceID().String()
+			if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+				entry.traceID = traceID
+			}
+			ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("trace_id", traceID))
+		}
+
+		traced := r.WithContext(ctx)
+		recorder := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, traced)
+
+		// The mux records the pattern on the request it was given; hand it
+		// back to the access log and metrics, which hold the original
+		r.Pattern = traced.Pattern
+		if traced.Pattern != "" {
+			span.SetName(traced.Pattern)
+			_, route, _ := strings.Cut(traced.Pattern, " ")
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// traceCaller adds the authenticated caller to the request's span
+func traceCaller(ctx context.Context, shopID, userID, apiKeyID int) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("shop.id", shopID))
+	if apiKeyID != 0 {
+		span.SetAttributes(attribute.Int("api_key.id", apiKeyID))
+	} else {
+		span.SetAttributes(attribute.Int("user.id", userID))
+	}
+}
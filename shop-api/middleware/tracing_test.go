@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The tracing package binds its tracer to the first global provider, so the
+// exporter is installed once for the whole test binary
+var testSpans = sync.OnceValue(func() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+})
+
+// traceRequest serves r through Tracing and a mux, and returns the one span it ended
+func traceRequest(t *testing.T, r *http.Request, status int) (tracetest.SpanStub, trace.SpanContext) {
+	t.Helper()
+	exporter := testSpans()
+	exporter.Reset()
+
+	var seen trace.SpanContext
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seen = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(status)
+	})
+	Tracing(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans exported, want 1", len(spans))
+	}
+	return spans[0], seen
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestTracingContinuesTheCallersTrace(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/products/42", nil)
+	r.RemoteAddr = "203.0.113.7:51000"
+	r.Header.Set("User-Agent", "pos-terminal/2.1")
+	r.Header.Set("traceparent", traceparent)
+	span, seen := traceRequest(t, r, http.StatusOK)
+
+	if span.Name != "GET /api/v1/products/{id}" {
+		t.Errorf("name = %q, want the matched route", span.Name)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("kind = %v, want server", span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s, want the traceparent's", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" || !span.Parent.IsRemote() {
+		t.Errorf("parent = %s (remote %v), want the caller's span 00f067aa0ba902b7", got, span.Parent.IsRemote())
+	}
+	// Handlers run inside the span, so their own spans are its children
+	if seen.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("the handler saw span %s, want %s", seen.SpanID(), span.SpanContext.SpanID())
+	}
+
+	values := attributes(span)
+	want := map[attribute.Key]attribute.Value{
+		"http.request.method":       attribute.StringValue("GET"),
+		"url.path":                  attribute.StringValue("/api/v1/products/42"),
+		"http.route":                attribute.StringValue("/api/v1/products/{id}"),
+		"http.response.status_code": attribute.IntValue(http.StatusOK),
+		"client.address":            attribute.StringValue("203.0.113.7"),
+		"user_agent.original":       attribute.StringValue("pos-terminal/2.1"),
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %v, want %v", key, values[key].Emit(), value.Emit())
+		}
+	}
+	if span.Status.Code != codes.Unset {
+		t.Errorf("status = %v, want unset for a 200", span.Status.Code)
+	}
+}
+
+func TestTracingStartsATraceWithoutTraceparent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/products/42", nil)
+	span, _ := traceRequest(t, r, http.StatusInternalServerError)
+
+	if !span.SpanContext.IsValid() || span.Parent.IsValid() {
+		t.Errorf("span %v with parent %v, want a new root span", span.SpanContext, span.Parent)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("status = %v, want an error for a 500", span.Status.Code)
+	}
+	if got := attributes(span)["http.response.status_code"]; got != attribute.IntValue(http.StatusInternalServerError) {
+		t.Errorf("status code attribute = %v, want 500", got.Emit())
+	}
+}
+
+func TestTracingNamesUnmatchedRequestsAfterTheMethod(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	span, _ := traceRequest(t, r, http.StatusOK)
+
+	// An unmatched path is not a route, and would make span names unbounded
+	if span.Name != http.MethodGet {
+		t.Errorf("name = %q, want %q", span.Name, http.MethodGet)
+	}
+	if _, ok := attributes(span)["http.route"]; ok {
+		t.Error("http.route set for an unmatched request")
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+type Scope string
+
+const (
+	ScopeProductsRead      Scope = "products:read"
+	ScopeProductsWrite     Scope = "products:write"
+	ScopeTransactionsRead  Scope = "transactions:read"
+	ScopeTransactionsWrite Scope = "transactions:write"
+	ScopeReportsRead       Scope = "reports:read"
+	ScopeShopsRead         Scope = "shops:read"
+
+	// ScopeAdmin gives SuperAdmin rights on the SuperAdmin routes open to keys,
+	// together with the route's own scope. No other scope implies it.
+	ScopeAdmin Scope = "admin"
+)
+
+// ValidScopes lists the scopes an API key can be granted
+var ValidScopes = []Scope{
+	ScopeProductsRead,
+	ScopeProductsWrite,
+	ScopeTransactionsRead,
+	ScopeTransactionsWrite,
+	ScopeReportsRead,
+	ScopeShopsRead,
+	ScopeAdmin,
+}
+
+// APIKey gives a machine (POS terminal, sync script...) access to one shop
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to tell keys apart
+	KeyHash    string     `json:"-"`      // Only the SHA-256 of the key is stored
+	Scopes     []Scope    `json:"scopes"`
+	ShopID     int        `json:"shop_id"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// AuditChange is the value of a field before and after an action
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditEntry records one administrative action. Entries are never changed or removed.
+type AuditEntry struct {
+	ID        int                    `json:"id"`
+	ShopID    int                    `json:"shop_id"`
+	ActorID   int                    `json:"actor_id,omitempty"`   // user who acted
+	APIKeyID  int                    `json:"api_key_id,omitempty"` // set instead when an API key acted
+	Action    string                 `json:"action"`               // e.g. product.update
+	Entity    string                 `json:"entity"`               // e.g. product
+	EntityID  int                    `json:"entity_id"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	IP        string                 `json:"ip"`
+	CreatedAt time.Time              `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Membership links a user to a shop with a shop-specific role
+type Membership struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ShopID    int       `json:"shop_id"`
+	Role      Role      `json:"role"`
+	Active    bool      `json:"active"` // false while the shop suspends the user's access
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation offers a user a role in a shop. It only becomes a membership
+// once the invited user accepts it.
+type Invitation struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ShopID    int       `json:"shop_id"`
+	Role      Role      `json:"role"`
+	InvitedBy int       `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
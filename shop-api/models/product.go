@@ -12,6 +12,7 @@ type Product struct {
 	Stock         int       `json:"stock"`
 	ImageURL      string    `json:"image_url"`
 	ShopID        int       `json:"shop_id"`
+	Version       int       `json:"version"` // incremented on every change, sent as the ETag
 	CreatedAt     time.Time `json:"created_at"`
 }
 
@@ -37,6 +38,7 @@ type AdminProductResponse struct {
 	Stock        int       `json:"stock"`
 	ImageURL     string    `json:"image_url"`
 	ShopID       int       `json:"shop_id"`
+	Version      int       `json:"version"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -63,6 +65,7 @@ func (p *Product) ToAdminResponse() AdminProductResponse {
 		Stock:        p.Stock,
 		ImageURL:     p.ImageURL,
 		ShopID:       p.ShopID,
+		Version:      p.Version,
 		CreatedAt:    p.CreatedAt,
 	}
 }
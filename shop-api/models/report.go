@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// DailySales sums the sales a shop recorded on one day
+type DailySales struct {
+	Date     string  `json:"date"` // YYYY-MM-DD
+	Sales    int     `json:"sales"`
+	Quantity int     `json:"quantity"`
+	Amount   float64 `json:"amount"`
+}
+
+// LowStockAlert is raised when a product's stock falls below the low-stock
+// threshold, and cleared when it is restocked
+type LowStockAlert struct {
+	ProductID int       `json:"product_id"`
+	Stock     int       `json:"stock"`
+	RaisedAt  time.Time `json:"raised_at"`
+}
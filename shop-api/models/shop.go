@@ -3,9 +3,11 @@ package models
 import "time"
 
 type Shop struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Active         bool      `json:"active"`
-	WhatsAppNumber string    `json:"whatsapp_number"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID               int       `json:"id"`
+	Name             string    `json:"name"`
+	Active           bool      `json:"active"`
+	WhatsAppNumber   string    `json:"whatsapp_number"`
+	RequireTwoFactor bool      `json:"require_two_factor"` // SuperAdmins must sign in with TOTP
+	Version          int       `json:"version"`            // incremented on every change, sent as the ETag
+	CreatedAt        time.Time `json:"created_at"`
 }
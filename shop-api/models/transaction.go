@@ -18,4 +18,19 @@ type Transaction struct {
 	Amount    float64         `json:"amount"`
 	ShopID    int             `json:"shop_id"`
 	CreatedAt time.Time       `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"` // hash of the shop's previous transaction
+	Hash      string          `json:"hash"`      // SHA-256 of the fields above, chaining the shop's transactions
+}
+
+// ChainCheckpoint is a signed statement of the head of a shop's transaction
+// chain at a point in time. Transactions up to it can no longer be rewritten,
+// even with every hash recomputed, without the signature giving it away.
+type ChainCheckpoint struct {
+	ID            int       `json:"id"`
+	ShopID        int       `json:"shop_id"`
+	TransactionID int       `json:"transaction_id"` // last transaction covered, 0 for an empty chain
+	Count         int       `json:"count"`          // transactions covered
+	Hash          string    `json:"hash"`           // hash of the last transaction covered
+	CreatedAt     time.Time `json:"created_at"`
+	Signature     string    `json:"signature"` // base64 Ed25519 signature
 }
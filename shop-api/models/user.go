@@ -16,7 +16,18 @@ type User struct {
 	Password  string    `json:"-"` // Never expose password in JSON
 	Role      Role      `json:"role"`
 	ShopID    int       `json:"shop_id"`
+	Active    bool      `json:"active"`
+	Version   int       `json:"version"` // incremented on every change, sent as the ETag
 	CreatedAt time.Time `json:"created_at"`
+
+	// Incremented on password changes and resets to revoke the tokens issued before
+	SessionVersion int `json:"-"`
+
+	// Two-factor authentication (TOTP)
+	TOTPSecret    string   `json:"-"`
+	TOTPEnabled   bool     `json:"-"` // False while enrollment awaits its first code
+	TOTPLastStep  int64    `json:"-"` // Last accepted time step, to reject replayed codes
+	RecoveryCodes []string `json:"-"` // SHA-256 hashes of unused recovery codes
 }
 
 // UserResponse is used for API responses (without sensitive data)
@@ -26,7 +37,11 @@ type UserResponse struct {
 	Email     string    `json:"email"`
 	Role      Role      `json:"role"`
 	ShopID    int       `json:"shop_id"`
+	Active    bool      `json:"active"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
 }
 
 func (u *User) ToResponse() UserResponse {
@@ -36,6 +51,10 @@ func (u *User) ToResponse() UserResponse {
 		Email:     u.Email,
 		Role:      u.Role,
 		ShopID:    u.ShopID,
+		Active:    u.Active,
+		Version:   u.Version,
 		CreatedAt: u.CreatedAt,
+
+		TwoFactorEnabled: u.TOTPEnabled,
 	}
 }
@@ -0,0 +1,35 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// SpecHandler serves the document as JSON, encoded once
+func SpecHandler(doc *Document) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(body)
+	}
+}
+
+// DocsHandler serves an interactive page rendering the document found at
+// openapi.json next to it. It is self-contained, so the docs work without
+// access to a CDN.
+func DocsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'; frame-ancestors 'none'")
+		w.Write(docsPage)
+	}
+}
@@ -0,0 +1,329 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"shop-api/models"
+	"shop-api/problem"
+	"shop-api/router"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// mergePatchType is the media type of JSON Merge Patch (RFC 7396) bodies
+const mergePatchType = "application/merge-patch+json"
+
+// Access is who may call an operation
+type Access int
+
+const (
+	Public Access = iota
+	Authenticated
+	Admin
+	SuperAdmin
+)
+
+// Operation describes one API route. Request and Response are zero values
+// of the Go types sent and returned, from which the JSON schemas are derived.
+type Operation struct {
+	Method      string
+	Path        string // as registered with the router, e.g. /products/{id}
+	Tag         string
+	Summary     string
+	Access      Access
+	Scopes      []models.Scope // scopes an API key needs; without any, keys are refused
+	Query       any            // struct whose fields tagged query are the query parameters, nil when there are none
+	Request     any            // JSON body, nil when the operation takes none
+	RequestType string         // media type of Request, application/json by default; a merge patch makes every field optional and nullable
+	Response    any            // JSON body of the success response, nil when there is none
+	Status      int            // success status, 200 by default
+	Conditional bool           // sends an ETag and honours If-Match
+	Idempotent  bool           // replays its first response for a repeated Idempotency-Key
+}
+
+// Document is an OpenAPI 3.1 document
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []Server                        `json:"servers"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components components                      `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Security    []map[string][]string `json:"security"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+	Schema      schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema schema `json:"schema"`
+}
+
+type response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string `json:"description"`
+	Schema      schema `json:"schema"`
+}
+
+type components struct {
+	Schemas         map[string]schema         `json:"schemas"`
+	Responses       map[string]response       `json:"responses"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Build generates the document describing operations, served from serverURL
+func Build(info Info, serverURL string, operations []Operation) *Document {
+	g := &generator{schemas: map[string]schema{}}
+	problemRef := g.schema(reflect.TypeOf(problem.Problem{}))
+
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info:    info,
+		Servers: []Server{{URL: serverURL}},
+		Paths:   map[string]map[string]operation{},
+		Components: components{
+			Schemas: g.schemas,
+			Responses: map[string]response{
+				"Problem": {
+					Description: "Problem details (RFC 9457)",
+					Content:     map[string]mediaType{problem.ContentType: {Schema: problemRef}},
+				},
+			},
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Token returned by /login"},
+				"apiKey":     {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "Shop API key, limited to its scopes"},
+			},
+		},
+	}
+
+	for _, op := range operations {
+		if doc.Paths[op.Path] == nil {
+			doc.Paths[op.Path] = map[string]operation{}
+		}
+		doc.Paths[op.Path][strings.ToLower(op.Method)] = g.operation(op)
+	}
+	return doc
+}
+
+func (g *generator) operation(op Operation) operation {
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	out := operation{
+		OperationID: operationID(op.Method, op.Path),
+		Tags:        []string{op.Tag},
+		Summary:     op.Summary,
+		Security:    []map[string][]string{},
+		Responses:   map[string]response{},
+	}
+
+	switch op.Access {
+	case Authenticated:
+		out.Description = "Requires authentication."
+	case Admin:
+		out.Description = "Requires the Admin or SuperAdmin role."
+	case SuperAdmin:
+		out.Description = "Requires the SuperAdmin role."
+	}
+	if op.Access != Public {
+		out.Security = []map[string][]string{{"bearerAuth": {}}}
+	}
+	if len(op.Scopes) > 0 {
+		scopes := op.Scopes
+		if op.Access == SuperAdmin {
+			scopes = append(slices.Clip(scopes), models.ScopeAdmin)
+		}
+		names := make([]string, len(scopes))
+		for i, scope := range scopes {
+			names[i] = string(scope)
+		}
+		out.Security = append(out.Security, map[string][]string{"apiKey": names})
+		out.Description += " API keys need the " + strings.Join(names, ", ") + " scopes."
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		out.Parameters = append(out.Parameters, parameter{
+			Name: match[1], In: "path", Required: true,
+			Schema: schema{"type": "integer", "minimum": 1},
+		})
+	}
+	if op.Query != nil {
+		t := reflect.TypeOf(op.Query)
+		for i := 0; i < t.NumField(); i++ {
+			if name := t.Field(i).Tag.Get("query"); name != "" {
+				out.Parameters = append(out.Parameters, parameter{
+					Name: name, In: "query",
+					Schema: g.schema(t.Field(i).Type),
+				})
+			}
+		}
+	}
+	if op.Conditional && op.Method == http.MethodGet {
+		out.Parameters = append(out.Parameters, parameter{
+			Name: "If-None-Match", In: "header",
+			Description: "Answers 304 when the resource still has this ETag",
+			Schema:      schema{"type": "string"},
+		})
+	}
+	if op.Conditional && op.Method != http.MethodGet {
+		out.Parameters = append(out.Parameters, parameter{
+			Name: "If-Match", In: "header",
+			Description: "ETag the change is based on; it is rejected with 412 if the resource changed since",
+			Schema:      schema{"type": "string"},
+		})
+	}
+	if op.Idempotent {
+		out.Parameters = append(out.Parameters, parameter{
+			Name: "Idempotency-Key", In: "header",
+			Description: "Retries with the same key replay the first response instead of creating again",
+			Schema:      schema{"type": "string", "maxLength": 255},
+		})
+	}
+
+	if op.Request != nil {
+		contentType := op.RequestType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		var bodySchema schema
+		if contentType == mergePatchType {
+			bodySchema = g.mergePatch(reflect.TypeOf(op.Request))
+		} else {
+			bodySchema = g.schema(reflect.TypeOf(op.Request))
+		}
+		out.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{contentType: {Schema: bodySchema}},
+		}
+	}
+
+	success := response{Description: http.StatusText(status)}
+	if op.Response != nil {
+		success.Content = map[string]mediaType{"application/json": {Schema: g.schema(reflect.TypeOf(op.Response))}}
+	}
+	if op.Conditional {
+		success.Headers = map[string]header{"ETag": {Description: "Current version of the resource", Schema: schema{"type": "string"}}}
+	}
+	out.Responses[fmt.Sprint(status)] = success
+
+	problemResponse := func(status int, description string) {
+		out.Responses[fmt.Sprint(status)] = response{Ref: "#/components/responses/Problem", Description: description}
+	}
+	if op.Request != nil {
+		problemResponse(http.StatusBadRequest, "Malformed JSON body")
+		problemResponse(http.StatusUnprocessableEntity, "Invalid fields, listed in errors")
+	}
+	if op.Access != Public {
+		problemResponse(http.StatusUnauthorized, "Missing or invalid credentials")
+		problemResponse(http.StatusForbidden, "Not allowed for this role or shop")
+	}
+	if strings.Contains(op.Path, "{") {
+		problemResponse(http.StatusNotFound, "No such resource")
+	}
+	if op.Conditional && op.Method == http.MethodGet {
+		out.Responses["304"] = response{Description: "Not modified since the ETag in If-None-Match"}
+	}
+	if op.Conditional && op.Method != http.MethodGet {
+		problemResponse(http.StatusPreconditionFailed, "The resource changed since the ETag in If-Match")
+	}
+	if op.Idempotent {
+		problemResponse(http.StatusConflict, "Idempotency-Key reused with a different request, or still in progress")
+	}
+	problemResponse(http.StatusTooManyRequests, "Rate limit exceeded; retry after Retry-After seconds")
+	out.Responses["default"] = response{Ref: "#/components/responses/Problem", Description: "Error"}
+	return out
+}
+
+// operationID turns "POST /users/{id}/deactivate" into "postUsersIdDeactivate"
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return !isAlphanumeric(r) }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// Diff lists the routes missing from the document and the documented
+// operations no route serves, sorted; it is empty when they agree
+func Diff(routes []router.Route, doc *Document) []string {
+	registered := map[string]bool{}
+	for _, route := range routes {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	documented := map[string]bool{}
+	for path, methods := range doc.Paths {
+		for method := range methods {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var diff []string
+	for route := range registered {
+		if !documented[route] {
+			diff = append(diff, "undocumented route "+route)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			diff = append(diff, "documented operation without a route "+op)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
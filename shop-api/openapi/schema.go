@@ -0,0 +1,150 @@
+package openapi
+
+import (
+	"reflect"
+	"shop-api/validate"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schema is a JSON Schema (2020-12) object
+type schema map[string]any
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// generator derives schemas from Go types, collecting named structs as
+// reusable components
+type generator struct {
+	schemas map[string]schema
+}
+
+// schema returns the schema of t, or a $ref to it for named structs
+func (g *generator) schema(t reflect.Type) schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Interface:
+		return schema{} // any JSON value
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = schema{} // placeholder, in case the type refers to itself
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// mergePatch returns a $ref to the JSON Merge Patch (RFC 7396) of the named
+// struct t: every field is optional, null resets it, and other keys are refused
+func (g *generator) mergePatch(t reflect.Type) schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, ok := g.schemas[t.Name()]; !ok {
+		properties := schema{}
+		for name, property := range g.object(t)["properties"].(schema) {
+			properties[name] = nullable(property.(schema))
+		}
+		g.schemas[t.Name()] = schema{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return schema{"$ref": "#/components/schemas/" + t.Name()}
+}
+
+// nullable lets s also be null
+func nullable(s schema) schema {
+	if name, ok := s["type"].(string); ok {
+		s["type"] = []string{name, "null"}
+		return s
+	}
+	return schema{"anyOf": []schema{s, {"type": "null"}}}
+}
+
+// object describes a struct from its json and validate tags
+func (g *generator) object(t reflect.Type) schema {
+	properties := schema{}
+	var required []string
+	g.fields(t, properties, &required)
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// fields adds the properties of t, flattening embedded structs as encoding/json does
+func (g *generator) fields(t reflect.Type, properties schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && tag == "" {
+			g.fields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := validate.FieldName(field)
+		s := g.schema(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				*required = append(*required, name)
+				continue
+			}
+			constrain(s, field.Type, rule)
+		}
+		properties[name] = s
+	}
+}
+
+// constrain translates one validate rule into schema keywords
+func constrain(s schema, t reflect.Type, rule string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	rule, arg, _ := strings.Cut(rule, "=")
+	switch rule {
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(arg, 64)
+		keyword := map[reflect.Kind]string{reflect.String: "Length", reflect.Slice: "Items", reflect.Array: "Items"}[t.Kind()]
+		if keyword == "" {
+			s[map[string]string{"min": "minimum", "max": "maximum"}[rule]] = limit
+			return
+		}
+		s[rule+keyword] = int(limit)
+	case "email":
+		s["format"] = "email"
+	case "url":
+		s["format"] = "uri"
+	case "oneof":
+		s["enum"] = strings.Fields(arg)
+	}
+}
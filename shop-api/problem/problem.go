@@ -0,0 +1,89 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of RFC 9457 problem details
+const ContentType = "application/problem+json"
+
+// Machine-readable error codes, stable across releases
+const (
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidInput         = "invalid_input"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeForbidden            = "forbidden"
+	CodeForbiddenTenant      = "forbidden_tenant"
+	CodeAccountDisabled      = "account_disabled"
+	CodeTwoFactorRequired    = "two_factor_required"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeInsufficientStock    = "insufficient_stock"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRequestInProgress    = "request_in_progress"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTooManyAttempts      = "too_many_attempts"
+	CodeRateLimited          = "rate_limited"
+	CodeBadGateway           = "bad_gateway"
+	CodeInternal             = "internal_error"
+)
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New builds a problem whose type is derived from its code
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "urn:shop-api:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// Write sends the problem as application/problem+json
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error is a shortcut for Write(w, r, New(status, code, detail))
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Write(w, r, New(status, code, detail))
+}
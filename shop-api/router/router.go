@@ -0,0 +1,113 @@
+package router
+
+import (
+	"net/http"
+	"shop-api/config"
+	"shop-api/problem"
+	"strings"
+)
+
+// Route is a registered endpoint, with its path relative to the API version prefix
+type Route struct {
+	Method string
+	Path   string // e.g. /products/{id}
+}
+
+// Router registers every route under the API version prefix and keeps the
+// original unversioned path as a deprecated alias. It answers unknown paths
+// with a JSON 404 and known paths with the wrong method with a JSON 405.
+type Router struct {
+	mux      *http.ServeMux
+	routes   []Route
+	fallback http.HandlerFunc
+}
+
+func New() *Router {
+	return &Router{
+		mux: http.NewServeMux(),
+	}
+}
+
+// Handle registers "METHOD /api/v1/path" and its deprecated alias "METHOD /path"
+func (rt *Router) Handle(method, path string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, Route{Method: method, Path: path})
+
+	rt.mux.HandleFunc(method+" "+config.APIPrefix+path, handler)
+	rt.mux.HandleFunc(method+" "+path, deprecated(handler))
+}
+
+// HandleRoot registers "METHOD /path" outside the versioned API, for
+// documents describing the service itself. It is not listed in Routes.
+func (rt *Router) HandleRoot(method, path string, handler http.HandlerFunc) {
+	rt.mux.HandleFunc(method+" "+path, handler)
+}
+
+// Fallback sets the handler for GET requests outside the API that match no
+// route, such as static files. It is kept out of the mux so that it doesn't
+// turn every unknown API path into a 405.
+func (rt *Router) Fallback(handler http.HandlerFunc) {
+	rt.fallback = handler
+}
+
+// Routes returns the versioned API routes in registration order
+func (rt *Router) Routes() []Route {
+	return rt.routes
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.mux.Handler(r); pattern == "" {
+		rt.notMatched(w, r)
+		return
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// notMatched lets the mux decide between 404 and 405, then replaces its
+// plain-text answer with a problem+json document. The mux writes into headers
+// of its own so its Content-Type and nosniff don't leak into the fallback;
+// only Allow is kept.
+func (rt *Router) notMatched(w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{header: make(http.Header)}
+	handler, _ := rt.mux.Handler(r)
+	handler.ServeHTTP(recorder, r)
+
+	if recorder.status == http.StatusMethodNotAllowed {
+		if allow := recorder.header.Values("Allow"); len(allow) > 0 {
+			w.Header()["Allow"] = allow
+		}
+		problem.Error(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "method "+r.Method+" is not allowed on this resource")
+		return
+	}
+
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if rt.fallback != nil && isRead && !strings.HasPrefix(r.URL.Path, config.APIPrefix+"/") {
+		rt.fallback(w, r)
+		return
+	}
+	NotFound(w, r)
+}
+
+// NotFound writes the API's problem+json 404
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "no resource matches this path")
+}
+
+// deprecated marks responses of an unversioned alias with its successor
+func deprecated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+config.APIPrefix+r.URL.Path+`>; rel="successor-version"`)
+		handler(w, r)
+	}
+}
+
+// statusRecorder captures the status code and headers of the mux's own error
+// handlers, discarding their body
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header         { return r.header }
+func (r *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *statusRecorder) WriteHeader(status int)      { r.status = status }
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"shop-api/problem"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestRouter() *Router {
+	rt := New()
+	rt.Handle("GET", "/products", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rt.Fallback(http.FileServerFS(fstest.MapFS{
+		"index.html": {Data: []byte("<!doctype html><title>Shop</title>")},
+	}).ServeHTTP)
+	return rt
+}
+
+func serve(rt *Router, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestFallbackKeepsItsOwnHeaders(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/html", got)
+	}
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "" {
+		t.Errorf("X-Content-Type-Options = %q leaked from the mux's 404", got)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	for _, path := range []string{"/api/v1/products", "/products"} {
+		rec := serve(newTestRouter(), http.MethodPost, path)
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: status = %d, want 405", path, rec.Code)
+		}
+		if got := rec.Header().Get("Allow"); got != "GET, HEAD" {
+			t.Errorf("%s: Allow = %q, want \"GET, HEAD\"", path, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != problem.ContentType {
+			t.Errorf("%s: Content-Type = %q, want %q", path, got, problem.ContentType)
+		}
+	}
+}
+
+func TestUnknownAPIPathIsNotFound(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/api/v1/index.html")
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, problem.ContentType)
+	}
+	if got := rec.Header().Get("Allow"); got != "" {
+		t.Errorf("Allow = %q on a 404", got)
+	}
+}
+
+func TestDeprecatedAlias(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/products")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec.Header().Get("Deprecation") != "true" {
+		t.Error("alias response lacks Deprecation")
+	}
+	if got, want := rec.Header().Get("Link"), `</api/v1/products>; rel="successor-version"`; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+}
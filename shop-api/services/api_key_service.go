@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"shop-api/models"
+	"shop-api/utils"
+	"slices"
+	"sync"
+	"time"
+)
+
+// apiKeyPrefix marks shop-api keys, making leaked keys easy to grep for
+const apiKeyPrefix = "sk_"
+
+type APIKeyService interface {
+	GetByShopID(shopID int) []models.APIKey
+	Create(shopID int, name string, scopes []models.Scope, createdBy int) (*models.APIKey, string, error)
+	Revoke(shopID, id int) error
+	Authenticate(key string) (*models.APIKey, error)
+	Ping(ctx context.Context) error // whether the store answers, for readiness
+}
+
+type APIKeyServiceImpl struct {
+	keys   []models.APIKey
+	nextID int
+	mu     sync.RWMutex
+}
+
+func NewAPIKeyService() APIKeyService {
+	return &APIKeyServiceImpl{
+		keys:   []models.APIKey{},
+		nextID: 1,
+	}
+}
+
+func (s *APIKeyServiceImpl) GetByShopID(shopID int) []models.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, key := range s.keys {
+		if key.ShopID == shopID {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Create generates a new key and returns it in clear text; this is the only
+// time the full key is available
+func (s *APIKeyServiceImpl) Create(shopID int, name string, scopes []models.Scope, createdBy int) (*models.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", newError(ErrInvalidInput, "at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.ValidScopes, scope) {
+			return nil, "", newError(ErrInvalidInput, "invalid scope: "+string(scope))
+		}
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := apiKeyPrefix + secret
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := models.APIKey{
+		ID:        s.nextID,
+		Name:      name,
+		Prefix:    plaintext[:len(apiKeyPrefix)+8],
+		KeyHash:   utils.HashToken(plaintext),
+		Scopes:    scopes,
+		ShopID:    shopID,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	s.nextID++
+	s.keys = append(s.keys, key)
+	slog.Info("api key created", "api_key_id", key.ID, "shop_id", shopID, "created_by", createdBy, "scopes", scopes)
+
+	return &key, plaintext, nil
+}
+
+func (s *APIKeyServiceImpl) Revoke(shopID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].ID == id && s.keys[i].ShopID == shopID {
+			if s.keys[i].RevokedAt == nil {
+				now := time.Now()
+				s.keys[i].RevokedAt = &now
+				slog.Info("api key revoked", "api_key_id", id, "shop_id", shopID)
+			}
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "api key not found")
+}
+
+// Authenticate resolves a clear-text key and records its use
+func (s *APIKeyServiceImpl) Authenticate(key string) (*models.APIKey, error) {
+	hash := utils.HashToken(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].KeyHash == hash {
+			if s.keys[i].RevokedAt != nil {
+				return nil, newError(ErrInvalidCredentials, "api key revoked")
+			}
+			now := time.Now()
+			s.keys[i].LastUsedAt = &now
+			apiKey := s.keys[i]
+			return &apiKey, nil
+		}
+	}
+	return nil, newError(ErrInvalidCredentials, "invalid api key")
+}
+
+func (s *APIKeyServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"shop-api/models"
+	"sync"
+	"time"
+)
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	ShopID   int
+	ActorID  int
+	APIKeyID int
+	Action   string
+	Entity   string
+	EntityID int
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Limit    int
+}
+
+// AuditService is append-only: entries can be recorded and listed, never changed
+type AuditService interface {
+	Record(entry models.AuditEntry)
+	List(filter AuditFilter) []models.AuditEntry
+	Ping(ctx context.Context) error // whether the store answers, for readiness
+}
+
+type AuditServiceImpl struct {
+	entries []models.AuditEntry
+	nextID  int
+	mu      sync.RWMutex
+}
+
+func NewAuditService() AuditService {
+	return &AuditServiceImpl{
+		nextID: 1,
+	}
+}
+
+func (s *AuditServiceImpl) Record(entry models.AuditEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.nextID
+	entry.CreatedAt = time.Now()
+	s.nextID++
+	s.entries = append(s.entries, entry)
+}
+
+// List returns the matching entries, newest first
+func (s *AuditServiceImpl) List(filter AuditFilter) []models.AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []models.AuditEntry{}
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if entry := s.entries[i]; filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (f AuditFilter) matches(entry models.AuditEntry) bool {
+	return (f.ShopID == 0 || entry.ShopID == f.ShopID) &&
+		(f.ActorID == 0 || entry.ActorID == f.ActorID) &&
+		(f.APIKeyID == 0 || entry.APIKeyID == f.APIKeyID) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Entity == "" || entry.Entity == f.Entity) &&
+		(f.EntityID == 0 || entry.EntityID == f.EntityID) &&
+		(f.From.IsZero() || !entry.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || entry.CreatedAt.Before(f.To))
+}
+
+func (s *AuditServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
+}
+
+// auditIgnoredFields change on every update and say nothing about it
+var auditIgnoredFields = map[string]bool{"version": true}
+
+// AuditChanges compares the JSON fields of two states of an entity, either of
+// which may be nil (before a create, after a delete)
+func AuditChanges(before, after any) map[string]models.AuditChange {
+	beforeFields, afterFields := jsonFields(before), jsonFields(after)
+
+	changes := map[string]models.AuditChange{}
+	for name, value := range beforeFields {
+		if !auditIgnoredFields[name] && !reflect.DeepEqual(value, afterFields[name]) {
+			changes[name] = models.AuditChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok && !auditIgnoredFields[name] && value != nil {
+			changes[name] = models.AuditChange{After: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// jsonFields returns the fields of a value as it is encoded in responses
+func jsonFields(value any) map[string]any {
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	return fields
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Sentinel errors returned (wrapped) by services. Handlers map them to HTTP
+// status codes in one place; use errors.Is to test for them.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrForbiddenTenant    = errors.New("resource belongs to another shop")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrAccountDisabled    = errors.New("account is deactivated")
+	ErrInvalidInput       = errors.New("invalid input")
+	ErrVersionConflict    = errors.New("resource was modified by another request")
+)
+
+// serviceError carries a specific message while matching a sentinel with errors.Is
+type serviceError struct {
+	kind    error
+	message string
+}
+
+func (e *serviceError) Error() string { return e.message }
+func (e *serviceError) Unwrap() error { return e.kind }
+
+// checkVersion fails with ErrVersionConflict when an expected version is
+// given (non-zero) and the stored one has moved on
+func checkVersion(resource string, current, expected int) error {
+	if expected != 0 && expected != current {
+		return newError(ErrVersionConflict, resource+" was modified since version "+strconv.Itoa(expected))
+	}
+	return nil
+}
+
+// newError returns an error with the given message that wraps kind
+func newError(kind error, message string) error {
+	return &serviceError{kind: kind, message: message}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"shop-api/config"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+	// ErrRequestInProgress is returned while the first request with a key is still running
+	ErrRequestInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// StoredResponse is the response replayed for a repeated idempotency key
+type StoredResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+type IdempotencyService interface {
+	// Begin reserves a key for a request identified by its fingerprint. It
+	// returns the stored response when the same request already completed.
+	Begin(shopID int, key, fingerprint string) (*StoredResponse, error)
+	// Complete stores the response of a reserved key
+	Complete(shopID int, key string, response StoredResponse)
+	// Release forgets a reserved key so the request can be retried
+	Release(shopID int, key string)
+}
+
+// idempotencyRecord is one key of one shop
+type idempotencyRecord struct {
+	Fingerprint string
+	Response    *StoredResponse // nil while the request is in flight
+	CreatedAt   time.Time
+}
+
+type idempotencyKey struct {
+	shopID int
+	key    string
+}
+
+type IdempotencyServiceImpl struct {
+	records map[idempotencyKey]*idempotencyRecord
+	mu      sync.Mutex
+}
+
+func NewIdempotencyService() IdempotencyService {
+	return &IdempotencyServiceImpl{
+		records: make(map[idempotencyKey]*idempotencyRecord),
+	}
+}
+
+func (s *IdempotencyServiceImpl) Begin(shopID int, key, fingerprint string) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	id := idempotencyKey{shopID: shopID, key: key}
+	if record, ok := s.records[id]; ok && now.Sub(record.CreatedAt) < config.IdempotencyKeyWindow {
+		switch {
+		case record.Fingerprint != fingerprint:
+			return nil, ErrIdempotencyKeyReused
+		case record.Response == nil:
+			return nil, ErrRequestInProgress
+		}
+		return record.Response, nil
+	}
+
+	if len(s.records) >= pruneThreshold {
+		s.prune(now)
+	}
+	s.records[id] = &idempotencyRecord{Fingerprint: fingerprint, CreatedAt: now}
+	return nil, nil
+}
+
+func (s *IdempotencyServiceImpl) Complete(shopID int, key string, response StoredResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[idempotencyKey{shopID: shopID, key: key}]; ok {
+		record.Response = &response
+	}
+}
+
+func (s *IdempotencyServiceImpl) Release(shopID int, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, idempotencyKey{shopID: shopID, key: key})
+}
+
+// prune drops keys older than the replay window
+func (s *IdempotencyServiceImpl) prune(now time.Time) {
+	for id, record := range s.records {
+		if now.Sub(record.CreatedAt) >= config.IdempotencyKeyWindow {
+			delete(s.records, id)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"shop-api/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginThrottledError is returned while an account or IP is backing off or locked out
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %d seconds", int(e.RetryAfter.Seconds()+0.5))
+}
+
+type LoginAttemptService interface {
+	Check(email, ip string) error
+	RecordFailure(email, ip string)
+	RecordSuccess(email, ip string)
+	Unlock(email string)
+}
+
+// pruneThreshold is the map size above which stale entries are swept
+const pruneThreshold = 1000
+
+// loginAttempt tracks recent failures for one account or IP
+type loginAttempt struct {
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+type LoginAttemptServiceImpl struct {
+	accounts map[string]*loginAttempt
+	ips      map[string]*loginAttempt
+	mu       sync.Mutex
+}
+
+func NewLoginAttemptService() LoginAttemptService {
+	return &LoginAttemptServiceImpl{
+		accounts: make(map[string]*loginAttempt),
+		ips:      make(map[string]*loginAttempt),
+	}
+}
+
+// Accounts are keyed by normalized email whether or not the user exists,
+// so lockouts don't reveal which addresses are registered
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (s *LoginAttemptServiceImpl) Check(email, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, attempt := range []*loginAttempt{s.accounts[accountKey(email)], s.ips[ip]} {
+		if attempt != nil && attempt.BlockedUntil.After(now) {
+			retryAfter = max(retryAfter, attempt.BlockedUntil.Sub(now))
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (s *LoginAttemptServiceImpl) RecordFailure(email, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if recordFailure(s.accounts, accountKey(email), config.LoginMaxAccountFailures, now) {
+		slog.Warn("account locked out after failed logins", "email", accountKey(email), "client_ip", ip, "duration", config.LoginLockoutDuration)
+	}
+	if recordFailure(s.ips, ip, config.LoginMaxIPFailures, now) {
+		slog.Warn("client IP locked out after failed logins", "client_ip", ip, "duration", config.LoginLockoutDuration)
+	}
+}
+
+// RecordSuccess clears the account's failures. IP failures are kept so that
+// one valid account can't be used to reset an IP that is guessing others.
+func (s *LoginAttemptServiceImpl) RecordSuccess(email, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.accounts, accountKey(email))
+}
+
+func (s *LoginAttemptServiceImpl) Unlock(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.accounts, accountKey(email))
+}
+
+// recordFailure counts a failure and reports whether it just locked the key out
+func recordFailure(attempts map[string]*loginAttempt, key string, maxFailures int, now time.Time) bool {
+	attempt, ok := attempts[key]
+	if !ok || now.Sub(attempt.LastFailure) > config.LoginFailureWindow {
+		if len(attempts) >= pruneThreshold {
+			pruneAttempts(attempts, now)
+		}
+		attempt = &loginAttempt{}
+		attempts[key] = attempt
+	}
+
+	attempt.Failures++
+	attempt.LastFailure = now
+
+	if attempt.Failures >= maxFailures {
+		attempt.BlockedUntil = now.Add(config.LoginLockoutDuration)
+		return attempt.Failures == maxFailures
+	}
+
+	// Exponential backoff: base, 2*base, 4*base... capped
+	backoff := config.LoginBackoffBase
+	for i := 1; i < attempt.Failures && backoff < config.LoginBackoffMax; i++ {
+		backoff *= 2
+	}
+	attempt.BlockedUntil = now.Add(min(backoff, config.LoginBackoffMax))
+	return false
+}
+
+// pruneAttempts drops entries that are neither blocked nor within the failure window
+func pruneAttempts(attempts map[string]*loginAttempt, now time.Time) {
+	for key, attempt := range attempts {
+		if now.After(attempt.BlockedUntil) && now.Sub(attempt.LastFailure) > config.LoginFailureWindow {
+			delete(attempts, key)
+		}
+	}
+}
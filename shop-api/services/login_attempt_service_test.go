@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"shop-api/config"
+	"testing"
+	"time"
+)
+
+// throttled returns how long Check asks to wait, 0 when the login may proceed
+func throttled(t *testing.T, attempts LoginAttemptService, email, ip string) time.Duration {
+	t.Helper()
+
+	err := attempts.Check(email, ip)
+	if err == nil {
+		return 0
+	}
+	var throttledErr *LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("err = %v, want a *LoginThrottledError", err)
+	}
+	return throttledErr.RetryAfter
+}
+
+func TestLoginBackoffDoublesAfterEachFailure(t *testing.T) {
+	attempts := NewLoginAttemptService()
+	if wait := throttled(t, attempts, "a@shop1.com", "192.0.2.1"); wait != 0 {
+		t.Fatalf("throttled before any failure (%v)", wait)
+	}
+
+	for failures, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		attempts.RecordFailure("a@shop1.com", "192.0.2.1")
+		wait := throttled(t, attempts, "a@shop1.com", "192.0.2.1")
+		if wait <= want-time.Second/2 || wait > want {
+			t.Errorf("after %d failures: retry in %v, want about %v", failures+1, wait, want)
+		}
+	}
+
+	// The account key ignores case and spaces, whatever the IP
+	if wait := throttled(t, attempts, " A@Shop1.com ", "198.51.100.7"); wait == 0 {
+		t.Error("the account is not throttled from another IP")
+	}
+	// Other accounts from other IPs are not affected
+	if wait := throttled(t, attempts, "b@shop1.com", "198.51.100.7"); wait != 0 {
+		t.Errorf("unrelated login throttled for %v", wait)
+	}
+}
+
+func TestLoginBackoffIsCapped(t *testing.T) {
+	previous := config.LoginMaxAccountFailures
+	config.LoginMaxAccountFailures = 100
+	t.Cleanup(func() { config.LoginMaxAccountFailures = previous })
+
+	attempts := NewLoginAttemptService()
+	for range 10 {
+		attempts.RecordFailure("a@shop1.com", "192.0.2.1")
+	}
+	if wait := throttled(t, attempts, "a@shop1.com", "192.0.2.2"); wait > config.LoginBackoffMax {
+		t.Errorf("retry in %v, above the %v cap", wait, config.LoginBackoffMax)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	attempts := NewLoginAttemptService()
+	for range config.LoginMaxAccountFailures {
+		attempts.RecordFailure("a@shop1.com", "192.0.2.1")
+	}
+
+	wait := throttled(t, attempts, "a@shop1.com", "192.0.2.2")
+	if wait <= config.LoginLockoutDuration-time.Minute {
+		t.Fatalf("retry in %v, want a lockout of %v", wait, config.LoginLockoutDuration)
+	}
+
+	// An administrator lifts it
+	attempts.Unlock("a@shop1.com")
+	if wait := throttled(t, attempts, "a@shop1.com", "192.0.2.2"); wait != 0 {
+		t.Errorf("still throttled for %v after Unlock", wait)
+	}
+}
+
+func TestIPLockoutSurvivesASuccessfulLogin(t *testing.T) {
+	attempts := NewLoginAttemptService()
+	// Guessing many accounts from one IP, once each
+	for i := range config.LoginMaxIPFailures {
+		attempts.RecordFailure(string(rune('a'+i))+"@shop1.com", "192.0.2.1")
+	}
+	if wait := throttled(t, attempts, "new@shop1.com", "192.0.2.1"); wait <= config.LoginLockoutDuration-time.Minute {
+		t.Fatalf("retry in %v, want the IP locked out for %v", wait, config.LoginLockoutDuration)
+	}
+
+	// A valid account does not reset the IP
+	attempts.RecordSuccess("a@shop1.com", "192.0.2.1")
+	if wait := throttled(t, attempts, "new@shop1.com", "192.0.2.1"); wait == 0 {
+		t.Error("a successful login lifted the IP lockout")
+	}
+}
+
+func TestSuccessfulLoginClearsAccountFailures(t *testing.T) {
+	attempts := NewLoginAttemptService()
+	for range config.LoginMaxAccountFailures - 1 {
+		attempts.RecordFailure("a@shop1.com", "192.0.2.1")
+	}
+	attempts.RecordSuccess("a@shop1.com", "192.0.2.1")
+
+	// The count starts over: one more failure only backs off
+	attempts.RecordFailure("a@shop1.com", "192.0.2.2")
+	if wait := throttled(t, attempts, "a@shop1.com", "192.0.2.3"); wait > config.LoginBackoffBase {
+		t.Errorf("retry in %v, want the first backoff of %v", wait, config.LoginBackoffBase)
+	}
+}
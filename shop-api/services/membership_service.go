@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"shop-api/models"
+	"sync"
+	"time"
+)
+
+type MembershipService interface {
+	GetByUserID(userID int) []models.Membership
+	GetByShopID(shopID int) []models.Membership
+	Get(userID, shopID int) (*models.Membership, error)
+	Create(membership models.Membership) (*models.Membership, error)
+	Delete(userID, shopID int) error
+	SetActive(userID, shopID int, active bool) error
+	AdministersAllShops(adminID, userID int) bool
+	Invite(invitation models.Invitation) (*models.Invitation, error)
+	GetInvitations(userID int) []models.Invitation
+	AcceptInvitation(id, userID int) (*models.Membership, error)
+	DeclineInvitation(id, userID int) (*models.Invitation, error)
+	Ping(ctx context.Context) error // whether the store answers, for readiness
+}
+
+type MembershipServiceImpl struct {
+	memberships      []models.Membership
+	nextID           int
+	invitations      []models.Invitation
+	nextInvitationID int
+	mu               sync.RWMutex
+}
+
+func NewMembershipService() MembershipService {
+	return &MembershipServiceImpl{
+		memberships: []models.Membership{
+			{
+				ID:        1,
+				UserID:    1,
+				ShopID:    1,
+				Role:      models.RoleSuperAdmin,
+				Active:    true,
+				CreatedAt: time.Now(),
+			},
+			{
+				ID:        2,
+				UserID:    2,
+				ShopID:    1,
+				Role:      models.RoleAdmin,
+				Active:    true,
+				CreatedAt: time.Now(),
+			},
+		},
+		nextID:           3,
+		nextInvitationID: 1,
+	}
+}
+
+func (s *MembershipServiceImpl) GetByUserID(userID int) []models.Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var memberships []models.Membership
+	for _, membership := range s.memberships {
+		if membership.UserID == userID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships
+}
+
+func (s *MembershipServiceImpl) GetByShopID(shopID int) []models.Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var memberships []models.Membership
+	for _, membership := range s.memberships {
+		if membership.ShopID == shopID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships
+}
+
+func (s *MembershipServiceImpl) Get(userID, shopID int) (*models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, membership := range s.memberships {
+		if membership.UserID == userID && membership.ShopID == shopID {
+			return &membership, nil
+		}
+	}
+	return nil, newError(ErrNotFound, "membership not found")
+}
+
+// Create adds a membership, or updates the role if the user already belongs to the shop
+func (s *MembershipServiceImpl) Create(membership models.Membership) (*models.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.memberships {
+		if s.memberships[i].UserID == membership.UserID && s.memberships[i].ShopID == membership.ShopID {
+			s.memberships[i].Role = membership.Role
+			slog.Info("membership role changed", "user_id", membership.UserID, "shop_id", membership.ShopID, "role", membership.Role)
+			return &s.memberships[i], nil
+		}
+	}
+
+	membership.ID = s.nextID
+	membership.Active = true
+	membership.CreatedAt = time.Now()
+	s.nextID++
+	s.memberships = append(s.memberships, membership)
+	slog.Info("membership granted", "user_id", membership.UserID, "shop_id", membership.ShopID, "role", membership.Role)
+
+	return &membership, nil
+}
+
+func (s *MembershipServiceImpl) Delete(userID, shopID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, membership := range s.memberships {
+		if membership.UserID == userID && membership.ShopID == shopID {
+			s.memberships = append(s.memberships[:i], s.memberships[i+1:]...)
+			slog.Info("membership revoked", "user_id", userID, "shop_id", shopID)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "membership not found")
+}
+
+// SetActive suspends or restores a user's access to one shop, leaving the
+// account and its other memberships alone
+func (s *MembershipServiceImpl) SetActive(userID, shopID int, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.memberships {
+		if s.memberships[i].UserID == userID && s.memberships[i].ShopID == shopID {
+			s.memberships[i].Active = active
+			slog.Info("membership activation changed", "user_id", userID, "shop_id", shopID, "active", active)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "membership not found")
+}
+
+// AdministersAllShops reports whether adminID is an active SuperAdmin of every
+// shop userID belongs to. Only then may adminID change the account itself
+// (email, password, activation), which all of those shops share.
+func (s *MembershipServiceImpl) AdministersAllShops(adminID, userID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, membership := range s.memberships {
+		if membership.UserID != userID {
+			continue
+		}
+		administers := false
+		for _, admin := range s.memberships {
+			if admin.UserID == adminID && admin.ShopID == membership.ShopID {
+				administers = admin.Active && admin.Role == models.RoleSuperAdmin
+				break
+			}
+		}
+		if !administers {
+			return false
+		}
+	}
+	return true
+}
+
+// Invite records a pending invitation, replacing the role of an earlier one to
+// the same shop. Users who already belong to the shop cannot be invited.
+func (s *MembershipServiceImpl) Invite(invitation models.Invitation) (*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, membership := range s.memberships {
+		if membership.UserID == invitation.UserID && membership.ShopID == invitation.ShopID {
+			return nil, newError(ErrConflict, "user is already a member of this shop")
+		}
+	}
+
+	for i := range s.invitations {
+		if s.invitations[i].UserID == invitation.UserID && s.invitations[i].ShopID == invitation.ShopID {
+			s.invitations[i].Role = invitation.Role
+			s.invitations[i].InvitedBy = invitation.InvitedBy
+			return &s.invitations[i], nil
+		}
+	}
+
+	invitation.ID = s.nextInvitationID
+	invitation.CreatedAt = time.Now()
+	s.nextInvitationID++
+	s.invitations = append(s.invitations, invitation)
+	slog.Info("membership invitation sent", "user_id", invitation.UserID, "shop_id", invitation.ShopID, "role", invitation.Role)
+
+	return &invitation, nil
+}
+
+// GetInvitations returns the invitations awaiting a user's answer
+func (s *MembershipServiceImpl) GetInvitations(userID int) []models.Invitation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var invitations []models.Invitation
+	for _, invitation := range s.invitations {
+		if invitation.UserID == userID {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations
+}
+
+// AcceptInvitation turns an invitation addressed to userID into a membership
+func (s *MembershipServiceImpl) AcceptInvitation(id, userID int) (*models.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, err := s.takeInvitation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Joined in the meantime (single sign-on): the existing role stands
+	for _, membership := range s.memberships {
+		if membership.UserID == userID && membership.ShopID == invitation.ShopID {
+			return &membership, nil
+		}
+	}
+
+	membership := models.Membership{
+		ID:        s.nextID,
+		UserID:    invitation.UserID,
+		ShopID:    invitation.ShopID,
+		Role:      invitation.Role,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+	s.nextID++
+	s.memberships = append(s.memberships, membership)
+	slog.Info("membership granted", "user_id", membership.UserID, "shop_id", membership.ShopID, "role", membership.Role, "invitation_id", id)
+
+	return &membership, nil
+}
+
+// DeclineInvitation discards an invitation addressed to userID and returns it
+func (s *MembershipServiceImpl) DeclineInvitation(id, userID int) (*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, err := s.takeInvitation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("membership invitation declined", "user_id", userID, "shop_id", invitation.ShopID)
+	return &invitation, nil
+}
+
+// takeInvitation removes an invitation addressed to userID; the caller holds the lock.
+// Another user's invitation is reported as missing.
+func (s *MembershipServiceImpl) takeInvitation(id, userID int) (models.Invitation, error) {
+	for i, invitation := range s.invitations {
+		if invitation.ID == id && invitation.UserID == userID {
+			s.invitations = append(s.invitations[:i], s.invitations[i+1:]...)
+			return invitation, nil
+		}
+	}
+	return models.Invitation{}, newError(ErrNotFound, "invitation not found")
+}
+
+func (s *MembershipServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"shop-api/config"
+	"shop-api/models"
+	"shop-api/utils"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type OIDCService interface {
+	AuthCodeURL(ctx context.Context) (authURL, state string, err error)
+	Exchange(ctx context.Context, code, state string) (*models.User, string, error)
+}
+
+// oidcLogin is a login started at AuthCodeURL, waiting for the IdP callback
+type oidcLogin struct {
+	Verifier  string // PKCE code verifier
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// oidcClaims are the ID token claims used to identify and map the user
+type oidcClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	AMR           []string `json:"amr"`
+}
+
+type OIDCServiceImpl struct {
+	userSvc       UserService
+	membershipSvc MembershipService
+	mappings      []config.OIDCGroupMapping
+
+	// The provider is discovered on first use, so the API starts even if the IdP is down
+	provider *oidc.Provider
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	logins map[string]oidcLogin // keyed by state
+	mu     sync.Mutex
+}
+
+func NewOIDCService(userSvc UserService, membershipSvc MembershipService) (OIDCService, error) {
+	for _, mapping := range config.OIDCGroupMappings {
+		role := models.Role(mapping.Role)
+		if role != models.RoleSuperAdmin && role != models.RoleAdmin {
+			return nil, fmt.Errorf("oidc: invalid role %q for group %q", mapping.Role, mapping.Group)
+		}
+	}
+
+	return &OIDCServiceImpl{
+		userSvc:       userSvc,
+		membershipSvc: membershipSvc,
+		mappings:      config.OIDCGroupMappings,
+		logins:        make(map[string]oidcLogin),
+	}, nil
+}
+
+// discover fetches the provider metadata once; callers must hold s.mu
+func (s *OIDCServiceImpl) discover(ctx context.Context) error {
+	if s.provider != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.OIDCIssuerURL)
+	if err != nil {
+		return fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+
+	s.provider = provider
+	s.oauth2 = oauth2.Config{
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       config.OIDCScopes,
+	}
+	s.verifier = provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID})
+	return nil
+}
+
+// AuthCodeURL starts an authorization code flow with PKCE and returns the IdP
+// URL, along with the state the caller must bind to the browser
+func (s *OIDCServiceImpl) AuthCodeURL(ctx context.Context) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.discover(ctx); err != nil {
+		return "", "", err
+	}
+
+	state, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// Drop logins that were never completed
+	now := time.Now()
+	for key, login := range s.logins {
+		if now.After(login.ExpiresAt) {
+			delete(s.logins, key)
+		}
+	}
+
+	s.logins[state] = oidcLogin{
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: now.Add(config.OIDCLoginExpiration),
+	}
+
+	return s.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)), state, nil
+}
+
+// Exchange completes the flow: it redeems the code, verifies the ID token,
+// syncs the user's memberships from their groups and issues a shop-api token.
+// Accounts with TOTP enabled get ErrTwoFactorRequired instead of a token.
+func (s *OIDCServiceImpl) Exchange(ctx context.Context, code, state string) (*models.User, string, error) {
+	s.mu.Lock()
+	login, ok := s.logins[state]
+	delete(s.logins, state)
+	oauthConfig, verifier := s.oauth2, s.verifier
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(login.ExpiresAt) {
+		return nil, "", newError(ErrInvalidInput, "unknown or expired login state")
+	}
+
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("oidc: code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("oidc: no id_token in token response")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("oidc: invalid id_token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, "", errors.New("oidc: nonce mismatch")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+	// Accounts are matched by email, so an address the IdP has not checked
+	// would let anyone registering it there sign in as the local user
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, "", errors.New("oidc: a verified email is required")
+	}
+
+	var raw map[string]any
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, "", err
+	}
+	groups := stringList(raw[config.OIDCGroupsClaim])
+
+	user, err := s.syncUser(claims, groups)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The IdP's multi-factor authentication counts for accounts without TOTP;
+	// those with it still have to present their code
+	twoFactor := slices.ContainsFunc(claims.AMR, func(method string) bool {
+		return method == "mfa" || method == "otp" || method == "hwk"
+	})
+
+	return s.userSvc.IssueToken(user.ID, twoFactor)
+}
+
+// syncUser finds or provisions the user, then makes their memberships in the
+// mapped shops match their IdP groups. Shops without mappings are left alone.
+func (s *OIDCServiceImpl) syncUser(claims oidcClaims, groups []string) (*models.User, error) {
+	// Highest role per mapped shop
+	granted := make(map[int]models.Role)
+	managed := make(map[int]bool)
+	var firstShop int
+	for _, mapping := range s.mappings {
+		managed[mapping.ShopID] = true
+		if !slices.Contains(groups, mapping.Group) {
+			continue
+		}
+		if firstShop == 0 {
+			firstShop = mapping.ShopID
+		}
+		if granted[mapping.ShopID] != models.RoleSuperAdmin {
+			granted[mapping.ShopID] = models.Role(mapping.Role)
+		}
+	}
+
+	user, err := s.userSvc.GetByEmail(claims.Email)
+	if err != nil {
+		if len(granted) == 0 {
+			return nil, newError(ErrForbiddenTenant, "no shop is mapped to your groups")
+		}
+		name := claims.Name
+		if name == "" {
+			name = claims.Email
+		}
+		user, err = s.userSvc.CreateExternal(name, claims.Email, firstShop, granted[firstShop])
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("user provisioned by single sign-on", "user_id", user.ID, "shop_id", firstShop)
+	}
+
+	for shopID := range managed {
+		role, ok := granted[shopID]
+		if !ok {
+			s.membershipSvc.Delete(user.ID, shopID)
+			continue
+		}
+		if _, err := s.membershipSvc.Create(models.Membership{
+			UserID: user.ID,
+			ShopID: shopID,
+			Role:   role,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// stringList converts a claim that may be a string or a list of strings
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"shop-api/config"
+	"shop-api/models"
+	"shop-api/utils"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "shop-api"
+
+// stubIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that checks the PKCE verifier before returning a signed ID token
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]stubGrant // authorization code -> what it redeems for
+}
+
+type stubGrant struct {
+	Challenge string
+	Claims    jwt.MapClaims
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, codes: make(map[string]stubGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	issuer, clientID, secret, redirect := config.OIDCIssuerURL, config.OIDCClientID, config.OIDCClientSecret, config.OIDCRedirectURL
+	groupsClaim, mappings := config.OIDCGroupsClaim, config.OIDCGroupMappings
+	t.Cleanup(func() {
+		config.OIDCIssuerURL, config.OIDCClientID, config.OIDCClientSecret, config.OIDCRedirectURL = issuer, clientID, secret, redirect
+		config.OIDCGroupsClaim, config.OIDCGroupMappings = groupsClaim, mappings
+	})
+	config.OIDCIssuerURL = idp.server.URL
+	config.OIDCClientID = testClientID
+	config.OIDCClientSecret = "secret"
+	config.OIDCRedirectURL = "http://localhost:8081/api/v1/auth/oidc/callback"
+	config.OIDCGroupsClaim = "groups"
+	config.OIDCGroupMappings = []config.OIDCGroupMapping{
+		{Group: "casa-managers", ShopID: 1, Role: string(models.RoleSuperAdmin)},
+		{Group: "casa-staff", ShopID: 1, Role: string(models.RoleAdmin)},
+	}
+
+	return idp
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.Challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.Claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// signIn runs a whole login: it starts it on svc, has the IdP authorize it
+// with the given claims and completes it with the returned code
+func (idp *stubIdP) signIn(t *testing.T, svc OIDCService, claims jwt.MapClaims) (*models.User, string, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	authURL, state, err := svc.AuthCodeURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if query.Get("state") != state {
+		t.Fatalf("state %q in the IdP URL, %q returned", query.Get("state"), state)
+	}
+
+	now := time.Now()
+	claims["iss"] = idp.server.URL
+	claims["aud"] = testClientID
+	claims["sub"] = "subject-" + state
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Minute).Unix()
+	claims["nonce"] = query.Get("nonce")
+
+	idp.mu.Lock()
+	idp.codes["code-"+state] = stubGrant{Challenge: query.Get("code_challenge"), Claims: claims}
+	idp.mu.Unlock()
+
+	return svc.Exchange(ctx, "code-"+state, state)
+}
+
+func newOIDCTestServices(t *testing.T) (OIDCService, UserService, MembershipService) {
+	t.Helper()
+	membershipSvc := NewMembershipService()
+	userSvc := NewUserService(membershipSvc, NewLoginAttemptService())
+	oidcSvc, err := NewOIDCService(userSvc, membershipSvc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oidcSvc, userSvc, membershipSvc
+}
+
+func TestOIDCProvisionsMappedUser(t *testing.T) {
+	idp := newStubIdP(t)
+	oidcSvc, _, membershipSvc := newOIDCTestServices(t)
+
+	user, token, err := idp.signIn(t, oidcSvc, jwt.MapClaims{
+		"email":          "sso@shop1.com",
+		"email_verified": true,
+		"name":           "Single Sign-On",
+		"groups":         []string{"casa-staff"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("no token issued")
+	}
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID || claims.ShopID != 1 || claims.Role != models.RoleAdmin {
+		t.Errorf("token claims = user %d shop %d role %s, want user %d shop 1 role Admin", claims.UserID, claims.ShopID, claims.Role, user.ID)
+	}
+	if _, err := membershipSvc.Get(user.ID, 1); err != nil {
+		t.Errorf("membership not created: %v", err)
+	}
+}
+
+func TestOIDCRequiresVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"unverified", jwt.MapClaims{"email": "super@shop1.com", "email_verified": false, "groups": []string{"casa-managers"}}},
+		{"verification missing", jwt.MapClaims{"email": "super@shop1.com", "groups": []string{"casa-managers"}}},
+		{"verification not a boolean", jwt.MapClaims{"email": "super@shop1.com", "email_verified": "true", "groups": []string{"casa-managers"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newStubIdP(t)
+			oidcSvc, _, _ := newOIDCTestServices(t)
+
+			user, token, err := idp.signIn(t, oidcSvc, tt.claims)
+			if err == nil || token != "" || user != nil {
+				t.Fatalf("signed in as %v (token %q), want an error", user, token)
+			}
+		})
+	}
+}
+
+func TestOIDCEnforcesLocalTOTP(t *testing.T) {
+	idp := newStubIdP(t)
+	oidcSvc, userSvc, _ := newOIDCTestServices(t)
+
+	// super@shop1.com (ID 1) turns on TOTP locally
+	secret, _, err := userSvc.BeginTOTPEnrollment(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userSvc.EnableTOTP(1, code); err != nil {
+		t.Fatal(err)
+	}
+
+	// The IdP's own multi-factor authentication does not replace it
+	user, token, err := idp.signIn(t, oidcSvc, jwt.MapClaims{
+		"email":          "super@shop1.com",
+		"email_verified": true,
+		"amr":            []string{"pwd", "mfa"},
+		"groups":         []string{"casa-managers"},
+	})
+	if !errors.Is(err, ErrTwoFactorRequired) {
+		t.Fatalf("err = %v, want ErrTwoFactorRequired", err)
+	}
+	if token != "" {
+		t.Error("token issued before the second factor")
+	}
+	if user == nil || user.ID != 1 {
+		t.Errorf("user = %v, want user 1 for the challenge", user)
+	}
+}
+
+func TestOIDCRejectsUnknownState(t *testing.T) {
+	newStubIdP(t)
+	oidcSvc, _, _ := newOIDCTestServices(t)
+
+	if _, _, err := oidcSvc.Exchange(context.Background(), "code", "forged"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("err = %v, want ErrInvalidInput", err)
+	}
+}
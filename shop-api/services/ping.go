@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pingStore reports whether a store's lock can be taken for reading before ctx
+// is done. A store stuck behind a long write makes the instance unready.
+func pingStore(ctx context.Context, mu *sync.RWMutex) error {
+	for !mu.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	mu.RUnlock()
+	return nil
+}
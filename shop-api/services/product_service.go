@@ -1,28 +1,37 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"log/slog"
+	"shop-api/events"
 	"shop-api/models"
+	"shop-api/tracing"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ProductService interface {
-	GetAll(shopID int) []models.Product
-	GetByID(id int) (*models.Product, error)
-	GetPublicProducts(shopID int) []models.Product
-	Create(product models.Product) (*models.Product, error)
-	Update(id int, product models.Product) (*models.Product, error)
-	Delete(id int) error
+	GetAll(ctx context.Context, shopID int) []models.Product
+	GetByID(ctx context.Context, id int) (*models.Product, error)
+	GetPublicProducts(ctx context.Context, shopID int) []models.Product
+	Create(ctx context.Context, product models.Product) (*models.Product, error)
+	Update(ctx context.Context, id int, product models.Product, expectedVersion int) (*models.Product, error)
+	Delete(ctx context.Context, id int, expectedVersion int) error
+	TakeStock(ctx context.Context, shopID, productID, quantity int) (*models.Product, error)
+	ReturnStock(ctx context.Context, productID, quantity int) error
+	Ping(ctx context.Context) error // whether the store answers, for readiness
 }
 
 type ProductServiceImpl struct {
 	products []models.Product
 	nextID   int
+	events   events.Publisher
 	mu       sync.RWMutex
 }
 
-func NewProductService() ProductService {
+func NewProductService(publisher events.Publisher) ProductService {
 	return &ProductServiceImpl{
 		products: []models.Product{
 			{
@@ -35,6 +44,7 @@ func NewProductService() ProductService {
 				Stock:         15,
 				ImageURL:      "https://example.com/iphone14.jpg",
 				ShopID:        1,
+				Version:       1,
 				CreatedAt:     time.Now(),
 			},
 			{
@@ -47,6 +57,7 @@ func NewProductService() ProductService {
 				Stock:         8,
 				ImageURL:      "https://example.com/macbook.jpg",
 				ShopID:        1,
+				Version:       1,
 				CreatedAt:     time.Now(),
 			},
 			{
@@ -59,6 +70,7 @@ func NewProductService() ProductService {
 				Stock:         20,
 				ImageURL:      "https://example.com/samsung.jpg",
 				ShopID:        2,
+				Version:       1,
 				CreatedAt:     time.Now(),
 			},
 			{
@@ -71,27 +83,29 @@ func NewProductService() ProductService {
 				Stock:         3,
 				ImageURL:      "https://example.com/airpods.jpg",
 				ShopID:        1,
+				Version:       1,
 				CreatedAt:     time.Now(),
 			},
 		},
 		nextID: 5,
+		events: publisher,
 	}
 }
 
-func (s *ProductServiceImpl) GetAll(shopID int) []models.Product {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *ProductServiceImpl) GetAll(ctx context.Context, shopID int) []models.Product {
+	ctx, span := tracing.Start(ctx, "ProductService.GetAll", attribute.Int("shop.id", shopID))
+	defer span.End()
 
-	var products []models.Product
-	for _, product := range s.products {
-		if product.ShopID == shopID {
-			products = append(products, product)
-		}
-	}
-	return products
+	return s.selectByShop(ctx, shopID)
 }
 
-func (s *ProductServiceImpl) GetByID(id int) (*models.Product, error) {
+func (s *ProductServiceImpl) GetByID(ctx context.Context, id int) (*models.Product, error) {
+	ctx, span := tracing.Start(ctx, "ProductService.GetByID", attribute.Int("product.id", id))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "select")
+	defer query.End()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -100,10 +114,21 @@ func (s *ProductServiceImpl) GetByID(id int) (*models.Product, error) {
 			return &product, nil
 		}
 	}
-	return nil, errors.New("product not found")
+	return nil, tracing.Error(span, newError(ErrNotFound, "product not found"))
+}
+
+func (s *ProductServiceImpl) GetPublicProducts(ctx context.Context, shopID int) []models.Product {
+	ctx, span := tracing.Start(ctx, "ProductService.GetPublicProducts", attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	return s.selectByShop(ctx, shopID)
 }
 
-func (s *ProductServiceImpl) GetPublicProducts(shopID int) []models.Product {
+// selectByShop returns copies of a shop's products
+func (s *ProductServiceImpl) selectByShop(ctx context.Context, shopID int) []models.Product {
+	_, query := tracing.Storage(ctx, "products", "select")
+	defer query.End()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -113,47 +138,173 @@ func (s *ProductServiceImpl) GetPublicProducts(shopID int) []models.Product {
 			products = append(products, product)
 		}
 	}
+	query.SetAttributes(attribute.Int("db.response.returned_rows", len(products)))
 	return products
 }
 
-func (s *ProductServiceImpl) Create(product models.Product) (*models.Product, error) {
+func (s *ProductServiceImpl) Create(ctx context.Context, product models.Product) (*models.Product, error) {
+	ctx, span := tracing.Start(ctx, "ProductService.Create", attribute.Int("shop.id", product.ShopID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "insert")
+	defer query.End()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	product.ID = s.nextID
+	product.Version = 1
 	product.CreatedAt = time.Now()
+	if err := s.events.Publish(ctx, product.ShopID, events.ProductCreated{
+		ProductID:    product.ID,
+		Name:         product.Name,
+		Category:     product.Category,
+		SellingPrice: product.SellingPrice,
+		Stock:        product.Stock,
+	}); err != nil {
+		return nil, tracing.Error(span, err)
+	}
 	s.nextID++
 	s.products = append(s.products, product)
+	slog.Debug("product created", "product_id", product.ID, "shop_id", product.ShopID)
 
 	return &product, nil
 }
 
-func (s *ProductServiceImpl) Update(id int, updated models.Product) (*models.Product, error) {
+// Update replaces a product. A non-zero expectedVersion makes the update
+// conditional: it fails with ErrVersionConflict if the product changed since.
+func (s *ProductServiceImpl) Update(ctx context.Context, id int, updated models.Product, expectedVersion int) (*models.Product, error) {
+	ctx, span := tracing.Start(ctx, "ProductService.Update", attribute.Int("product.id", id))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "update")
+	defer query.End()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for i := range s.products {
 		if s.products[i].ID == id {
+			if err := checkVersion("product", s.products[i].Version, expectedVersion); err != nil {
+				return nil, tracing.Error(span, err)
+			}
+
+			if updated.Stock != s.products[i].Stock {
+				if err := s.events.Publish(ctx, s.products[i].ShopID, events.StockChanged{
+					ProductID: id,
+					Before:    s.products[i].Stock,
+					After:     updated.Stock,
+					Reason:    "update",
+				}); err != nil {
+					return nil, tracing.Error(span, err)
+				}
+			}
+
 			// Keep the original ID, ShopID, and CreatedAt
 			updated.ID = s.products[i].ID
+			updated.Version = s.products[i].Version + 1
 			updated.ShopID = s.products[i].ShopID
 			updated.CreatedAt = s.products[i].CreatedAt
 			s.products[i] = updated
 			return &s.products[i], nil
 		}
 	}
-	return nil, errors.New("product not found")
+	return nil, tracing.Error(span, newError(ErrNotFound, "product not found"))
 }
 
-func (s *ProductServiceImpl) Delete(id int) error {
+func (s *ProductServiceImpl) Delete(ctx context.Context, id int, expectedVersion int) error {
+	ctx, span := tracing.Start(ctx, "ProductService.Delete", attribute.Int("product.id", id))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "delete")
+	defer query.End()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for i, product := range s.products {
 		if product.ID == id {
+			if err := checkVersion("product", product.Version, expectedVersion); err != nil {
+				return tracing.Error(span, err)
+			}
 			s.products = append(s.products[:i], s.products[i+1:]...)
+			slog.Debug("product deleted", "product_id", id, "shop_id", product.ShopID)
+			return nil
+		}
+	}
+	return tracing.Error(span, newError(ErrNotFound, "product not found"))
+}
+
+// TakeStock takes a sold quantity out of a product of the shop. It fails with
+// ErrInsufficientStock rather than going below zero, under the same lock as
+// the check, so concurrent sales cannot oversell.
+func (s *ProductServiceImpl) TakeStock(ctx context.Context, shopID, productID, quantity int) (*models.Product, error) {
+	ctx, span := tracing.Start(ctx, "ProductService.TakeStock", attribute.Int("product.id", productID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "update")
+	defer query.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.products {
+		if s.products[i].ID == productID {
+			if s.products[i].ShopID != shopID {
+				return nil, tracing.Error(span, newError(ErrForbiddenTenant, "product does not belong to this shop"))
+			}
+			if s.products[i].Stock < quantity {
+				return nil, tracing.Error(span, ErrInsufficientStock)
+			}
+			if err := s.changeStock(ctx, i, s.products[i].Stock-quantity, "sale"); err != nil {
+				return nil, tracing.Error(span, err)
+			}
+			product := s.products[i]
+			return &product, nil
+		}
+	}
+	return nil, tracing.Error(span, newError(ErrNotFound, "product not found"))
+}
+
+// ReturnStock puts back a quantity taken by TakeStock for a sale that could
+// not be recorded after all
+func (s *ProductServiceImpl) ReturnStock(ctx context.Context, productID, quantity int) error {
+	ctx, span := tracing.Start(ctx, "ProductService.ReturnStock", attribute.Int("product.id", productID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "products", "update")
+	defer query.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.products {
+		if s.products[i].ID == productID {
+			if err := s.changeStock(ctx, i, s.products[i].Stock+quantity, "sale cancelled"); err != nil {
+				return tracing.Error(span, err)
+			}
 			return nil
 		}
 	}
-	return errors.New("product not found")
+	return tracing.Error(span, newError(ErrNotFound, "product not found"))
+}
+
+// changeStock sets the stock of s.products[i] and publishes the change; the
+// caller holds the lock
+func (s *ProductServiceImpl) changeStock(ctx context.Context, i, stock int, reason string) error {
+	if err := s.events.Publish(ctx, s.products[i].ShopID, events.StockChanged{
+		ProductID: s.products[i].ID,
+		Before:    s.products[i].Stock,
+		After:     stock,
+		Reason:    reason,
+	}); err != nil {
+		return err
+	}
+	s.products[i].Stock = stock
+	s.products[i].Version++
+	return nil
+}
+
+func (s *ProductServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
 }
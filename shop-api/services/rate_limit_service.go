@@ -0,0 +1,86 @@
+package services
+
+import (
+	"math"
+	"shop-api/config"
+	"sync"
+	"time"
+)
+
+// RateLimitDecision is the state of a client's bucket after taking a token
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int           // bucket size
+	Remaining  int           // whole tokens left
+	RetryAfter time.Duration // until the next token, when refused
+	Reset      time.Duration // until the bucket is full again
+}
+
+type RateLimitService interface {
+	// Take spends one token from the bucket of key under limit
+	Take(key string, limit config.RateLimit) (RateLimitDecision, error)
+}
+
+// tokenBucket holds the tokens left at the time of its last update
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+	Full    time.Time // from then on, the bucket is the same as a new one
+}
+
+// RateLimitServiceImpl keeps the buckets in memory, so every instance limits
+// its clients separately
+type RateLimitServiceImpl struct {
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+func NewRateLimitService() RateLimitService {
+	return &RateLimitServiceImpl{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (s *RateLimitServiceImpl) Take(key string, limit config.RateLimit) (RateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	perToken := limit.Period / time.Duration(limit.Requests)
+	burst := float64(limit.Burst)
+
+	key = limit.Group + ":" + key
+	bucket, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= pruneThreshold {
+			s.pruneFull(now)
+		}
+		bucket = &tokenBucket{Tokens: burst, Updated: now}
+		s.buckets[key] = bucket
+	}
+
+	// Refill for the time elapsed since the last request
+	bucket.Tokens = min(burst, bucket.Tokens+float64(now.Sub(bucket.Updated))/float64(perToken))
+	bucket.Updated = now
+
+	decision := RateLimitDecision{Limit: limit.Burst}
+	if bucket.Tokens >= 1 {
+		bucket.Tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = time.Duration((1 - bucket.Tokens) * float64(perToken))
+	}
+	decision.Remaining = int(math.Floor(bucket.Tokens))
+	decision.Reset = time.Duration((burst - bucket.Tokens) * float64(perToken))
+	bucket.Full = now.Add(decision.Reset)
+	return decision, nil
+}
+
+// pruneFull drops the buckets that have refilled completely
+func (s *RateLimitServiceImpl) pruneFull(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.Full) {
+			delete(s.buckets, key)
+		}
+	}
+}
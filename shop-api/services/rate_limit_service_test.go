@@ -0,0 +1,95 @@
+package services
+
+import (
+	"shop-api/config"
+	"testing"
+	"time"
+)
+
+// testRateLimit refills one token per second, up to 3
+var testRateLimit = config.RateLimit{Group: "test", Requests: 60, Period: time.Minute, Burst: 3}
+
+// elapse moves the bucket of key back in time, as if d had passed since its last request
+func elapse(limits RateLimitService, key string, d time.Duration) {
+	bucket := limits.(*RateLimitServiceImpl).buckets[testRateLimit.Group+":"+key]
+	bucket.Updated = bucket.Updated.Add(-d)
+}
+
+func take(t *testing.T, limits RateLimitService, key string) RateLimitDecision {
+	t.Helper()
+	decision, err := limits.Take(key, testRateLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decision
+}
+
+// near reports whether d is within 50ms below want, the time the test itself takes
+func near(d, want time.Duration) bool {
+	return d <= want && d > want-50*time.Millisecond
+}
+
+func TestTokenBucketAllowsABurst(t *testing.T) {
+	limits := NewRateLimitService()
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		decision := take(t, limits, "client")
+		if !decision.Allowed || decision.Remaining != remaining || decision.Limit != 3 {
+			t.Fatalf("decision = %+v, want allowed with %d remaining of 3", decision, remaining)
+		}
+	}
+
+	decision := take(t, limits, "client")
+	if decision.Allowed {
+		t.Fatal("allowed past the burst")
+	}
+	if !near(decision.RetryAfter, time.Second) {
+		t.Errorf("retry after %v, want about the 1s a token takes", decision.RetryAfter)
+	}
+	if !near(decision.Reset, 3*time.Second) {
+		t.Errorf("reset in %v, want about 3s", decision.Reset)
+	}
+
+	// Other clients and other route groups have their own buckets
+	if !take(t, limits, "other client").Allowed {
+		t.Error("another client was refused")
+	}
+	otherGroup := testRateLimit
+	otherGroup.Group = "other"
+	if decision, _ := limits.Take("client", otherGroup); !decision.Allowed {
+		t.Error("the client was refused in another route group")
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	limits := NewRateLimitService()
+	for range 3 {
+		take(t, limits, "client")
+	}
+
+	// Half a token is not enough
+	elapse(limits, "client", 500*time.Millisecond)
+	if decision := take(t, limits, "client"); decision.Allowed || !near(decision.RetryAfter, 500*time.Millisecond) {
+		t.Fatalf("decision = %+v, want refused for another 500ms", decision)
+	}
+
+	// One token per second
+	elapse(limits, "client", 500*time.Millisecond)
+	if decision := take(t, limits, "client"); !decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf("decision = %+v after a second, want one token", decision)
+	}
+	if take(t, limits, "client").Allowed {
+		t.Fatal("allowed twice after one second")
+	}
+
+	// An idle client gets its burst back, and no more
+	elapse(limits, "client", time.Hour)
+	for i := range 3 {
+		if !take(t, limits, "client").Allowed {
+			t.Fatalf("request %d refused after an hour", i+1)
+		}
+	}
+	if take(t, limits, "client").Allowed {
+		t.Error("the bucket refilled past its burst")
+	}
+}
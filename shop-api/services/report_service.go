@@ -0,0 +1,141 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ReportService keeps reports that are built from domain events rather than
+// read from the other stores. Events can be delivered twice: each one is
+// applied once, by its ID.
+type ReportService interface {
+	ApplySale(ctx context.Context, event events.Event, sale events.SaleRecorded) error
+	ApplyStockChange(ctx context.Context, event events.Event, change events.StockChanged) error
+	DailySales(ctx context.Context, shopID int) []models.DailySales        // newest day first
+	LowStockAlerts(ctx context.Context, shopID int) []models.LowStockAlert // oldest first
+	Ping(ctx context.Context) error                                        // whether the store answers, for readiness
+}
+
+// stockState is the last known stock of a product
+type stockState struct {
+	shopID    int
+	stock     int
+	changedAt time.Time
+	alert     *models.LowStockAlert // while the stock is low
+}
+
+type ReportServiceImpl struct {
+	sales   map[int]map[string]*models.DailySales // shop ID → date → totals
+	stock   map[int]*stockState                   // product ID → stock
+	applied map[string]bool                       // IDs of the events applied
+	mu      sync.RWMutex
+}
+
+func NewReportService() ReportService {
+	return &ReportServiceImpl{
+		sales:   map[int]map[string]*models.DailySales{},
+		stock:   map[int]*stockState{},
+		applied: map[string]bool{},
+	}
+}
+
+// ApplySale adds a recorded sale to the totals of its day
+func (s *ReportServiceImpl) ApplySale(ctx context.Context, event events.Event, sale events.SaleRecorded) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.applied[event.ID] {
+		return nil
+	}
+	s.applied[event.ID] = true
+
+	days, ok := s.sales[event.ShopID]
+	if !ok {
+		days = map[string]*models.DailySales{}
+		s.sales[event.ShopID] = days
+	}
+	date := event.OccurredAt.Format(time.DateOnly)
+	day, ok := days[date]
+	if !ok {
+		day = &models.DailySales{Date: date}
+		days[date] = day
+	}
+	day.Sales++
+	day.Quantity += sale.Quantity
+	day.Amount += sale.Amount
+	return nil
+}
+
+// ApplyStockChange raises a low-stock alert when a product's stock falls
+// below config.LowStockThreshold, and clears it once the stock is back up.
+// A change older than the last one applied to the product (a retried
+// delivery) is ignored.
+func (s *ReportServiceImpl) ApplyStockChange(ctx context.Context, event events.Event, change events.StockChanged) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.applied[event.ID] {
+		return nil
+	}
+	s.applied[event.ID] = true
+
+	state, ok := s.stock[change.ProductID]
+	if !ok {
+		state = &stockState{shopID: event.ShopID}
+		s.stock[change.ProductID] = state
+	} else if event.OccurredAt.Before(state.changedAt) {
+		return nil
+	}
+	state.stock = change.After
+	state.changedAt = event.OccurredAt
+
+	switch {
+	case change.After >= config.LowStockThreshold:
+		state.alert = nil
+	case state.alert == nil:
+		state.alert = &models.LowStockAlert{ProductID: change.ProductID, Stock: change.After, RaisedAt: event.OccurredAt}
+	default:
+		state.alert.Stock = change.After
+	}
+	return nil
+}
+
+func (s *ReportServiceImpl) DailySales(ctx context.Context, shopID int) []models.DailySales {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	days := []models.DailySales{}
+	for _, day := range s.sales[shopID] {
+		days = append(days, *day)
+	}
+	slices.SortFunc(days, func(a, b models.DailySales) int {
+		return cmp.Compare(b.Date, a.Date)
+	})
+	return days
+}
+
+func (s *ReportServiceImpl) LowStockAlerts(ctx context.Context, shopID int) []models.LowStockAlert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	alerts := []models.LowStockAlert{}
+	for _, state := range s.stock {
+		if state.shopID == shopID && state.alert != nil {
+			alerts = append(alerts, *state.alert)
+		}
+	}
+	slices.SortFunc(alerts, func(a, b models.LowStockAlert) int {
+		return a.RaisedAt.Compare(b.RaisedAt)
+	})
+	return alerts
+}
+
+func (s *ReportServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
+}
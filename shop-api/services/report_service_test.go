@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"shop-api/events"
+	"shop-api/ledger"
+	"shop-api/models"
+	"testing"
+	"time"
+)
+
+// eventually polls check until it holds, as events are delivered in the background
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not reached in time", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReportsFollowSales(t *testing.T) {
+	ctx := context.Background()
+	bus := newTestBus(t)
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	productSvc := NewProductService(bus)
+	transactionSvc := NewTransactionService(productSvc, signer, bus)
+	reportSvc := NewReportService()
+	events.On(bus, "reports", reportSvc.ApplySale)
+	events.On(bus, "low-stock", reportSvc.ApplyStockChange)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	go bus.Run(runCtx)
+
+	product, err := productSvc.Create(ctx, models.Product{Name: "Cable", SellingPrice: 50, Stock: 6, ShopID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := transactionSvc.Create(ctx, models.Transaction{Type: models.TransactionSale, ProductID: &product.ID, Quantity: 1, Amount: 50, ShopID: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eventually(t, "sales reported", func() bool {
+		days := reportSvc.DailySales(ctx, 2)
+		return len(days) == 1 && days[0].Sales == 2
+	})
+	day := reportSvc.DailySales(ctx, 2)[0]
+	if day.Date != time.Now().Format(time.DateOnly) || day.Quantity != 2 || day.Amount != 100 {
+		t.Errorf("daily sales = %+v, want today's 2 sales of 100", day)
+	}
+
+	// The second sale took the stock from 5 to 4, under the threshold
+	eventually(t, "low stock raised", func() bool {
+		return len(reportSvc.LowStockAlerts(ctx, 2)) == 1
+	})
+	if alert := reportSvc.LowStockAlerts(ctx, 2)[0]; alert.ProductID != product.ID || alert.Stock != 4 {
+		t.Errorf("alert = %+v, want product %d at 4", alert, product.ID)
+	}
+
+	// Restocking clears it
+	restocked := *product
+	restocked.Stock = 20
+	if _, err := productSvc.Update(ctx, product.ID, restocked, 0); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "low stock cleared", func() bool {
+		return len(reportSvc.LowStockAlerts(ctx, 2)) == 0
+	})
+}
+
+func TestReportsApplyEachEventOnce(t *testing.T) {
+	ctx := context.Background()
+	reportSvc := NewReportService()
+
+	event := events.Event{ID: "sale-1", Type: events.TypeSaleRecorded, ShopID: 1, OccurredAt: time.Now()}
+	for range 2 {
+		if err := reportSvc.ApplySale(ctx, event, events.SaleRecorded{TransactionID: 9, Quantity: 3, Amount: 30}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	days := reportSvc.DailySales(ctx, 1)
+	if len(days) != 1 || days[0].Sales != 1 || days[0].Amount != 30 {
+		t.Errorf("daily sales = %+v, want one sale of 30", days)
+	}
+
+	// A retried delivery of an older change does not undo a newer one
+	now := time.Now()
+	low := events.Event{ID: "stock-2", ShopID: 1, OccurredAt: now}
+	high := events.Event{ID: "stock-1", ShopID: 1, OccurredAt: now.Add(-time.Minute)}
+	reportSvc.ApplyStockChange(ctx, low, events.StockChanged{ProductID: 4, Before: 8, After: 2})
+	reportSvc.ApplyStockChange(ctx, high, events.StockChanged{ProductID: 4, Before: 3, After: 8})
+	if alerts := reportSvc.LowStockAlerts(ctx, 1); len(alerts) != 1 || alerts[0].Stock != 2 {
+		t.Errorf("alerts = %+v, want product 4 at 2", alerts)
+	}
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"log/slog"
+	"shop-api/events"
 	"shop-api/models"
 	"sync"
 	"time"
@@ -11,16 +13,19 @@ type ShopService interface {
 	GetByID(id int) (*models.Shop, error)
 	GetAll() []models.Shop
 	Create(shop models.Shop) (*models.Shop, error)
-	UpdateWhatsApp(shopID int, whatsappNumber string) error
+	UpdateWhatsApp(ctx context.Context, shopID int, whatsappNumber string, expectedVersion int) error
+	SetRequireTwoFactor(ctx context.Context, shopID int, required bool, expectedVersion int) error
+	Ping(ctx context.Context) error // whether the store answers, for readiness
 }
 
 type ShopServiceImpl struct {
 	shops  []models.Shop
 	nextID int
+	events events.Publisher
 	mu     sync.RWMutex
 }
 
-func NewShopService() ShopService {
+func NewShopService(publisher events.Publisher) ShopService {
 	return &ShopServiceImpl{
 		shops: []models.Shop{
 			{
@@ -28,6 +33,7 @@ func NewShopService() ShopService {
 				Name:           "TechStore Casablanca",
 				Active:         true,
 				WhatsAppNumber: "212600000001",
+				Version:        1,
 				CreatedAt:      time.Now(),
 			},
 			{
@@ -35,10 +41,12 @@ func NewShopService() ShopService {
 				Name:           "ElectroShop Rabat",
 				Active:         true,
 				WhatsAppNumber: "212600000002",
+				Version:        1,
 				CreatedAt:      time.Now(),
 			},
 		},
 		nextID: 3,
+		events: publisher,
 	}
 }
 
@@ -51,7 +59,7 @@ func (s *ShopServiceImpl) GetByID(id int) (*models.Shop, error) {
 			return &shop, nil
 		}
 	}
-	return nil, errors.New("shop not found")
+	return nil, newError(ErrNotFound, "shop not found")
 }
 
 func (s *ShopServiceImpl) GetAll() []models.Shop {
@@ -66,6 +74,7 @@ func (s *ShopServiceImpl) Create(shop models.Shop) (*models.Shop, error) {
 	defer s.mu.Unlock()
 
 	shop.ID = s.nextID
+	shop.Version = 1
 	shop.CreatedAt = time.Now()
 	s.nextID++
 	s.shops = append(s.shops, shop)
@@ -73,15 +82,61 @@ func (s *ShopServiceImpl) Create(shop models.Shop) (*models.Shop, error) {
 	return &shop, nil
 }
 
-func (s *ShopServiceImpl) UpdateWhatsApp(shopID int, whatsappNumber string) error {
+func (s *ShopServiceImpl) UpdateWhatsApp(ctx context.Context, shopID int, whatsappNumber string, expectedVersion int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for i := range s.shops {
 		if s.shops[i].ID == shopID {
+			if err := checkVersion("shop", s.shops[i].Version, expectedVersion); err != nil {
+				return err
+			}
+			updated := s.shops[i]
+			updated.WhatsAppNumber = whatsappNumber
+			if err := s.publishUpdate(ctx, updated); err != nil {
+				return err
+			}
 			s.shops[i].WhatsAppNumber = whatsappNumber
+			s.shops[i].Version++
+			slog.Info("shop whatsapp number changed", "shop_id", shopID)
 			return nil
 		}
 	}
-	return errors.New("shop not found")
+	return newError(ErrNotFound, "shop not found")
+}
+
+func (s *ShopServiceImpl) SetRequireTwoFactor(ctx context.Context, shopID int, required bool, expectedVersion int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.shops {
+		if s.shops[i].ID == shopID {
+			if err := checkVersion("shop", s.shops[i].Version, expectedVersion); err != nil {
+				return err
+			}
+			updated := s.shops[i]
+			updated.RequireTwoFactor = required
+			if err := s.publishUpdate(ctx, updated); err != nil {
+				return err
+			}
+			s.shops[i].RequireTwoFactor = required
+			s.shops[i].Version++
+			slog.Info("shop two-factor policy changed", "shop_id", shopID, "required", required)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "shop not found")
+}
+
+// publishUpdate announces the settings a shop is about to have; the caller holds the lock
+func (s *ShopServiceImpl) publishUpdate(ctx context.Context, shop models.Shop) error {
+	return s.events.Publish(ctx, shop.ID, events.ShopUpdated{
+		WhatsAppNumber:   shop.WhatsAppNumber,
+		RequireTwoFactor: shop.RequireTwoFactor,
+		Version:          shop.Version + 1,
+	})
+}
+
+func (s *ShopServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
 }
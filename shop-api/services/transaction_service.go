@@ -1,16 +1,29 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"shop-api/config"
+	"shop-api/events"
+	"shop-api/ledger"
 	"shop-api/models"
+	"shop-api/tracing"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TransactionService interface {
-	GetAll(shopID int) []models.Transaction
-	Create(transaction models.Transaction) (*models.Transaction, error)
-	GetDashboard(shopID int) (*DashboardStats, error)
+	GetAll(ctx context.Context, shopID int) []models.Transaction
+	Create(ctx context.Context, transaction models.Transaction) (*models.Transaction, error)
+	GetDashboard(ctx context.Context, shopID int) (*DashboardStats, error)
+	VerifyChain(ctx context.Context, shopID int) ledger.Verification
+	Checkpoint(ctx context.Context, shopID int) *models.ChainCheckpoint
+	CheckpointAll(ctx context.Context) []models.ChainCheckpoint // shops whose chain moved since their last checkpoint
+	ExportCheckpoints(ctx context.Context, shopID int) ledger.CheckpointExport
+	Ping(ctx context.Context) error // whether the store answers, for readiness
 }
 
 type DashboardStats struct {
@@ -24,47 +37,82 @@ type DashboardStats struct {
 }
 
 type TransactionServiceImpl struct {
-	transactions []models.Transaction
-	nextID       int
-	mu           sync.RWMutex
-	productSvc   ProductService
-}
-
-func NewTransactionService(productSvc ProductService) TransactionService {
-	return &TransactionServiceImpl{
-		transactions: []models.Transaction{
-			{
-				ID:        1,
-				Type:      models.TransactionSale,
-				ProductID: intPtr(1),
-				Quantity:  2,
-				Amount:    20000,
-				ShopID:    1,
-				CreatedAt: time.Now().AddDate(0, 0, -5),
-			},
-			{
-				ID:        2,
-				Type:      models.TransactionExpense,
-				ProductID: nil,
-				Quantity:  1,
-				Amount:    5000,
-				ShopID:    1,
-				CreatedAt: time.Now().AddDate(0, 0, -3),
-			},
+	transactions     []models.Transaction
+	nextID           int
+	heads            map[int]string // shop ID → hash of its last transaction
+	checkpoints      []models.ChainCheckpoint
+	nextCheckpointID int
+	signer           *ledger.Signer
+	events           events.Publisher
+	mu               sync.RWMutex
+	productSvc       ProductService
+}
+
+func NewTransactionService(productSvc ProductService, signer *ledger.Signer, publisher events.Publisher) TransactionService {
+	s := &TransactionServiceImpl{
+		nextID:           3,
+		heads:            map[int]string{},
+		nextCheckpointID: 1,
+		signer:           signer,
+		events:           publisher,
+		productSvc:       productSvc,
+	}
+	// Seed transactions are chained like recorded ones
+	for _, transaction := range []models.Transaction{
+		{
+			ID:        1,
+			Type:      models.TransactionSale,
+			ProductID: intPtr(1),
+			Quantity:  2,
+			Amount:    20000,
+			ShopID:    1,
+			CreatedAt: time.Now().AddDate(0, 0, -5),
+		},
+		{
+			ID:        2,
+			Type:      models.TransactionExpense,
+			ProductID: nil,
+			Quantity:  1,
+			Amount:    5000,
+			ShopID:    1,
+			CreatedAt: time.Now().AddDate(0, 0, -3),
 		},
-		nextID:     3,
-		productSvc: productSvc,
+	} {
+		s.chain(&transaction)
+		s.transactions = append(s.transactions, transaction)
 	}
+	return s
+}
+
+// chain links a new transaction to the last one of its shop; the caller holds the lock
+func (s *TransactionServiceImpl) chain(transaction *models.Transaction) {
+	transaction.PrevHash = ledger.GenesisHash
+	if head, ok := s.heads[transaction.ShopID]; ok {
+		transaction.PrevHash = head
+	}
+	transaction.Hash = ledger.Hash(*transaction)
+	s.heads[transaction.ShopID] = transaction.Hash
 }
 
 func intPtr(i int) *int {
 	return &i
 }
 
-func (s *TransactionServiceImpl) GetAll(shopID int) []models.Transaction {
+func (s *TransactionServiceImpl) GetAll(ctx context.Context, shopID int) []models.Transaction {
+	ctx, span := tracing.Start(ctx, "TransactionService.GetAll", attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "transactions", "select")
+	defer query.End()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.shopTransactions(shopID)
+}
+
+// shopTransactions returns a shop's transactions in chain order; the caller holds the lock
+func (s *TransactionServiceImpl) shopTransactions(shopID int) []models.Transaction {
 	var transactions []models.Transaction
 	for _, transaction := range s.transactions {
 		if transaction.ShopID == shopID {
@@ -74,54 +122,78 @@ func (s *TransactionServiceImpl) GetAll(shopID int) []models.Transaction {
 	return transactions
 }
 
-func (s *TransactionServiceImpl) Create(transaction models.Transaction) (*models.Transaction, error) {
+func (s *TransactionServiceImpl) Create(ctx context.Context, transaction models.Transaction) (*models.Transaction, error) {
+	ctx, span := tracing.Start(ctx, "TransactionService.Create",
+		attribute.Int("shop.id", transaction.ShopID), attribute.String("transaction.type", string(transaction.Type)))
+	defer span.End()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Validate product exists and belongs to the same shop
-	if transaction.ProductID != nil {
-		product, err := s.productSvc.GetByID(*transaction.ProductID)
+	if transaction.ProductID != nil && transaction.Type == models.TransactionSale {
+		// The product service checks the stock and takes the sale out of it
+		// under one lock, so concurrent sales cannot both pass the check
+		if _, err := s.productSvc.TakeStock(ctx, transaction.ShopID, *transaction.ProductID, transaction.Quantity); err != nil {
+			return nil, tracing.Error(span, err)
+		}
+	} else if transaction.ProductID != nil {
+		// Validate product exists and belongs to the same shop
+		product, err := s.productSvc.GetByID(ctx, *transaction.ProductID)
 		if err != nil {
-			return nil, errors.New("product not found")
+			return nil, tracing.Error(span, newError(ErrNotFound, "product not found"))
 		}
 		if product.ShopID != transaction.ShopID {
-			return nil, errors.New("product does not belong to this shop")
-		}
-
-		// For sales, check and update stock
-		if transaction.Type == models.TransactionSale {
-			if product.Stock < transaction.Quantity {
-				return nil, errors.New("insufficient stock")
-			}
-			// Update stock (this is simplified - in a real app, you'd update through the service)
+			return nil, tracing.Error(span, newError(ErrForbiddenTenant, "product does not belong to this shop"))
 		}
 	}
 
+	_, query := tracing.Storage(ctx, "transactions", "insert")
+	defer query.End()
 	transaction.ID = s.nextID
 	transaction.CreatedAt = time.Now()
+	if transaction.Type == models.TransactionSale && transaction.ProductID != nil {
+		if err := s.events.Publish(ctx, transaction.ShopID, events.SaleRecorded{
+			TransactionID: transaction.ID,
+			ProductID:     *transaction.ProductID,
+			Quantity:      transaction.Quantity,
+			Amount:        transaction.Amount,
+		}); err != nil {
+			if returnErr := s.productSvc.ReturnStock(ctx, *transaction.ProductID, transaction.Quantity); returnErr != nil {
+				slog.Error("returning the stock of an unrecorded sale failed", "product_id", *transaction.ProductID, "quantity", transaction.Quantity, "error", returnErr)
+			}
+			return nil, tracing.Error(span, err)
+		}
+	}
 	s.nextID++
+	s.chain(&transaction)
 	s.transactions = append(s.transactions, transaction)
+	slog.Debug("transaction recorded", "transaction_id", transaction.ID, "shop_id", transaction.ShopID, "type", transaction.Type, "amount", transaction.Amount)
 
 	return &transaction, nil
 }
 
-func (s *TransactionServiceImpl) GetDashboard(shopID int) (*DashboardStats, error) {
+func (s *TransactionServiceImpl) GetDashboard(ctx context.Context, shopID int) (*DashboardStats, error) {
+	ctx, span := tracing.Start(ctx, "TransactionService.GetDashboard", attribute.Int("shop.id", shopID))
+	defer span.End()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	stats := &DashboardStats{}
 
 	// Get all products for this shop
-	products := s.productSvc.GetAll(shopID)
+	products := s.productSvc.GetAll(ctx, shopID)
 
-	// Count low stock products (less than 5)
+	// Count low stock products
 	for _, product := range products {
-		if product.Stock < 5 {
+		if product.Stock < config.LowStockThreshold {
 			stats.LowStockCount++
 		}
 	}
 
-	// Calculate sales, expenses, and profit
+	// Calculate sales, expenses, and profit; each sale looks its product up
+	scanCtx, scan := tracing.Start(ctx, "TransactionService.GetDashboard.aggregate")
+	lookups := 0
 	for _, transaction := range s.transactions {
 		if transaction.ShopID == shopID {
 			switch transaction.Type {
@@ -131,7 +203,8 @@ func (s *TransactionServiceImpl) GetDashboard(shopID int) (*DashboardStats, erro
 
 				// Calculate revenue and cost for profit
 				if transaction.ProductID != nil {
-					product, err := s.productSvc.GetByID(*transaction.ProductID)
+					lookups++
+					product, err := s.productSvc.GetByID(scanCtx, *transaction.ProductID)
 					if err == nil {
 						stats.TotalRevenue += float64(transaction.Quantity) * product.SellingPrice
 						stats.TotalCost += float64(transaction.Quantity) * product.PurchasePrice
@@ -143,8 +216,124 @@ func (s *TransactionServiceImpl) GetDashboard(shopID int) (*DashboardStats, erro
 		}
 	}
 
+	scan.SetAttributes(attribute.Int("transactions.scanned", len(s.transactions)), attribute.Int("product.lookups", lookups))
+	scan.End()
+
 	// Calculate net profit (sales revenue - cost - expenses)
 	stats.NetProfit = stats.TotalRevenue - stats.TotalCost - stats.TotalExpenses
 
 	return stats, nil
 }
+
+// VerifyChain recomputes every hash of a shop's chain and checks it against
+// the shop's signed checkpoints
+func (s *TransactionServiceImpl) VerifyChain(ctx context.Context, shopID int) ledger.Verification {
+	ctx, span := tracing.Start(ctx, "TransactionService.VerifyChain", attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "transactions", "select")
+	defer query.End()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	verification := ledger.Verify(shopID, s.shopTransactions(shopID), s.shopCheckpoints(shopID), s.signer.PublicKey())
+	if !verification.Valid {
+		slog.Error("transaction chain broken", "shop_id", shopID, "reason", verification.Break.Reason,
+			"position", verification.Break.Position, "transaction_id", verification.Break.TransactionID)
+	}
+	return verification
+}
+
+// Checkpoint signs the current head of a shop's chain
+func (s *TransactionServiceImpl) Checkpoint(ctx context.Context, shopID int) *models.ChainCheckpoint {
+	ctx, span := tracing.Start(ctx, "TransactionService.Checkpoint", attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "checkpoints", "insert")
+	defer query.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpoint(shopID)
+}
+
+func (s *TransactionServiceImpl) CheckpointAll(ctx context.Context) []models.ChainCheckpoint {
+	ctx, span := tracing.Start(ctx, "TransactionService.CheckpointAll")
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "checkpoints", "insert")
+	defer query.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var created []models.ChainCheckpoint
+	for shopID, head := range s.heads {
+		if checkpoints := s.shopCheckpoints(shopID); len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].Hash == head {
+			continue
+		}
+		created = append(created, *s.checkpoint(shopID))
+	}
+	return created
+}
+
+// checkpoint signs and stores the head of a shop's chain; the caller holds the lock
+func (s *TransactionServiceImpl) checkpoint(shopID int) *models.ChainCheckpoint {
+	checkpoint := models.ChainCheckpoint{
+		ID:        s.nextCheckpointID,
+		ShopID:    shopID,
+		Hash:      ledger.GenesisHash,
+		CreatedAt: time.Now(),
+	}
+	transactions := s.shopTransactions(shopID)
+	if len(transactions) > 0 {
+		last := transactions[len(transactions)-1]
+		checkpoint.TransactionID, checkpoint.Count, checkpoint.Hash = last.ID, len(transactions), last.Hash
+	}
+	s.signer.Sign(&checkpoint)
+
+	s.nextCheckpointID++
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	slog.Info("transaction chain checkpoint signed", "shop_id", shopID, "checkpoint_id", checkpoint.ID, "count", checkpoint.Count)
+	return &checkpoint
+}
+
+// ExportCheckpoints returns a shop's checkpoints with the key that verifies them
+func (s *TransactionServiceImpl) ExportCheckpoints(ctx context.Context, shopID int) ledger.CheckpointExport {
+	ctx, span := tracing.Start(ctx, "TransactionService.ExportCheckpoints", attribute.Int("shop.id", shopID))
+	defer span.End()
+
+	_, query := tracing.Storage(ctx, "checkpoints", "select")
+	defer query.End()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	checkpoints := s.shopCheckpoints(shopID)
+	if checkpoints == nil {
+		checkpoints = []models.ChainCheckpoint{}
+	}
+	return ledger.CheckpointExport{
+		ShopID:      shopID,
+		Algorithm:   ledger.Algorithm,
+		PublicKey:   base64.StdEncoding.EncodeToString(s.signer.PublicKey()),
+		Checkpoints: checkpoints,
+	}
+}
+
+// shopCheckpoints returns a shop's checkpoints, oldest first; the caller holds the lock
+func (s *TransactionServiceImpl) shopCheckpoints(shopID int) []models.ChainCheckpoint {
+	var checkpoints []models.ChainCheckpoint
+	for _, checkpoint := range s.checkpoints {
+		if checkpoint.ShopID == shopID {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	return checkpoints
+}
+
+func (s *TransactionServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"shop-api/events"
+	"shop-api/ledger"
+	"shop-api/models"
+	"sync"
+	"testing"
+)
+
+// newTestBus opens an event bus on an outbox in a temporary directory
+func newTestBus(t *testing.T) *events.Bus {
+	t.Helper()
+
+	bus, err := events.NewBus(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Shutdown(context.Background()) })
+	return bus
+}
+
+func TestConcurrentSalesDoNotOversell(t *testing.T) {
+	ctx := context.Background()
+	bus := newTestBus(t)
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	productSvc := NewProductService(bus)
+	transactionSvc := NewTransactionService(productSvc, signer, bus)
+
+	product, err := productSvc.Create(ctx, models.Product{Name: "Cable", SellingPrice: 50, Stock: 5, ShopID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const buyers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold, refused := 0, 0
+	for range buyers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := transactionSvc.Create(ctx, models.Transaction{
+				Type:      models.TransactionSale,
+				ProductID: &product.ID,
+				Quantity:  1,
+				Amount:    50,
+				ShopID:    1,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				sold++
+			case errors.Is(err, ErrInsufficientStock):
+				refused++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sold != 5 || refused != buyers-5 {
+		t.Errorf("%d sold and %d refused, want 5 and %d", sold, refused, buyers-5)
+	}
+	// The stock is taken when the sale is recorded, not by a later event
+	after, err := productSvc.GetByID(ctx, product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Stock != 0 {
+		t.Errorf("stock = %d, want 0", after.Stock)
+	}
+}
+
+func TestSaleOfAnotherShopsProduct(t *testing.T) {
+	ctx := context.Background()
+	bus := newTestBus(t)
+	signer, err := ledger.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	productSvc := NewProductService(bus)
+	transactionSvc := NewTransactionService(productSvc, signer, bus)
+
+	product, err := productSvc.Create(ctx, models.Product{Name: "Cable", SellingPrice: 50, Stock: 5, ShopID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transactionSvc.Create(ctx, models.Transaction{Type: models.TransactionSale, ProductID: &product.ID, Quantity: 1, Amount: 50, ShopID: 1})
+	if !errors.Is(err, ErrForbiddenTenant) {
+		t.Fatalf("err = %v, want ErrForbiddenTenant", err)
+	}
+	if after, _ := productSvc.GetByID(ctx, product.ID); after.Stock != 5 {
+		t.Errorf("stock = %d, want 5 untouched", after.Stock)
+	}
+}
@@ -1,31 +1,68 @@
 package services
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
+	"log/slog"
+	"shop-api/config"
+	"shop-api/metrics"
 	"shop-api/models"
 	"shop-api/utils"
+	"strings"
 	"sync"
 	"time"
 )
 
 type UserService interface {
 	Register(name, email, password string, role models.Role, shopID int) (*models.User, error)
-	Login(email, password string) (*models.User, string, error)
-	GetByShopID(shopID int) []models.User
+	Login(email, password, ip string) (*models.User, string, error)
 	GetByID(id int) (*models.User, error)
+	GetByEmail(email string) (*models.User, error)
+	SwitchShop(userID, shopID int, twoFactor bool) (*models.User, *models.Membership, string, error)
+	Update(id int, name, email string, expectedVersion int) (*models.User, error)
+	SetActive(id int, active bool, expectedVersion int) error
+	ChangePassword(id int, oldPassword, newPassword string) error
+	CreatePasswordReset(id int) (string, error)
+	ResetPassword(token, newPassword string) error
+	Unlock(id int) error
+	CompleteTwoFactorLogin(userID int, code, ip string) (*models.User, string, error)
+	BeginTOTPEnrollment(id int) (string, string, error)
+	EnableTOTP(id int, code string) ([]string, error)
+	DisableTOTP(id int, password, code string) error
+	CreateExternal(name, email string, shopID int, role models.Role) (*models.User, error)
+	IssueToken(id int, twoFactor bool) (*models.User, string, error)
+	Ping(ctx context.Context) error // whether the store answers, for readiness
+}
+
+// ErrTwoFactorRequired is returned by Login (and IssueToken) when the first
+// factor was accepted but the account still has to present a TOTP or recovery code
+var ErrTwoFactorRequired = errors.New("two-factor authentication required")
+
+// passwordReset is a pending single-use reset, keyed by the token hash
+type passwordReset struct {
+	UserID    int
+	ExpiresAt time.Time
 }
 
 type UserServiceImpl struct {
-	users  []models.User
-	nextID int
-	mu     sync.RWMutex
+	users          []models.User
+	nextID         int
+	mu             sync.RWMutex
+	membershipSvc  MembershipService
+	loginAttempts  LoginAttemptService
+	passwordResets map[string]passwordReset
+	dummyHash      string
 }
 
-func NewUserService() UserService {
+func NewUserService(membershipSvc MembershipService, loginAttempts LoginAttemptService) UserService {
 	// Create some initial users with hashed passwords
 	hashedPassword1, _ := utils.HashPassword("admin123")
 	hashedPassword2, _ := utils.HashPassword("admin123")
 
+	// Compared against on unknown emails so they take as long as a wrong password
+	dummyHash, _ := utils.HashPassword("not-a-real-password")
+
 	return &UserServiceImpl{
 		users: []models.User{
 			{
@@ -35,6 +72,8 @@ func NewUserService() UserService {
 				Password:  hashedPassword1,
 				Role:      models.RoleSuperAdmin,
 				ShopID:    1,
+				Active:    true,
+				Version:   1,
 				CreatedAt: time.Now(),
 			},
 			{
@@ -44,30 +83,40 @@ func NewUserService() UserService {
 				Password:  hashedPassword2,
 				Role:      models.RoleAdmin,
 				ShopID:    1,
+				Active:    true,
+				Version:   1,
 				CreatedAt: time.Now(),
 			},
 		},
-		nextID: 3,
+		nextID:         3,
+		membershipSvc:  membershipSvc,
+		loginAttempts:  loginAttempts,
+		passwordResets: make(map[string]passwordReset),
+		dummyHash:      dummyHash,
 	}
 }
 
 func (s *UserServiceImpl) Register(name, email, password string, role models.Role, shopID int) (*models.User, error) {
+	if err := utils.ValidatePassword(password, email, name); err != nil {
+		return nil, err
+	}
+
+	// Hash password before taking the lock: bcrypt would stall every request
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Check if email already exists
 	for _, user := range s.users {
 		if user.Email == email {
-			return nil, errors.New("email already exists")
+			return nil, newError(ErrConflict, "email already exists")
 		}
 	}
 
-	// Hash password
-	hashedPassword, err := utils.HashPassword(password)
-	if err != nil {
-		return nil, err
-	}
-
 	user := models.User{
 		ID:        s.nextID,
 		Name:      name,
@@ -75,67 +124,586 @@ func (s *UserServiceImpl) Register(name, email, password string, role models.Rol
 		Password:  hashedPassword,
 		Role:      role,
 		ShopID:    shopID,
+		Active:    true,
+		Version:   1,
 		CreatedAt: time.Now(),
 	}
 
 	s.nextID++
 	s.users = append(s.users, user)
 
+	// The registration shop becomes the user's first membership
+	if _, err := s.membershipSvc.Create(models.Membership{
+		UserID: user.ID,
+		ShopID: shopID,
+		Role:   role,
+	}); err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
-func (s *UserServiceImpl) Login(email, password string) (*models.User, string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Find user by email
-	var user *models.User
-	for i := range s.users {
-		if s.users[i].Email == email {
-			user = &s.users[i]
-			break
-		}
+func (s *UserServiceImpl) Login(email, password, ip string) (*models.User, string, error) {
+	// Refuse early while the account or IP is backing off
+	if err := s.loginAttempts.Check(email, ip); err != nil {
+		metrics.RecordLogin(metrics.LoginThrottled)
+		return nil, "", err
 	}
 
-	if user == nil {
-		return nil, "", errors.New("invalid credentials")
+	// Find user by email
+	user, err := s.GetByEmail(email)
+	if err != nil {
+		// Burn the same bcrypt time as a real check so response times don't
+		// reveal which emails exist
+		utils.CheckPassword(s.dummyHash, password)
+		s.loginAttempts.RecordFailure(email, ip)
+		metrics.RecordLogin(metrics.LoginFailed)
+		slog.Warn("login failed", "reason", "unknown email", "email", email, "client_ip", ip)
+		return nil, "", newError(ErrInvalidCredentials, "invalid credentials")
 	}
 
 	// Check password
 	if err := utils.CheckPassword(user.Password, password); err != nil {
-		return nil, "", errors.New("invalid credentials")
+		s.loginAttempts.RecordFailure(email, ip)
+		metrics.RecordLogin(metrics.LoginFailed)
+		slog.Warn("login failed", "reason", "wrong password", "user_id", user.ID, "client_ip", ip)
+		return nil, "", newError(ErrInvalidCredentials, "invalid credentials")
+	}
+	s.loginAttempts.RecordSuccess(email, ip)
+
+	// Transparently move old hashes to the configured bcrypt cost
+	if utils.NeedsRehash(user.Password) {
+		s.rehashPassword(user.ID, user.Password, password)
+	}
+
+	if !user.Active {
+		metrics.RecordLogin(metrics.LoginFailed)
+		slog.Warn("login failed", "reason", "account deactivated", "user_id", user.ID, "client_ip", ip)
+		return nil, "", newError(ErrAccountDisabled, "account is deactivated")
+	}
+
+	// The session token is only issued after the second factor
+	if user.TOTPEnabled {
+		metrics.RecordLogin(metrics.LoginTwoFactorRequired)
+		slog.Info("login awaiting second factor", "user_id", user.ID, "client_ip", ip)
+		return user, "", ErrTwoFactorRequired
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(user)
+	membership, err := s.defaultMembership(user)
 	if err != nil {
 		return nil, "", err
 	}
 
+	// Generate token scoped to the default shop
+	token, err := utils.GenerateToken(user, membership, false)
+	if err != nil {
+		return nil, "", err
+	}
+	metrics.RecordLogin(metrics.LoginSucceeded)
+	slog.Info("login succeeded", "user_id", user.ID, "shop_id", membership.ShopID, "client_ip", ip)
+
 	return user, token, nil
 }
 
-func (s *UserServiceImpl) GetByShopID(shopID int) []models.User {
+// rehashPassword replaces a user's hash with one at the configured cost, unless
+// the password was changed in the meantime
+func (s *UserServiceImpl) rehashPassword(id int, oldHash, password string) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id && s.users[i].Password == oldHash {
+			s.users[i].Password = hashedPassword
+			return
+		}
+	}
+}
+
+// defaultMembership returns the membership for the user's home shop, falling back
+// to the first shop the user has access to
+func (s *UserServiceImpl) defaultMembership(user *models.User) (*models.Membership, error) {
+	if membership, err := s.membershipSvc.Get(user.ID, user.ShopID); err == nil && membership.Active {
+		return membership, nil
+	}
+
+	for _, membership := range s.membershipSvc.GetByUserID(user.ID) {
+		if membership.Active {
+			return &membership, nil
+		}
+	}
+	return nil, newError(ErrForbiddenTenant, "user has no shop membership")
+}
+
+// SwitchShop issues a new token scoped to another shop the user is a member of.
+// twoFactor carries over whether the current session passed a second factor.
+func (s *UserServiceImpl) SwitchShop(userID, shopID int, twoFactor bool) (*models.User, *models.Membership, string, error) {
+	user, err := s.GetByID(userID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	membership, err := s.membershipSvc.Get(userID, shopID)
+	if err != nil || !membership.Active {
+		return nil, nil, "", newError(ErrForbiddenTenant, "user is not a member of this shop")
+	}
+
+	token, err := utils.GenerateToken(user, membership, twoFactor)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return user, membership, token, nil
+}
+
+func (s *UserServiceImpl) GetByID(id int) (*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var users []models.User
 	for _, user := range s.users {
-		if user.ShopID == shopID {
-			users = append(users, user)
+		if user.ID == id {
+			return &user, nil
 		}
 	}
-	return users
+	return nil, newError(ErrNotFound, "user not found")
 }
 
-func (s *UserServiceImpl) GetByID(id int) (*models.User, error) {
+func (s *UserServiceImpl) GetByEmail(email string) (*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	for _, user := range s.users {
-		if user.ID == id {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, newError(ErrNotFound, "user not found")
+}
+
+// Update changes a user's profile; a non-zero expectedVersion makes it conditional
+func (s *UserServiceImpl) Update(id int, name, email string, expectedVersion int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Email must stay unique
+	for _, user := range s.users {
+		if user.Email == email && user.ID != id {
+			return nil, newError(ErrConflict, "email already exists")
+		}
+	}
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			if err := checkVersion("user", s.users[i].Version, expectedVersion); err != nil {
+				return nil, err
+			}
+			s.users[i].Name = name
+			s.users[i].Email = email
+			s.users[i].Version++
+			user := s.users[i]
 			return &user, nil
 		}
 	}
-	return nil, errors.New("user not found")
+	return nil, newError(ErrNotFound, "user not found")
+}
+
+func (s *UserServiceImpl) SetActive(id int, active bool, expectedVersion int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			if err := checkVersion("user", s.users[i].Version, expectedVersion); err != nil {
+				return err
+			}
+			s.users[i].Active = active
+			s.users[i].Version++
+			slog.Info("account activation changed", "user_id", id, "active", active)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "user not found")
+}
+
+// ChangePassword replaces the password after checking the current one, and
+// revokes the user's tokens
+func (s *UserServiceImpl) ChangePassword(id int, oldPassword, newPassword string) error {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	// bcrypt runs outside the lock, which the auth middleware needs on every request
+	if err := utils.CheckPassword(user.Password, oldPassword); err != nil {
+		return newError(ErrInvalidInput, "current password is incorrect")
+	}
+	if err := utils.ValidatePassword(newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := s.replacePassword(id, user.Password, hashedPassword); err != nil {
+		return err
+	}
+	slog.Info("password changed", "user_id", id)
+	return nil
+}
+
+// replacePassword swaps a user's hash unless it changed since oldHash was
+// read, and revokes the tokens issued with the old password
+func (s *UserServiceImpl) replacePassword(id int, oldHash, newHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			if s.users[i].Password != oldHash {
+				return newError(ErrConflict, "password was changed concurrently, try again")
+			}
+			s.users[i].Password = newHash
+			s.users[i].SessionVersion++
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "user not found")
+}
+
+// CreatePasswordReset issues a single-use reset token; only its hash is kept
+func (s *UserServiceImpl) CreatePasswordReset(id int) (string, error) {
+	if _, err := s.GetByID(id); err != nil {
+		return "", err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.passwordResets[utils.HashToken(token)] = passwordReset{
+		UserID:    id,
+		ExpiresAt: time.Now().Add(config.PasswordResetExpiration),
+	}
+	slog.Info("password reset issued", "user_id", id)
+
+	return token, nil
+}
+
+// ResetPassword redeems a reset token, then revokes the user's tokens
+func (s *UserServiceImpl) ResetPassword(token, newPassword string) error {
+	key := utils.HashToken(token)
+
+	s.mu.Lock()
+	reset, ok := s.passwordResets[key]
+	if ok && time.Now().After(reset.ExpiresAt) {
+		delete(s.passwordResets, key)
+		ok = false
+	}
+	s.mu.Unlock()
+	if !ok {
+		return newError(ErrInvalidInput, "invalid or expired reset token")
+	}
+
+	user, err := s.GetByID(reset.UserID)
+	if err != nil {
+		return err
+	}
+
+	// A rejected password leaves the token usable for another try
+	if err := utils.ValidatePassword(newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// The token is single-use: only the first of concurrent redemptions finds it
+	s.mu.Lock()
+	_, ok = s.passwordResets[key]
+	delete(s.passwordResets, key)
+	s.mu.Unlock()
+	if !ok {
+		return newError(ErrInvalidInput, "invalid or expired reset token")
+	}
+
+	if err := s.replacePassword(reset.UserID, user.Password, hashedPassword); err != nil {
+		return err
+	}
+	slog.Info("password reset", "user_id", reset.UserID)
+	return nil
+}
+
+// Unlock clears the failed login attempts of a user's account
+func (s *UserServiceImpl) Unlock(id int) error {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	s.loginAttempts.Unlock(user.Email)
+	slog.Info("account unlocked", "user_id", id)
+	return nil
+}
+
+// CompleteTwoFactorLogin finishes a login started with the password, using a
+// TOTP code or an unused recovery code
+func (s *UserServiceImpl) CompleteTwoFactorLogin(userID int, code, ip string) (*models.User, string, error) {
+	user, err := s.GetByID(userID)
+	if err != nil {
+		return nil, "", newError(ErrInvalidCredentials, "invalid credentials")
+	}
+
+	// Codes are throttled like passwords, on the same account key
+	if err := s.loginAttempts.Check(user.Email, ip); err != nil {
+		metrics.RecordLogin(metrics.LoginThrottled)
+		return nil, "", err
+	}
+
+	if !user.Active || !user.TOTPEnabled {
+		return nil, "", newError(ErrInvalidCredentials, "invalid credentials")
+	}
+
+	if !s.verifySecondFactor(userID, code) {
+		s.loginAttempts.RecordFailure(user.Email, ip)
+		metrics.RecordLogin(metrics.LoginFailed)
+		slog.Warn("login failed", "reason", "wrong second factor", "user_id", userID, "client_ip", ip)
+		return nil, "", newError(ErrInvalidInput, "invalid two-factor code")
+	}
+	s.loginAttempts.RecordSuccess(user.Email, ip)
+
+	membership, err := s.defaultMembership(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := utils.GenerateToken(user, membership, true)
+	if err != nil {
+		return nil, "", err
+	}
+	metrics.RecordLogin(metrics.LoginSucceeded)
+	slog.Info("login succeeded", "user_id", user.ID, "shop_id", membership.ShopID, "client_ip", ip, "two_factor", true)
+
+	return user, token, nil
+}
+
+// verifySecondFactor accepts a TOTP code not used before, or consumes a recovery code
+func (s *UserServiceImpl) verifySecondFactor(userID int, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID != userID {
+			continue
+		}
+		user := &s.users[i]
+
+		if step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+			if step <= user.TOTPLastStep {
+				return false
+			}
+			user.TOTPLastStep = step
+			return true
+		}
+
+		hashed := utils.HashToken(strings.ToLower(strings.TrimSpace(code)))
+		for j, recoveryCode := range user.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(hashed)) == 1 {
+				user.RecoveryCodes = append(user.RecoveryCodes[:j:j], user.RecoveryCodes[j+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// BeginTOTPEnrollment stores a new pending secret and returns it with its
+// provisioning URI. The secret only takes effect once EnableTOTP confirms a code.
+func (s *UserServiceImpl) BeginTOTPEnrollment(id int) (string, string, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			if s.users[i].TOTPEnabled {
+				return "", "", newError(ErrConflict, "two-factor authentication is already enabled")
+			}
+			s.users[i].TOTPSecret = secret
+			uri := utils.TOTPProvisioningURI(config.TOTPIssuer, s.users[i].Email, secret)
+			return secret, uri, nil
+		}
+	}
+	return "", "", newError(ErrNotFound, "user not found")
+}
+
+// EnableTOTP confirms enrollment with a first valid code and returns fresh recovery codes
+func (s *UserServiceImpl) EnableTOTP(id int, code string) ([]string, error) {
+	recoveryCodes, err := utils.GenerateRecoveryCodes(config.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			user := &s.users[i]
+			if user.TOTPEnabled {
+				return nil, newError(ErrConflict, "two-factor authentication is already enabled")
+			}
+			if user.TOTPSecret == "" {
+				return nil, newError(ErrConflict, "two-factor setup has not been started")
+			}
+
+			step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+			if !ok {
+				return nil, newError(ErrInvalidInput, "invalid two-factor code")
+			}
+
+			user.TOTPEnabled = true
+			user.TOTPLastStep = step
+			user.Version++ // two_factor_enabled is part of the representation
+			user.RecoveryCodes = make([]string, len(recoveryCodes))
+			for j, recoveryCode := range recoveryCodes {
+				user.RecoveryCodes[j] = utils.HashToken(recoveryCode)
+			}
+			slog.Info("two-factor authentication enabled", "user_id", id)
+			return recoveryCodes, nil
+		}
+	}
+	return nil, newError(ErrNotFound, "user not found")
+}
+
+// DisableTOTP turns two-factor authentication off; both the password and a
+// current code are required
+func (s *UserServiceImpl) DisableTOTP(id int, password, code string) error {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return newError(ErrConflict, "two-factor authentication is not enabled")
+	}
+
+	if err := utils.CheckPassword(user.Password, password); err != nil {
+		return newError(ErrInvalidInput, "password is incorrect")
+	}
+	if !s.verifySecondFactor(id, code) {
+		return newError(ErrInvalidInput, "invalid two-factor code")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == id {
+			s.users[i].TOTPEnabled = false
+			s.users[i].TOTPSecret = ""
+			s.users[i].TOTPLastStep = 0
+			s.users[i].RecoveryCodes = nil
+			s.users[i].Version++
+			slog.Info("two-factor authentication disabled", "user_id", id)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, "user not found")
+}
+
+// CreateExternal provisions a user authenticated by an external identity
+// provider. The account gets a random password, so it can only sign in through
+// the provider unless an admin issues a password reset.
+func (s *UserServiceImpl) CreateExternal(name, email string, shopID int, role models.Role) (*models.User, error) {
+	randomPassword, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(randomPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return nil, newError(ErrConflict, "email already exists")
+		}
+	}
+
+	user := models.User{
+		ID:        s.nextID,
+		Name:      name,
+		Email:     email,
+		Password:  hashedPassword,
+		Role:      role,
+		ShopID:    shopID,
+		Active:    true,
+		Version:   1,
+		CreatedAt: time.Now(),
+	}
+
+	s.nextID++
+	s.users = append(s.users, user)
+
+	if _, err := s.membershipSvc.Create(models.Membership{
+		UserID: user.ID,
+		ShopID: shopID,
+		Role:   role,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// IssueToken creates a session token for a user authenticated elsewhere (SSO)
+func (s *UserServiceImpl) IssueToken(id int, twoFactor bool) (*models.User, string, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !user.Active {
+		return nil, "", newError(ErrAccountDisabled, "account is deactivated")
+	}
+
+	// Signing in elsewhere does not skip the account's own second factor
+	if user.TOTPEnabled {
+		slog.Info("single sign-on awaiting second factor", "user_id", user.ID)
+		return user, "", ErrTwoFactorRequired
+	}
+
+	membership, err := s.defaultMembership(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := utils.GenerateToken(user, membership, twoFactor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+func (s *UserServiceImpl) Ping(ctx context.Context) error {
+	return pingStore(ctx, &s.mu)
 }
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"shop-api/config"
+	"shop-api/models"
+	"shop-api/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// withoutLoginBackoff lets a test fail logins without waiting between attempts
+func withoutLoginBackoff(t *testing.T) {
+	previous := config.LoginBackoffBase
+	config.LoginBackoffBase = 0
+	t.Cleanup(func() { config.LoginBackoffBase = previous })
+}
+
+// enableTOTP turns on TOTP for a user and returns its secret, the step of
+// the code that confirmed it, and the recovery codes
+func enableTOTP(t *testing.T, userSvc UserService, userID int) (string, int64, []string) {
+	t.Helper()
+
+	secret, _, err := userSvc.BeginTOTPEnrollment(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := utils.TOTPStep(time.Now())
+	code, err := utils.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := userSvc.EnableTOTP(userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, step, recoveryCodes
+}
+
+func TestTwoFactorLoginRejectsReplayedCodes(t *testing.T) {
+	withoutLoginBackoff(t)
+	userSvc := NewUserService(NewMembershipService(), NewLoginAttemptService())
+	secret, step, _ := enableTOTP(t, userSvc, 1)
+
+	// The code that confirmed the enrollment is already used
+	enrollCode, _ := utils.TOTPCode(secret, step)
+	if _, _, err := userSvc.CompleteTwoFactorLogin(1, enrollCode, "192.0.2.1"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("enrollment code replayed: err = %v, want ErrInvalidInput", err)
+	}
+
+	code, _ := utils.TOTPCode(secret, step+1)
+	if _, token, err := userSvc.CompleteTwoFactorLogin(1, code, "192.0.2.1"); err != nil || token == "" {
+		t.Fatalf("fresh code refused: %v", err)
+	}
+	if _, _, err := userSvc.CompleteTwoFactorLogin(1, code, "192.0.2.1"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("code replayed: err = %v, want ErrInvalidInput", err)
+	}
+
+	user, err := userSvc.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPLastStep != step+1 {
+		t.Errorf("TOTPLastStep = %d, want %d", user.TOTPLastStep, step+1)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	withoutLoginBackoff(t)
+	userSvc := NewUserService(NewMembershipService(), NewLoginAttemptService())
+	_, _, recoveryCodes := enableTOTP(t, userSvc, 1)
+
+	// Typed in upper case with spaces around, as read from a printout
+	if _, token, err := userSvc.CompleteTwoFactorLogin(1, "  "+strings.ToUpper(recoveryCodes[0])+" ", "192.0.2.1"); err != nil || token == "" {
+		t.Fatalf("recovery code refused: %v", err)
+	}
+	if _, _, err := userSvc.CompleteTwoFactorLogin(1, recoveryCodes[0], "192.0.2.1"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("recovery code used twice: err = %v, want ErrInvalidInput", err)
+	}
+
+	// The others still work
+	if _, _, err := userSvc.CompleteTwoFactorLogin(1, recoveryCodes[1], "192.0.2.1"); err != nil {
+		t.Fatalf("second recovery code refused: %v", err)
+	}
+	user, err := userSvc.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.RecoveryCodes) != len(recoveryCodes)-2 {
+		t.Errorf("%d recovery codes left, want %d", len(user.RecoveryCodes), len(recoveryCodes)-2)
+	}
+}
+
+func TestLoginRehashesPasswordsBelowTheConfiguredCost(t *testing.T) {
+	withoutLoginBackoff(t)
+	previous := config.PasswordBcryptCost
+	t.Cleanup(func() { config.PasswordBcryptCost = previous })
+
+	// Seed users are hashed at the cost in force when the store is created
+	config.PasswordBcryptCost = bcrypt.MinCost
+	userSvc := NewUserService(NewMembershipService(), NewLoginAttemptService())
+	config.PasswordBcryptCost = bcrypt.MinCost + 1
+
+	cost := func() int {
+		t.Helper()
+		user, err := userSvc.GetByID(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cost, err := bcrypt.Cost([]byte(user.Password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cost
+	}
+
+	// A failed login leaves the hash alone
+	if _, _, err := userSvc.Login("admin@shop1.com", "wrong password", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if got := cost(); got != bcrypt.MinCost {
+		t.Fatalf("cost = %d after a failed login, want %d", got, bcrypt.MinCost)
+	}
+
+	if _, _, err := userSvc.Login("admin@shop1.com", "admin123", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cost(); got != bcrypt.MinCost+1 {
+		t.Fatalf("cost = %d after login, want %d", got, bcrypt.MinCost+1)
+	}
+	// The new hash still matches the password
+	if _, _, err := userSvc.Login("admin@shop1.com", "admin123", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterEnforcesThePasswordPolicy(t *testing.T) {
+	userSvc := NewUserService(NewMembershipService(), NewLoginAttemptService())
+
+	_, err := userSvc.Register("Jane", "jane@shop1.com", "password", models.RoleAdmin, 1)
+	var policyErr *utils.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("err = %v, want a *PasswordPolicyError", err)
+	}
+	if _, err := userSvc.GetByEmail("jane@shop1.com"); err == nil {
+		t.Error("user created with a rejected password")
+	}
+}
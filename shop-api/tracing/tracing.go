@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer goes through the global provider, so spans started before Setup are no-ops
+var tracer = otel.Tracer("shop-api")
+
+// Setup installs the W3C trace-context propagator and a tracer provider
+// sending spans to exporter: "none", "otlp" (OTLP over HTTP, configured by
+// the standard OTEL_EXPORTER_OTLP_* variables) or "stdout" (one JSON document
+// per span, for tests). The returned function flushes pending spans.
+func Setup(ctx context.Context, exporter, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var processor sdktrace.SpanProcessor
+	switch strings.ToLower(exporter) {
+	case "none", "":
+		// Incoming trace context is still propagated to the request's logs
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		client, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewBatchSpanProcessor(client)
+	case "stdout":
+		client, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(client)
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q, expected none, otlp or stdout", exporter)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartServer begins the span of an incoming request, continuing the trace
+// its traceparent header belongs to
+func StartServer(ctx context.Context, carrier propagation.TextMapCarrier, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// Inject returns the trace context of ctx, to carry it with asynchronous
+// work such as events. It is empty outside a traced request.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// StartConsumer begins the span of an event delivery, continuing the trace it
+// was published in. Events published outside a trace get a no-op span.
+func StartConsumer(ctx context.Context, carrier map[string]string, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+}
+
+// Start begins a span for a service method. Outside a traced request it
+// returns a no-op span, so background work like metric scrapes adds no traces.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Storage begins a span for an operation ("select", "insert", "update" or
+// "delete") on one of the in-memory collections
+func Storage(ctx context.Context, collection, operation string) (context.Context, trace.Span) {
+	return Start(ctx, operation+" "+collection,
+		semconv.DBSystemNameKey.String("memory"),
+		semconv.DBCollectionName(collection),
+		semconv.DBOperationName(operation),
+	)
+}
+
+// Error records err on span and returns it
+func Error(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
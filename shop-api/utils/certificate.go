@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader serves a TLS certificate from files, and loads them
+// again when they change, so renewed certificates apply without a restart.
+// The files are checked during handshakes, at most once per interval.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	checked     time.Time
+}
+
+// NewCertificateReloader loads the certificate, failing if the files are unusable
+func NewCertificateReloader(certFile, keyFile string, interval time.Duration) (*CertificateReloader, error) {
+	c := &CertificateReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	modTime, err := c.modTimeOfFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (c *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.Sub(c.checked) >= c.interval {
+		c.checked = now
+		// A pair caught mid-renewal fails to load; the current one is kept until both files match
+		if modTime, err := c.modTimeOfFiles(); err == nil && !modTime.Equal(c.modTime) {
+			if err := c.load(modTime); err != nil {
+				slog.Warn("TLS certificate reload failed, keeping the current one", "cert_file", c.certFile, "error", err)
+			} else {
+				slog.Info("TLS certificate reloaded", "cert_file", c.certFile)
+			}
+		}
+	}
+	return c.certificate, nil
+}
+
+func (c *CertificateReloader) load(modTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.certificate = &certificate
+	c.modTime = modTime
+	return nil
+}
+
+// modTimeOfFiles returns the latest modification time of the two files
+func (c *CertificateReloader) modTimeOfFiles() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -4,26 +4,42 @@ import (
 	"errors"
 	"shop-api/config"
 	"shop-api/models"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
-	UserID int         `json:"user_id"`
-	Email  string      `json:"email"`
-	Role   models.Role `json:"role"`
-	ShopID int         `json:"shop_id"`
+	UserID    int         `json:"user_id"`
+	Email     string      `json:"email"`
+	Role      models.Role `json:"role"`
+	ShopID    int         `json:"shop_id"`
+	TwoFactor bool        `json:"two_factor,omitempty"` // Signed in with a second factor
+
+	// User.SessionVersion when the token was issued; older tokens are revoked
+	SessionVersion int `json:"session_version,omitempty"`
+
+	// Set by the middleware when the shop requires a second factor the token lacks
+	TwoFactorRequired bool `json:"-"`
+	// Set by the middleware when the request is authenticated with an API key
+	APIKeyID int `json:"-"`
+
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT token for a user
-func GenerateToken(user *models.User) (string, error) {
+// twoFactorAudience marks tokens that only allow completing a two-factor login
+const twoFactorAudience = "2fa-challenge"
+
+// GenerateToken creates a JWT token for a user, scoped to the shop and role of the given membership
+func GenerateToken(user *models.User, membership *models.Membership, twoFactor bool) (string, error) {
 	claims := Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
-		ShopID: user.ShopID,
+		UserID:         user.ID,
+		Email:          user.Email,
+		Role:           membership.Role,
+		ShopID:         membership.ShopID,
+		TwoFactor:      twoFactor,
+		SessionVersion: user.SessionVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.JWTExpiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -48,8 +64,44 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		// Two-factor challenges are not session tokens
+		for _, audience := range claims.Audience {
+			if audience == twoFactorAudience {
+				return nil, errors.New("invalid token")
+			}
+		}
 		return claims, nil
 	}
 
 	return nil, errors.New("invalid token")
 }
+
+// GenerateTwoFactorChallenge creates a short-lived token proving the password step
+// succeeded, to be exchanged for a session token with a TOTP or recovery code
+func GenerateTwoFactorChallenge(user *models.User) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(user.ID),
+		Audience:  jwt.ClaimStrings{twoFactorAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.TwoFactorChallengeExpiration)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(config.JWTSecret)
+}
+
+// ValidateTwoFactorChallenge validates a challenge token and returns the user ID
+func ValidateTwoFactorChallenge(tokenString string) (int, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return config.JWTSecret, nil
+	}, jwt.WithAudience(twoFactorAudience))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(claims.Subject)
+}
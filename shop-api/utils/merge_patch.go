@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to a JSON document:
+// members of the patch replace those of the document, null removes them,
+// and nested objects are merged recursively.
+func MergePatch(document, patch []byte) ([]byte, error) {
+	var target, changes any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+	if _, ok := changes.(map[string]any); !ok {
+		return nil, errors.New("merge patch must be a JSON object")
+	}
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = map[string]any{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = mergeValue(object[key], value)
+	}
+	return object
+}
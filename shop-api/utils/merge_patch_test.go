@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396 Appendix A whose patch is an object
+	tests := []struct {
+		document, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.document), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", tt.document, tt.patch, err)
+			continue
+		}
+		var gotValue, wantValue any
+		json.Unmarshal(got, &gotValue)
+		json.Unmarshal([]byte(tt.want), &wantValue)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("%s + %s = %s, want %s", tt.document, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchRejectsNonObjectPatches(t *testing.T) {
+	for _, patch := range []string{`["a"]`, `null`, `"text"`, `{`} {
+		if _, err := MergePatch([]byte(`{"a":"b"}`), []byte(patch)); err == nil {
+			t.Errorf("patch %s accepted", patch)
+		}
+	}
+}
@@ -1,10 +1,38 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"bufio"
+	_ "embed"
+	"shop-api/config"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the bundled offline list of common and breached passwords
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(file string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
 
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), config.PasswordBcryptCost)
 	return string(bytes), err
 }
 
@@ -12,3 +40,74 @@ func HashPassword(password string) (string, error) {
 func CheckPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+// NeedsRehash reports whether a hash was made with a lower cost than configured
+func NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err == nil && cost < config.PasswordBcryptCost
+}
+
+// PasswordPolicyError lists every rule a password breaks
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Problems, ", ")
+}
+
+// ValidatePassword checks a password against the configured policy and the
+// common password list. email and name must not be reused as the password.
+func ValidatePassword(password, email, name string) error {
+	var problems []string
+
+	length := len([]rune(password))
+	if length < config.PasswordMinLength {
+		problems = append(problems, "must be at least "+strconv.Itoa(config.PasswordMinLength)+" characters")
+	}
+	if len(password) > config.PasswordMaxLength {
+		problems = append(problems, "must be at most "+strconv.Itoa(config.PasswordMaxLength)+" bytes")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	if config.PasswordRequireUpper && !hasUpper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if config.PasswordRequireLower && !hasLower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if config.PasswordRequireDigit && !hasDigit {
+		problems = append(problems, "must contain a digit")
+	}
+	if config.PasswordRequireSymbol && !hasSymbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if lowered == strings.ToLower(email) || (localPart != "" && lowered == localPart) ||
+		(name != "" && lowered == strings.ToLower(name)) {
+		problems = append(problems, "must not be your email or name")
+	}
+
+	if _, common := commonPasswords[lowered]; common {
+		problems = append(problems, "is too common or has appeared in a data breach")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
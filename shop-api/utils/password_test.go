@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"shop-api/config"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		problems []string // nil when accepted
+	}{
+		{"strong", "Correct7Horse", nil},
+		{"unicode letters count as characters", "Élégant9é", nil},
+		{"too short", "Ab1", []string{"must be at least 8 characters"}},
+		{"too long for bcrypt", "Aa1" + strings.Repeat("x", 70), []string{"must be at most 72 bytes"}},
+		{"no uppercase", "lowercase9", []string{"must contain an uppercase letter"}},
+		{"no lowercase", "UPPERCASE9", []string{"must contain a lowercase letter"}},
+		{"no digit", "NoDigitsHere", []string{"must contain a digit"}},
+		{"email local part", "Jane.Doe1", []string{"must not be your email or name"}},
+		{"common", "Password1", []string{"is too common or has appeared in a data breach"}},
+		{"every problem at once", "abc", []string{"must be at least 8 characters", "must contain an uppercase letter", "must contain a digit"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, "jane.doe1@shop1.com", "Jane")
+
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("err = %v, want a *PasswordPolicyError", err)
+			}
+			if !slices.Equal(policyErr.Problems, tt.problems) {
+				t.Errorf("problems = %q, want %q", policyErr.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestCommonPasswordsIgnoreCase(t *testing.T) {
+	previous := config.PasswordRequireUpper
+	config.PasswordRequireUpper = false
+	t.Cleanup(func() { config.PasswordRequireUpper = previous })
+
+	for _, password := range []string{"password1", "PASSWORD1"} {
+		var policyErr *PasswordPolicyError
+		if err := ValidatePassword(password, "", ""); !errors.As(err, &policyErr) || !slices.Contains(policyErr.Problems, "is too common or has appeared in a data breach") {
+			t.Errorf("%s: err = %v, want it reported as common", password, err)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	previous := config.PasswordBcryptCost
+	t.Cleanup(func() { config.PasswordBcryptCost = previous })
+
+	config.PasswordBcryptCost = bcrypt.MinCost
+	hash, err := HashPassword("Correct7Horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(hash) {
+		t.Error("a hash at the configured cost needs a rehash")
+	}
+
+	config.PasswordBcryptCost = bcrypt.MinCost + 1
+	if !NeedsRehash(hash) {
+		t.Error("a hash below the configured cost does not need a rehash")
+	}
+	if NeedsRehash("not a bcrypt hash") {
+		t.Error("an unreadable hash needs a rehash")
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// Appendix B lists 8-digit codes; 6-digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("T=%d: code = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeRejectsInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Fatal("invalid secret accepted")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+
+	tests := []struct {
+		offset int64 // steps from the current one
+		valid  bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := ValidateTOTP(rfc6238Secret, code, now)
+		if ok != tt.valid {
+			t.Errorf("offset %d: valid = %v, want %v", tt.offset, ok, tt.valid)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("offset %d: matched step %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateTOTPIgnoresSpaces(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := ValidateTOTP(rfc6238Secret, "287 082", now); !ok {
+		t.Error("code typed with a space was rejected")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, "", now); ok {
+		t.Error("empty code accepted")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+}
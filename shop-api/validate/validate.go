@@ -0,0 +1,171 @@
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"shop-api/problem"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Struct checks a struct against the rules in its `validate` tags and
+// reports every violation at once. Fields are named after their json tag.
+//
+// Rules, separated by commas:
+//
+//	required      the value must not be empty (zero, blank string, nil, empty slice)
+//	min=N, max=N  bounds on a number, or on the length of a string or slice
+//	email         a bare e-mail address
+//	url           an absolute http(s) URL
+//	oneof=A B C   one of the listed values
+//
+// Apart from required, rules are skipped for empty values so optional
+// fields only need to be valid when they are present.
+func Struct(v any) []problem.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs []problem.FieldError
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		errs = append(errs, checkField(FieldName(field), value.Field(i), strings.Split(tag, ","))...)
+	}
+	return errs
+}
+
+// FieldName returns the name a struct field has in JSON
+func FieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func checkField(name string, value reflect.Value, rules []string) []problem.FieldError {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			if hasRule(rules, "required") {
+				return []problem.FieldError{fieldError(name, "required", "is required")}
+			}
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if isEmpty(value) {
+		if hasRule(rules, "required") {
+			return []problem.FieldError{fieldError(name, "required", "is required")}
+		}
+		return nil
+	}
+
+	var errs []problem.FieldError
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(rule, "=")
+		if message, ok := check(rule, arg, value); !ok {
+			errs = append(errs, fieldError(name, rule, message))
+		}
+	}
+	return errs
+}
+
+// check applies one rule to a non-empty value and returns a message when it fails
+func check(rule, arg string, value reflect.Value) (string, bool) {
+	switch rule {
+	case "required":
+		return "", true
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic("validate: bad " + rule + " argument " + strconv.Quote(arg))
+		}
+		n, unit := measure(value)
+		bound := "at least"
+		if rule == "max" {
+			bound = "at most"
+		}
+		if (rule == "min" && n < limit) || (rule == "max" && n > limit) {
+			switch unit {
+			case "characters":
+				return "must be " + bound + " " + arg + " characters long", false
+			case "items":
+				return "must contain " + bound + " " + arg + " items", false
+			}
+			return "must be " + bound + " " + arg, false
+		}
+		return "", true
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be a valid email address", false
+		}
+		return "", true
+	case "url":
+		u, err := url.Parse(value.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "must be an absolute http or https URL", false
+		}
+		return "", true
+	case "oneof":
+		options := strings.Fields(arg)
+		current := fmt.Sprint(value.Interface())
+		for _, option := range options {
+			if current == option {
+				return "", true
+			}
+		}
+		return "must be one of " + strings.Join(options, ", "), false
+	}
+	panic("validate: unknown rule " + strconv.Quote(rule))
+}
+
+// measure returns a number's value, or the length of a string or slice and its unit
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), "characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	panic("validate: min/max on unsupported kind " + value.Kind().String())
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldError(field, code, message string) problem.FieldError {
+	return problem.FieldError{Field: field, Code: code, Message: message}
+}
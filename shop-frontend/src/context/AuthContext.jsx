@@ -28,22 +28,52 @@ export const AuthProvider = ({ children }) => {
     setLoading(false)
   }, [])
 
+  const startSession = (user, token) => {
+    localStorage.setItem('token', token)
+    localStorage.setItem('user', JSON.stringify(user))
+
+    setToken(token)
+    setUser(user)
+  }
+
   const login = async (email, password) => {
     try {
       const response = await authAPI.login(email, password)
+
+      // Accounts with two-factor authentication get a challenge instead of a token
+      if (response.data.two_factor_required) {
+        return {
+          success: false,
+          twoFactorRequired: true,
+          challengeToken: response.data.challenge_token,
+        }
+      }
+
       const { user, token } = response.data
+      startSession(user, token)
 
-      localStorage.setItem('token', token)
-      localStorage.setItem('user', JSON.stringify(user))
+      return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.detail || 'Login failed'
+      }
+    }
+  }
 
-      setToken(token)
-      setUser(user)
+  const loginTwoFactor = async (challengeToken, code) => {
+    try {
+      const response = await authAPI.loginTwoFactor(challengeToken, code)
+      const { user, token } = response.data
+      startSession(user, token)
 
       return { success: true }
     } catch (error) {
       return {
         success: false,
-        error: error.response?.data?.error || 'Login failed'
+        // An expired challenge means starting over with the password
+        challengeExpired: error.response?.data?.code === 'unauthorized',
+        error: error.response?.data?.detail || 'Verification failed'
       }
     }
   }
@@ -55,7 +85,7 @@ export const AuthProvider = ({ children }) => {
     } catch (error) {
       return {
         success: false,
-        error: error.response?.data?.error || 'Registration failed'
+        error: error.response?.data?.detail || 'Registration failed'
       }
     }
   }
@@ -75,6 +105,7 @@ export const AuthProvider = ({ children }) => {
     token,
     loading,
     login,
+    loginTwoFactor,
     register,
     logout,
     isSuperAdmin,
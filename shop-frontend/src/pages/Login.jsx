@@ -10,8 +10,10 @@ const Login = () => {
   const [emailError, setEmailError] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
+  const [challengeToken, setChallengeToken] = useState('')
+  const [code, setCode] = useState('')
 
-  const { login } = useAuth()
+  const { login, loginTwoFactor } = useAuth()
   const navigate = useNavigate()
   const [showPassword, setShowPassword] = useState(false)
 
@@ -47,6 +49,8 @@ const Login = () => {
 
     if (result.success) {
       navigate('/dashboard')
+    } else if (result.twoFactorRequired) {
+      setChallengeToken(result.challengeToken)
     } else {
       setError(result.error)
     }
@@ -54,6 +58,75 @@ const Login = () => {
     setLoading(false)
   }
 
+  const handleCodeSubmit = async (e) => {
+    e.preventDefault()
+    setError('')
+    setLoading(true)
+
+    const result = await loginTwoFactor(challengeToken, code.trim())
+
+    if (result.success) {
+      navigate('/dashboard')
+    } else {
+      if (result.challengeExpired) {
+        restart()
+      }
+      setError(result.error)
+    }
+
+    setLoading(false)
+  }
+
+  const restart = () => {
+    setChallengeToken('')
+    setCode('')
+    setPassword('')
+  }
+
+  if (challengeToken) {
+    return (
+      <div>
+        <Navbar />
+
+        <div className="auth-container">
+          <div className="auth-card">
+            <h2>Two-Factor Verification</h2>
+
+            {error && <div className="alert alert-error">{error}</div>}
+
+            <form onSubmit={handleCodeSubmit}>
+              <div className="form-group">
+                <label>Authentication code</label>
+                <input
+                  type="text"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  required
+                  autoFocus
+                  autoComplete="one-time-code"
+                  placeholder="123456"
+                />
+                <small>Enter the code from your authenticator app, or one of your recovery codes.</small>
+              </div>
+
+              <button
+                type="submit"
+                className="btn btn-primary btn-full"
+                disabled={loading || !code.trim()}
+              >
+                {loading ? 'Verifying...' : 'Verify'}
+              </button>
+            </form>
+
+            <p className="auth-link">
+              <a href="#" onClick={(e) => { e.preventDefault(); setError(''); restart() }}>Back to login</a>
+            </p>
+          </div>
+        </div>
+      </div>
+    )
+  }
+
   return (
     <div>
       <Navbar />
@@ -42,16 +42,19 @@ const Products = () => {
       name: formData.name,
       description: formData.description,
       category: formData.category,
-      purchase_price: Number(formData.purchase_price),
       selling_price: Number(formData.selling_price),
       stock: Number(formData.stock),
       image_url: formData.image_url
     }
+    // Only a SuperAdmin may set the purchase price
+    if (isSuperAdmin()) {
+      payload.purchase_price = Number(formData.purchase_price)
+    }
 
     console.log("📦 Product payload:", payload)
 
     if (editingProduct) {
-      await productsAPI.update(editingProduct.id, payload)
+      await productsAPI.update(editingProduct.id, payload, editingProduct.version)
     } else {
       await productsAPI.create(payload)
     }
@@ -62,7 +65,7 @@ const Products = () => {
 
   } catch (error) {
     console.error("❌ Full error:", error.response)
-    alert(error.response?.data?.error || 'Error saving product')
+    alert(error.response?.data?.detail || 'Error saving product')
   }
 }
 
@@ -72,7 +75,7 @@ const Products = () => {
       await productsAPI.delete(id)
       loadProducts()
     } catch (error) {
-      alert(error.response?.data?.error || 'Error deleting product')
+      alert(error.response?.data?.detail || 'Error deleting product')
     }
   }
 
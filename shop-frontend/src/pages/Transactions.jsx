@@ -1,4 +1,4 @@
-import { useState, useEffect } from 'react'
+import { useState, useEffect, useRef } from 'react'
 import Navbar from '../components/Navbar'
 import { transactionsAPI, productsAPI } from '../services/api'
 import './Transactions.css'
@@ -7,6 +7,9 @@ const Transactions = () => {
   const [transactions, setTransactions] = useState([])
   const [products, setProducts] = useState([])
   const [showModal, setShowModal] = useState(false)
+  // Resubmitting the same data reuses its Idempotency-Key, so a retry after a
+  // timeout can't record the transaction twice
+  const lastAttempt = useRef(null)
   const [formData, setFormData] = useState({
     type: '',
     product_id: '',
@@ -53,14 +56,19 @@ const Transactions = () => {
 
     console.log("📦 Payload being sent:", data)
 
-    await transactionsAPI.create(data)
+    const body = JSON.stringify(data)
+    if (lastAttempt.current?.body !== body) {
+      lastAttempt.current = { body, key: crypto.randomUUID() }
+    }
+    await transactionsAPI.create(data, lastAttempt.current.key)
+    lastAttempt.current = null
 
     setShowModal(false)
     resetForm()
     loadTransactions()
   } catch (error) {
     console.error(" Full error response:", error.response)
-    alert(error.response?.data?.error || 'Error creating transaction')
+    alert(error.response?.data?.detail || 'Error creating transaction')
   }
 }
 
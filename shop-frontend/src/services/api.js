@@ -1,6 +1,6 @@
 import axios from 'axios'
 
-const API_BASE_URL = 'http://localhost:8081'
+const API_BASE_URL = 'http://localhost:8081/api/v1'
 
 const api = axios.create({
   baseURL: API_BASE_URL,
@@ -21,6 +21,8 @@ api.interceptors.request.use((config) => {
 // Auth API
 export const authAPI = {
   login: (email, password) => api.post('/login', { email, password }),
+  // Second step for accounts with two-factor authentication: TOTP or recovery code
+  loginTwoFactor: (challengeToken, code) => api.post('/login/2fa', { challenge_token: challengeToken, code }),
   register: (data) => api.post('/register', data),
 }
 
@@ -34,14 +36,22 @@ export const publicAPI = {
 export const productsAPI = {
   getAll: () => api.get('/products'),
   create: (data) => api.post('/products', data),
-  update: (id, data) => api.put(`/products/${id}`, data),
+  // version makes the update conditional (If-Match): it fails with 412 if someone else saved first
+  update: (id, data, version) => api.put(`/products/${id}`, data, {
+    headers: version ? { 'If-Match': `"${version}"` } : {},
+  }),
+  patch: (id, changes) => api.patch(`/products/${id}`, changes, {
+    headers: { 'Content-Type': 'application/merge-patch+json' },
+  }),
   delete: (id) => api.delete(`/products/${id}`),
 }
 
 // Transactions API
 export const transactionsAPI = {
   getAll: () => api.get('/transactions'),
-  create: (data) => api.post('/transactions', data),
+  create: (data, idempotencyKey) => api.post('/transactions', data, {
+    headers: idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {},
+  }),
 }
 
 // Dashboard API